@@ -0,0 +1,482 @@
+package main
+
+import (
+	"context"
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"html/template"
+	"log"
+	"math"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"highloadtest/storage"
+)
+
+// session_YYYY-MM-DD_HH-MM-SS[_runRUN][_instID]_RAND, см. generateSessionID
+var sessionIDPattern = regexp.MustCompile(`^session_(\d{4}-\d{2}-\d{2}_\d{2}-\d{2}-\d{2})(?:_run([^_]+))?(?:_inst([^_]+))?_([0-9a-f]+)$`)
+
+const unknownGroup = "unknown"
+
+// Коды причин, которые aggregate ставит сам; сессии без кода (старые отчеты) идут в reasonUnspecified
+const (
+	reasonMissingReport    = "missing_report"
+	reasonUnreadableReport = "unreadable_report"
+	reasonUnspecified      = "unspecified"
+)
+
+type SessionIDParts struct {
+	StartedAt  time.Time
+	RunID      string
+	InstanceID string
+	Random     string
+}
+
+func parseSessionID(id string) (SessionIDParts, bool) {
+	m := sessionIDPattern.FindStringSubmatch(id)
+	if m == nil {
+		return SessionIDParts{}, false
+	}
+	started, err := time.ParseInLocation("2006-01-02_15-04-05", m[1], time.Local)
+	if err != nil {
+		return SessionIDParts{}, false
+	}
+	return SessionIDParts{StartedAt: started, RunID: m[2], InstanceID: m[3], Random: m[4]}, true
+}
+
+// aggregatedSession - то, что удалось узнать о сессии из ее отчета и имени папки
+type aggregatedSession struct {
+	SessionID        string    `json:"session_id"`
+	RunID            string    `json:"run_id"`
+	InstanceID       string    `json:"instance_id"`
+	GPU              string    `json:"gpu"`
+	StartedAt        time.Time `json:"started_at"`
+	DurationSeconds  float64   `json:"duration_seconds"`
+	Status           string    `json:"status"`
+	StatusCode       string    `json:"status_code,omitempty"`
+	StatusReason     string    `json:"status_reason,omitempty"`
+	Screenshots      int       `json:"screenshots"`
+	TimeToFirstFrame *float64  `json:"time_to_first_frame_seconds,omitempty"`
+}
+
+type GroupSummary struct {
+	Key                    string         `json:"key"`
+	Sessions               int            `json:"sessions"`
+	StatusCounts           map[string]int `json:"status_counts"`
+	SuccessRate            float64        `json:"success_rate"`
+	MedianTimeToFirstFrame *float64       `json:"median_time_to_first_frame_seconds,omitempty"`
+	P95TimeToFirstFrame    *float64       `json:"p95_time_to_first_frame_seconds,omitempty"`
+	// FailureReasons - число неуспешных сессий по коду причины (status_code)
+	FailureReasons map[string]int `json:"failure_reasons"`
+}
+
+type AggregateSummary struct {
+	GeneratedAt time.Time                 `json:"generated_at"`
+	Source      string                    `json:"source"`
+	Overall     GroupSummary              `json:"overall"`
+	Groups      map[string][]GroupSummary `json:"groups"`
+	Sessions    []aggregatedSession       `json:"sessions"`
+}
+
+func runAggregate(args []string) error {
+	fs := flag.NewFlagSet("aggregate", flag.ExitOnError)
+	cfgFlags := registerConfigFlags(fs)
+	dir := fs.String("dir", "", "local directory with session folders (overrides configured storage)")
+	prefix := fs.String("prefix", "session_", "only sessions whose ID starts with this prefix")
+	groupBy := fs.String("group-by", "run,instance,gpu", "comma-separated grouping keys: run, instance, gpu")
+	outDir := fs.String("out", "./aggregate", "directory for summary.json, summary.csv, sessions.csv and index.html")
+	fs.Parse(args)
+
+	groups := splitList(*groupBy)
+	for _, g := range groups {
+		if g != "run" && g != "instance" && g != "gpu" {
+			return fmt.Errorf("unknown group-by key %q (expected run, instance or gpu)", g)
+		}
+	}
+
+	ctx := context.Background()
+	var store storage.ArtifactStore
+	var err error
+	if *dir != "" {
+		store, err = storage.NewLocalStore(storage.LocalConfig{Dir: *dir})
+	} else {
+		cfg, cfgErr := cfgFlags.resolve(fs)
+		if cfgErr != nil {
+			return cfgErr
+		}
+		store, err = storage.New(ctx, cfg.Storage)
+	}
+	if err != nil {
+		return err
+	}
+	defer store.Close()
+
+	log.Printf("Collecting sessions from %s...", store)
+	sessions, err := collectSessions(ctx, store, *prefix)
+	if err != nil {
+		return err
+	}
+	log.Printf("Found %d sessions", len(sessions))
+
+	summary := AggregateSummary{
+		GeneratedAt: time.Now(),
+		Source:      store.String(),
+		Overall:     summarize("all", sessions),
+		Groups:      make(map[string][]GroupSummary),
+		Sessions:    sessions,
+	}
+	for _, g := range groups {
+		summary.Groups[g] = summarizeBy(sessions, g)
+	}
+
+	if err := os.MkdirAll(*outDir, 0755); err != nil {
+		return err
+	}
+	if err := writeSummaryJSON(filepath.Join(*outDir, "summary.json"), summary); err != nil {
+		return err
+	}
+	if err := writeSummaryCSV(filepath.Join(*outDir, "summary.csv"), summary); err != nil {
+		return err
+	}
+	if err := writeSessionsCSV(filepath.Join(*outDir, "sessions.csv"), sessions); err != nil {
+		return err
+	}
+	if err := writeSummaryHTML(filepath.Join(*outDir, "index.html"), summary); err != nil {
+		return err
+	}
+
+	log.Printf("Success rate: %.1f%% (%d sessions), median TTFF %s, p95 %s",
+		summary.Overall.SuccessRate*100, summary.Overall.Sessions,
+		formatSeconds(summary.Overall.MedianTimeToFirstFrame), formatSeconds(summary.Overall.P95TimeToFirstFrame))
+	log.Printf("Summary written to %s", *outDir)
+	return nil
+}
+
+func collectSessions(ctx context.Context, store storage.ArtifactStore, prefix string) ([]aggregatedSession, error) {
+	objects, err := store.List(ctx, prefix)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list %s: %v", store, err)
+	}
+
+	// Ключи вида <session_id>/<file>: собираем папки сессий
+	folders := make(map[string]bool)
+	for _, obj := range objects {
+		folder, _, ok := strings.Cut(obj.Key, "/")
+		if !ok || !strings.HasPrefix(folder, "session_") {
+			continue
+		}
+		folders[folder] = true
+	}
+
+	var sessions []aggregatedSession
+	for folder := range folders {
+		s := aggregatedSession{SessionID: folder, Status: StatusFailed}
+		if parts, ok := parseSessionID(folder); ok {
+			s.StartedAt = parts.StartedAt
+			s.RunID = parts.RunID
+			s.InstanceID = parts.InstanceID
+		}
+
+		data, err := storage.GetBytes(ctx, store, folder+"/"+reportJSONName)
+		switch {
+		case errors.Is(err, storage.ErrNotFound):
+			s.StatusCode = reasonMissingReport
+			s.StatusReason = "missing session report"
+		case err != nil:
+			return nil, fmt.Errorf("failed to read report of %s: %v", folder, err)
+		default:
+			if err := json.Unmarshal(data, &s); err != nil {
+				s.Status = StatusFailed
+				s.StatusCode = reasonUnreadableReport
+				s.StatusReason = "unreadable session report"
+			}
+			var counts struct {
+				Screenshots []json.RawMessage `json:"screenshots"`
+			}
+			if json.Unmarshal(data, &counts) == nil {
+				s.Screenshots = len(counts.Screenshots)
+			}
+		}
+		sessions = append(sessions, s)
+	}
+
+	sort.Slice(sessions, func(i, j int) bool {
+		if !sessions[i].StartedAt.Equal(sessions[j].StartedAt) {
+			return sessions[i].StartedAt.Before(sessions[j].StartedAt)
+		}
+		return sessions[i].SessionID < sessions[j].SessionID
+	})
+	return sessions, nil
+}
+
+// UnmarshalJSON читает отчет сессии, не затирая данные, полученные из имени папки
+func (s *aggregatedSession) UnmarshalJSON(data []byte) error {
+	var r struct {
+		RunID            string    `json:"run_id"`
+		InstanceID       string    `json:"instance_id"`
+		GPU              string    `json:"gpu"`
+		StartedAt        time.Time `json:"started_at"`
+		DurationSeconds  float64   `json:"duration_seconds"`
+		Status           string    `json:"status"`
+		StatusCode       string    `json:"status_code"`
+		StatusReason     string    `json:"status_reason"`
+		TimeToFirstFrame *float64  `json:"time_to_first_frame_seconds"`
+	}
+	if err := json.Unmarshal(data, &r); err != nil {
+		return err
+	}
+	if r.RunID != "" {
+		s.RunID = r.RunID
+	}
+	if r.InstanceID != "" {
+		s.InstanceID = r.InstanceID
+	}
+	s.GPU = r.GPU
+	if !r.StartedAt.IsZero() {
+		s.StartedAt = r.StartedAt
+	}
+	s.DurationSeconds = r.DurationSeconds
+	s.Status = r.Status
+	s.StatusCode = r.StatusCode
+	s.StatusReason = r.StatusReason
+	s.TimeToFirstFrame = r.TimeToFirstFrame
+	return nil
+}
+
+func groupKey(s aggregatedSession, by string) string {
+	var key string
+	switch by {
+	case "run":
+		key = s.RunID
+	case "instance":
+		key = s.InstanceID
+	case "gpu":
+		key = s.GPU
+	}
+	if key == "" {
+		return unknownGroup
+	}
+	return key
+}
+
+func summarizeBy(sessions []aggregatedSession, by string) []GroupSummary {
+	buckets := make(map[string][]aggregatedSession)
+	for _, s := range sessions {
+		key := groupKey(s, by)
+		buckets[key] = append(buckets[key], s)
+	}
+
+	summaries := make([]GroupSummary, 0, len(buckets))
+	for key, group := range buckets {
+		summaries = append(summaries, summarize(key, group))
+	}
+	sort.Slice(summaries, func(i, j int) bool { return summaries[i].Key < summaries[j].Key })
+	return summaries
+}
+
+func summarize(key string, sessions []aggregatedSession) GroupSummary {
+	g := GroupSummary{
+		Key:            key,
+		Sessions:       len(sessions),
+		StatusCounts:   make(map[string]int),
+		FailureReasons: make(map[string]int),
+	}
+
+	var ttff []float64
+	for _, s := range sessions {
+		g.StatusCounts[s.Status]++
+		if s.Status != StatusSuccess {
+			// Группируем по коду: в тексте причины числа, свои у каждой сессии
+			code := s.StatusCode
+			if code == "" {
+				code = reasonUnspecified
+			}
+			g.FailureReasons[code]++
+		}
+		if s.TimeToFirstFrame != nil {
+			ttff = append(ttff, *s.TimeToFirstFrame)
+		}
+	}
+
+	if len(sessions) > 0 {
+		g.SuccessRate = float64(g.StatusCounts[StatusSuccess]) / float64(len(sessions))
+	}
+	g.MedianTimeToFirstFrame = percentile(ttff, 50)
+	g.P95TimeToFirstFrame = percentile(ttff, 95)
+	return g
+}
+
+// percentile по методу ближайшего ранга; nil - значений нет
+func percentile(values []float64, p float64) *float64 {
+	if len(values) == 0 {
+		return nil
+	}
+	sorted := append([]float64(nil), values...)
+	sort.Float64s(sorted)
+	rank := int(math.Ceil(p / 100 * float64(len(sorted))))
+	if rank < 1 {
+		rank = 1
+	}
+	return &sorted[rank-1]
+}
+
+// formatSeconds - секунды для CSV и лога; пустая строка для отсутствующего значения
+func formatSeconds(v *float64) string {
+	if v == nil {
+		return ""
+	}
+	return strconv.FormatFloat(*v, 'f', 2, 64)
+}
+
+func writeSummaryJSON(path string, summary AggregateSummary) error {
+	data, err := json.MarshalIndent(summary, "", "  ")
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(path, data, 0644)
+}
+
+func writeCSV(path string, rows [][]string) error {
+	f, err := os.Create(path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	w := csv.NewWriter(f)
+	if err := w.WriteAll(rows); err != nil {
+		return err
+	}
+	return f.Close()
+}
+
+func writeSummaryCSV(path string, summary AggregateSummary) error {
+	rows := [][]string{{"group_by", "key", "sessions", "success", "degraded", "failed", "aborted", "success_rate", "median_ttff_s", "p95_ttff_s"}}
+	addRow := func(by string, g GroupSummary) {
+		rows = append(rows, []string{
+			by, g.Key, strconv.Itoa(g.Sessions),
+			strconv.Itoa(g.StatusCounts[StatusSuccess]), strconv.Itoa(g.StatusCounts[StatusDegraded]),
+			strconv.Itoa(g.StatusCounts[StatusFailed]), strconv.Itoa(g.StatusCounts[StatusAborted]),
+			strconv.FormatFloat(g.SuccessRate, 'f', 4, 64),
+			formatSeconds(g.MedianTimeToFirstFrame),
+			formatSeconds(g.P95TimeToFirstFrame),
+		})
+	}
+	addRow("all", summary.Overall)
+	for _, by := range sortedKeys(summary.Groups) {
+		for _, g := range summary.Groups[by] {
+			addRow(by, g)
+		}
+	}
+	return writeCSV(path, rows)
+}
+
+func writeSessionsCSV(path string, sessions []aggregatedSession) error {
+	rows := [][]string{{"session_id", "run_id", "instance_id", "gpu", "started_at", "duration_s", "status", "status_code", "status_reason", "screenshots", "ttff_s"}}
+	for _, s := range sessions {
+		started := ""
+		if !s.StartedAt.IsZero() {
+			started = s.StartedAt.Format(time.RFC3339)
+		}
+		rows = append(rows, []string{
+			s.SessionID, s.RunID, s.InstanceID, s.GPU, started,
+			strconv.FormatFloat(s.DurationSeconds, 'f', 1, 64),
+			s.Status, s.StatusCode, s.StatusReason, strconv.Itoa(s.Screenshots),
+			formatSeconds(s.TimeToFirstFrame),
+		})
+	}
+	return writeCSV(path, rows)
+}
+
+// secondsCell - секунды для HTML; прочерк для отсутствующего значения
+func secondsCell(v *float64) string {
+	if v == nil {
+		return "-"
+	}
+	return fmt.Sprintf("%.1fs", *v)
+}
+
+func sortedKeys[V any](m map[string]V) []string {
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+var summaryTemplate = template.Must(template.New("summary").Funcs(template.FuncMap{
+	"pct":        func(v float64) string { return fmt.Sprintf("%.1f%%", v*100) },
+	"secs":       secondsCell,
+	"sortedKeys": sortedKeys[int],
+	"time": func(t time.Time) string {
+		if t.IsZero() {
+			return ""
+		}
+		return t.Format("2006-01-02 15:04:05")
+	},
+}).Parse(`<!DOCTYPE html>
+<html>
+<head>
+<meta charset="utf-8">
+<title>High Load Test summary</title>
+<style>
+body { font-family: sans-serif; margin: 2em; }
+table { border-collapse: collapse; margin-bottom: 2em; }
+th, td { border: 1px solid #ccc; padding: 4px 8px; text-align: left; }
+th { background: #f0f0f0; }
+.success { color: #2a7d2a; } .degraded { color: #b8860b; } .failed, .aborted { color: #b22222; }
+</style>
+</head>
+<body>
+<h1>High Load Test summary</h1>
+<p>Source: {{.Source}}<br>Generated: {{time .GeneratedAt}}</p>
+
+<h2>Overall</h2>
+<table>
+<tr><th>Sessions</th><th>Success rate</th><th>Median TTFF</th><th>p95 TTFF</th>{{range sortedKeys .Overall.StatusCounts}}<th>{{.}}</th>{{end}}</tr>
+<tr><td>{{.Overall.Sessions}}</td><td>{{pct .Overall.SuccessRate}}</td><td>{{secs .Overall.MedianTimeToFirstFrame}}</td><td>{{secs .Overall.P95TimeToFirstFrame}}</td>{{range $k := sortedKeys .Overall.StatusCounts}}<td>{{index $.Overall.StatusCounts $k}}</td>{{end}}</tr>
+</table>
+
+<h2>Failure reasons</h2>
+<table>
+<tr><th>Reason</th><th>Sessions</th></tr>
+{{range $k := sortedKeys .Overall.FailureReasons}}<tr><td>{{$k}}</td><td>{{index $.Overall.FailureReasons $k}}</td></tr>
+{{end}}</table>
+
+{{range $by, $groups := .Groups}}
+<h2>By {{$by}}</h2>
+<table>
+<tr><th>{{$by}}</th><th>Sessions</th><th>Success rate</th><th>Median TTFF</th><th>p95 TTFF</th></tr>
+{{range $groups}}<tr><td>{{.Key}}</td><td>{{.Sessions}}</td><td>{{pct .SuccessRate}}</td><td>{{secs .MedianTimeToFirstFrame}}</td><td>{{secs .P95TimeToFirstFrame}}</td></tr>
+{{end}}</table>
+{{end}}
+
+<h2>Sessions</h2>
+<table>
+<tr><th>Session</th><th>Run</th><th>Instance</th><th>GPU</th><th>Started</th><th>Status</th><th>Reason</th><th>Screenshots</th><th>TTFF</th></tr>
+{{range .Sessions}}<tr><td>{{.SessionID}}</td><td>{{.RunID}}</td><td>{{.InstanceID}}</td><td>{{.GPU}}</td><td>{{time .StartedAt}}</td><td class="{{.Status}}">{{.Status}}</td><td>{{.StatusReason}}</td><td>{{.Screenshots}}</td><td>{{secs .TimeToFirstFrame}}</td></tr>
+{{end}}</table>
+</body>
+</html>
+`))
+
+func writeSummaryHTML(path string, summary AggregateSummary) error {
+	f, err := os.Create(path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	if err := summaryTemplate.Execute(f, summary); err != nil {
+		return err
+	}
+	return f.Close()
+}
@@ -0,0 +1,340 @@
+package main
+
+import (
+	"bytes"
+	"flag"
+	"fmt"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"gopkg.in/yaml.v3"
+
+	"highloadtest/runnerconfig"
+	"highloadtest/storage"
+)
+
+type SessionConfig struct {
+	URL                string              `yaml:"url" json:"url"`
+	Duration           time.Duration       `yaml:"duration" json:"duration"`
+	ScreenshotInterval time.Duration       `yaml:"screenshot_interval" json:"screenshot_interval"`
+	StatsInterval      time.Duration       `yaml:"stats_interval" json:"stats_interval"`
+	Frames             FrameConfig         `yaml:"frames" json:"frames"`
+	Headless           bool                `yaml:"headless" json:"headless"`
+	ChromePath         string              `yaml:"chrome_path" json:"chrome_path"`
+	BrowserArgs        []string            `yaml:"browser_args" json:"browser_args"`
+	ConsentSelector    string              `yaml:"consent_selector" json:"consent_selector"`
+	ConsentTimeout     time.Duration       `yaml:"consent_timeout" json:"consent_timeout"`
+	StepTimeout        time.Duration       `yaml:"step_timeout" json:"step_timeout"`
+	ShutdownGrace      time.Duration       `yaml:"shutdown_grace" json:"shutdown_grace"`
+	ScenarioFile       string              `yaml:"scenario_file" json:"scenario_file"`
+	Scenario           []ScenarioStep      `yaml:"scenario" json:"scenario"`
+	Storage            storage.Config      `yaml:"storage" json:"storage"`
+	Upload             storage.QueueConfig `yaml:"upload" json:"upload"`
+	Heartbeat          HeartbeatConfig     `yaml:"heartbeat" json:"heartbeat"`
+}
+
+func defaultSessionConfig() SessionConfig {
+	return SessionConfig{
+		URL:                runnerconfig.DefaultURL,
+		Duration:           runnerconfig.DefaultDuration,
+		ScreenshotInterval: runnerconfig.DefaultScreenshotInterval,
+		StatsInterval:      5 * time.Second,
+		Frames:             defaultFrameConfig(),
+		BrowserArgs: []string{
+			"--password-store=basic",
+			"--no-first-run",
+			"--disable-features=AccountConsistency",
+			"--disable-signin-promo",
+			"--start-maximized",      // Запускаем на весь экран
+			"--disable-web-security", // Отключаем ограничения безопасности
+		},
+		ConsentSelector: "#accept-button",
+		ConsentTimeout:  30 * time.Second,
+		StepTimeout:     30 * time.Second,
+		ShutdownGrace:   30 * time.Second,
+		Storage: storage.Config{
+			Backend: storage.BackendLocal,
+			Local:   storage.LocalConfig{Dir: "./artifacts"},
+			SFTP:    storage.SFTPConfig{Port: 22, Dir: "files"},
+		},
+		Upload:    storage.DefaultQueueConfig(),
+		Heartbeat: HeartbeatConfig{Interval: 15 * time.Second},
+	}
+}
+
+// loadConfigFile читает YAML или JSON (JSON является подмножеством YAML)
+func loadConfigFile(path string, cfg *SessionConfig) error {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return fmt.Errorf("failed to read config %s: %v", path, err)
+	}
+
+	decoder := yaml.NewDecoder(bytes.NewReader(data))
+	decoder.KnownFields(true)
+	if err := decoder.Decode(cfg); err != nil {
+		return fmt.Errorf("failed to parse config %s: %v", filepath.Base(path), err)
+	}
+	return nil
+}
+
+// applyEnv переопределяет значения из переменных окружения HLT_*
+func applyEnv(cfg *SessionConfig) error {
+	if v := os.Getenv("HLT_URL"); v != "" {
+		cfg.URL = v
+	}
+	if v := os.Getenv("HLT_DURATION"); v != "" {
+		d, err := time.ParseDuration(v)
+		if err != nil {
+			return fmt.Errorf("invalid HLT_DURATION %q: %v", v, err)
+		}
+		cfg.Duration = d
+	}
+	if v := os.Getenv("HLT_SCREENSHOT_INTERVAL"); v != "" {
+		d, err := time.ParseDuration(v)
+		if err != nil {
+			return fmt.Errorf("invalid HLT_SCREENSHOT_INTERVAL %q: %v", v, err)
+		}
+		cfg.ScreenshotInterval = d
+	}
+	if v := os.Getenv("HLT_STATS_INTERVAL"); v != "" {
+		d, err := time.ParseDuration(v)
+		if err != nil {
+			return fmt.Errorf("invalid HLT_STATS_INTERVAL %q: %v", v, err)
+		}
+		cfg.StatsInterval = d
+	}
+	if v := os.Getenv("HLT_SHUTDOWN_GRACE"); v != "" {
+		d, err := time.ParseDuration(v)
+		if err != nil {
+			return fmt.Errorf("invalid HLT_SHUTDOWN_GRACE %q: %v", v, err)
+		}
+		cfg.ShutdownGrace = d
+	}
+	if v := os.Getenv("HLT_HEADLESS"); v != "" {
+		cfg.Headless = v == "1" || strings.EqualFold(v, "true")
+	}
+	if v := os.Getenv("HLT_CHROME_PATH"); v != "" {
+		cfg.ChromePath = v
+	}
+	if v := os.Getenv("HLT_BROWSER_ARGS"); v != "" {
+		cfg.BrowserArgs = splitList(v)
+	}
+	if v := os.Getenv("HLT_CONSENT_SELECTOR"); v != "" {
+		cfg.ConsentSelector = v
+	}
+	if v := os.Getenv("HLT_SCENARIO"); v != "" {
+		cfg.ScenarioFile = v
+	}
+	if v := os.Getenv("HLT_ARTIFACTS_DIR"); v != "" {
+		cfg.Storage.Local.Dir = v
+	}
+	if v := os.Getenv("HLT_STORAGE_BACKEND"); v != "" {
+		cfg.Storage.Backend = v
+	}
+	if v := os.Getenv("HLT_STORAGE_HOST"); v != "" {
+		cfg.Storage.SFTP.Host = v
+	}
+	if v := os.Getenv("HLT_STORAGE_USER"); v != "" {
+		cfg.Storage.SFTP.User = v
+	}
+	if v := os.Getenv("HLT_STORAGE_PASSWORD"); v != "" {
+		cfg.Storage.SFTP.Password = v
+	}
+	if v := os.Getenv("HLT_S3_ENDPOINT"); v != "" {
+		cfg.Storage.S3.Endpoint = v
+	}
+	if v := os.Getenv("HLT_S3_BUCKET"); v != "" {
+		cfg.Storage.S3.Bucket = v
+	}
+	if v := os.Getenv("HLT_S3_ACCESS_KEY"); v != "" {
+		cfg.Storage.S3.AccessKey = v
+	}
+	if v := os.Getenv("HLT_S3_SECRET_KEY"); v != "" {
+		cfg.Storage.S3.SecretKey = v
+	}
+	if v := os.Getenv("HLT_HTTP_URL"); v != "" {
+		cfg.Storage.HTTP.URL = v
+	}
+	if v := os.Getenv("HLT_HTTP_TOKEN"); v != "" {
+		cfg.Storage.HTTP.Token = v
+	}
+	if v := os.Getenv("HLT_HEARTBEAT_URL"); v != "" {
+		cfg.Heartbeat.URL = v
+	}
+	if v := os.Getenv("HLT_HEARTBEAT_TOKEN"); v != "" {
+		cfg.Heartbeat.Token = v
+	}
+	if v := os.Getenv("HLT_HEARTBEAT_INTERVAL"); v != "" {
+		d, err := time.ParseDuration(v)
+		if err != nil {
+			return fmt.Errorf("invalid HLT_HEARTBEAT_INTERVAL %q: %v", v, err)
+		}
+		cfg.Heartbeat.Interval = d
+	}
+	return nil
+}
+
+func splitList(s string) []string {
+	var out []string
+	for _, part := range strings.Split(s, ",") {
+		if part = strings.TrimSpace(part); part != "" {
+			out = append(out, part)
+		}
+	}
+	return out
+}
+
+func (c *SessionConfig) Validate() error {
+	var problems []string
+
+	u, err := url.Parse(c.URL)
+	if c.URL == "" {
+		problems = append(problems, "url is required")
+	} else if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		problems = append(problems, fmt.Sprintf("url %q must be an absolute http(s) URL", c.URL))
+	}
+	if c.Duration <= 0 {
+		problems = append(problems, "duration must be positive")
+	}
+	if c.ScreenshotInterval <= 0 {
+		problems = append(problems, "screenshot_interval must be positive")
+	} else if c.ScreenshotInterval > c.Duration && c.Duration > 0 {
+		problems = append(problems, fmt.Sprintf("screenshot_interval %v is longer than duration %v", c.ScreenshotInterval, c.Duration))
+	}
+	if c.StatsInterval < 0 {
+		problems = append(problems, "stats_interval must not be negative (0 disables WebRTC stats)")
+	}
+	if c.ConsentSelector != "" && c.ConsentTimeout <= 0 {
+		problems = append(problems, "consent_timeout must be positive when consent_selector is set")
+	}
+	if c.StepTimeout <= 0 {
+		problems = append(problems, "step_timeout must be positive")
+	}
+	if c.ShutdownGrace < 2*time.Second {
+		problems = append(problems, "shutdown_grace must be at least 2s")
+	}
+	problems = append(problems, c.Frames.Validate()...)
+	problems = append(problems, validateScenario(c.Scenario, "")...)
+	if err := c.Storage.Validate(); err != nil {
+		problems = append(problems, err.Error())
+	}
+	if c.Storage.Backend != storage.BackendLocal && c.Storage.Local.Dir == "" {
+		problems = append(problems, "storage.local.dir is required as a fallback")
+	}
+	if err := c.Upload.Validate(); err != nil {
+		problems = append(problems, err.Error())
+	}
+	if c.Heartbeat.URL != "" {
+		hu, err := url.Parse(c.Heartbeat.URL)
+		if err != nil || (hu.Scheme != "http" && hu.Scheme != "https") || hu.Host == "" {
+			problems = append(problems, fmt.Sprintf("heartbeat.url %q must be an absolute http(s) URL", c.Heartbeat.URL))
+		}
+		if c.Heartbeat.Interval <= 0 {
+			problems = append(problems, "heartbeat.interval must be positive")
+		}
+	}
+
+	if len(problems) > 0 {
+		return fmt.Errorf("invalid session config:\n  - %s", strings.Join(problems, "\n  - "))
+	}
+	return nil
+}
+
+type configFlags struct {
+	configPath         *string
+	url                *string
+	duration           *time.Duration
+	screenshotInterval *time.Duration
+	statsInterval      *time.Duration
+	shutdownGrace      *time.Duration
+	headless           *bool
+	chromePath         *string
+	consentSelector    *string
+	scenarioPath       *string
+	artifactsDir       *string
+	storageBackend     *string
+	heartbeatURL       *string
+}
+
+func registerConfigFlags(fs *flag.FlagSet) *configFlags {
+	return &configFlags{
+		configPath:         fs.String("config", "", "path to session config (YAML or JSON)"),
+		url:                fs.String("url", "", "target URL to open"),
+		duration:           fs.Duration("duration", 0, "session duration (e.g. 5m)"),
+		screenshotInterval: fs.Duration("screenshot-interval", 0, "interval between screenshots (e.g. 15s)"),
+		statsInterval:      fs.Duration("stats-interval", 0, "interval between WebRTC stats polls, 0 disables (e.g. 5s)"),
+		shutdownGrace:      fs.Duration("shutdown-grace", 0, "time allowed to save results after SIGINT/SIGTERM"),
+		headless:           fs.Bool("headless", false, "run browser without GUI"),
+		chromePath:         fs.String("chrome-path", "", "path to Google Chrome executable"),
+		consentSelector:    fs.String("consent-selector", "", "CSS selector of the consent button"),
+		scenarioPath:       fs.String("scenario", "", "path to scenario file (YAML or JSON list of steps)"),
+		artifactsDir:       fs.String("artifacts-dir", "", "local directory for artifacts"),
+		storageBackend:     fs.String("storage", "", "artifact storage backend: local, sftp, s3 or http"),
+		heartbeatURL:       fs.String("heartbeat-url", "", "orchestrator URL to POST session heartbeats to"),
+	}
+}
+
+// resolve собирает конфиг: значения по умолчанию < файл < окружение < флаги
+func (f *configFlags) resolve(fs *flag.FlagSet) (SessionConfig, error) {
+	cfg := defaultSessionConfig()
+
+	path := *f.configPath
+	if path == "" {
+		path = os.Getenv("HLT_CONFIG")
+	}
+	if path != "" {
+		if err := loadConfigFile(path, &cfg); err != nil {
+			return cfg, err
+		}
+	}
+
+	if err := applyEnv(&cfg); err != nil {
+		return cfg, err
+	}
+
+	fs.Visit(func(fl *flag.Flag) {
+		switch fl.Name {
+		case "url":
+			cfg.URL = *f.url
+		case "duration":
+			cfg.Duration = *f.duration
+		case "screenshot-interval":
+			cfg.ScreenshotInterval = *f.screenshotInterval
+		case "stats-interval":
+			cfg.StatsInterval = *f.statsInterval
+		case "shutdown-grace":
+			cfg.ShutdownGrace = *f.shutdownGrace
+		case "headless":
+			cfg.Headless = *f.headless
+		case "chrome-path":
+			cfg.ChromePath = *f.chromePath
+		case "consent-selector":
+			cfg.ConsentSelector = *f.consentSelector
+		case "scenario":
+			cfg.ScenarioFile = *f.scenarioPath
+		case "artifacts-dir":
+			cfg.Storage.Local.Dir = *f.artifactsDir
+		case "storage":
+			cfg.Storage.Backend = *f.storageBackend
+		case "heartbeat-url":
+			cfg.Heartbeat.URL = *f.heartbeatURL
+		}
+	})
+
+	// Шаги из отдельного файла заменяют встроенные, без шагов используем сценарий по умолчанию
+	if cfg.ScenarioFile != "" {
+		steps, err := loadScenarioFile(cfg.ScenarioFile)
+		if err != nil {
+			return cfg, err
+		}
+		cfg.Scenario = steps
+	}
+	if len(cfg.Scenario) == 0 {
+		cfg.Scenario = defaultScenario(cfg)
+	}
+
+	return cfg, cfg.Validate()
+}
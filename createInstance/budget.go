@@ -0,0 +1,211 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"log"
+	"time"
+)
+
+// Budget - ограничения расходов запуска; нулевой лимит не проверяется
+type Budget struct {
+	MaxHourly     float64
+	MaxRunCost    float64
+	SessionLength time.Duration
+}
+
+// RunBudget - лимиты и прогноз, сохраненные в манифесте, чтобы их видели ожидание, auto-destroy и --resume
+type RunBudget struct {
+	MaxHourlyUSD       float64    `json:"max_hourly_usd,omitempty"`
+	MaxRunCostUSD      float64    `json:"max_run_cost_usd,omitempty"`
+	SessionLength      string     `json:"session_length"`
+	ProjectedHourlyUSD float64    `json:"projected_hourly_usd"`
+	ProjectedCostUSD   float64    `json:"projected_cost_usd"`
+	ExhaustedAt        *time.Time `json:"exhausted_at,omitempty"`
+}
+
+func addBudgetFlags(fs *flag.FlagSet) *Budget {
+	b := &Budget{}
+	fs.Float64Var(&b.MaxHourly, "max-total-hourly", 0, "maximum summed hourly price of the pool in USD (0: no limit)")
+	fs.Float64Var(&b.MaxRunCost, "max-run-cost", 0, "maximum cost of the whole run in USD; instances are destroyed when it is reached (0: no limit)")
+	fs.DurationVar(&b.SessionLength, "session-length", 30*time.Minute, "expected instance lifetime used to project the run cost")
+	return b
+}
+
+func (b *Budget) Validate() error {
+	if b.MaxHourly < 0 || b.MaxRunCost < 0 {
+		return fmt.Errorf("budgets must not be negative")
+	}
+	if b.SessionLength <= 0 {
+		return fmt.Errorf("-session-length must be positive")
+	}
+	return nil
+}
+
+// Select берет по порядку до count предложений, которые укладываются в бюджет. Предложение,
+// которое не влезает, пропускается: более дешевое из следующих еще может поместиться
+func (b *Budget) Select(offers []Offer, count int) []Offer {
+	var selected []Offer
+	hourly := 0.0
+	skipped := 0
+	for _, offer := range offers {
+		if len(selected) == count {
+			break
+		}
+		next := hourly + offer.DPHTotal
+		if !b.fits(next) {
+			skipped++
+			continue
+		}
+		hourly = next
+		selected = append(selected, offer)
+	}
+	if skipped > 0 && len(selected) < count {
+		fmt.Printf("Budget allows %d of %d instances ($%.4f/hour, projected $%.4f over %v)\n",
+			len(selected), count, hourly, hourly*b.SessionLength.Hours(), b.SessionLength)
+	}
+	return selected
+}
+
+// fits проверяет, что пул с такой суммарной почасовой ценой укладывается в бюджет
+func (b *Budget) fits(hourly float64) bool {
+	if b.MaxHourly > 0 && hourly > b.MaxHourly {
+		return false
+	}
+	return b.MaxRunCost <= 0 || hourly*b.SessionLength.Hours() <= b.MaxRunCost
+}
+
+// Projection возвращает прогноз расходов для выбранных предложений
+func (b *Budget) Projection(offers []Offer) *RunBudget {
+	hourly := 0.0
+	for _, offer := range offers {
+		hourly += offer.DPHTotal
+	}
+	return &RunBudget{
+		MaxHourlyUSD:       b.MaxHourly,
+		MaxRunCostUSD:      b.MaxRunCost,
+		SessionLength:      b.SessionLength.String(),
+		ProjectedHourlyUSD: hourly,
+		ProjectedCostUSD:   hourly * b.SessionLength.Hours(),
+	}
+}
+
+// limits восстанавливает бюджет запуска из манифеста; без бюджета ограничений нет
+func (rb *RunBudget) limits() *Budget {
+	b := &Budget{SessionLength: 30 * time.Minute}
+	if rb == nil {
+		return b
+	}
+	b.MaxHourly = rb.MaxHourlyUSD
+	b.MaxRunCost = rb.MaxRunCostUSD
+	if d, err := time.ParseDuration(rb.SessionLength); err == nil {
+		b.SessionLength = d
+	}
+	return b
+}
+
+func (m *RunManifest) SetBudget(budget *RunBudget) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.Budget = budget
+}
+
+// OverBudget сообщает, что начисленная стоимость запуска достигла -max-run-cost
+func (m *RunManifest) OverBudget() bool {
+	m.mu.Lock()
+	limit := 0.0
+	if m.Budget != nil {
+		limit = m.Budget.MaxRunCostUSD
+	}
+	m.mu.Unlock()
+	if limit <= 0 {
+		return false
+	}
+	cost, _ := m.Cost()
+	return cost >= limit
+}
+
+// enforceBudget удаляет все живые инстансы, если бюджет запуска исчерпан; возвращает true, если исчерпан
+func enforceBudget(ctx context.Context, provider Provider, manifest *RunManifest) bool {
+	if !manifest.OverBudget() {
+		return false
+	}
+	if len(manifest.Active()) == 0 {
+		return true
+	}
+	cost, _ := manifest.Cost()
+	fmt.Printf("\n=== RUN BUDGET EXHAUSTED ===\n")
+	fmt.Printf("Accrued $%.4f of $%.4f, destroying %d instances\n", cost, manifest.Budget.MaxRunCostUSD, len(manifest.Active()))
+	for _, id := range manifest.Active() {
+		if err := provider.DestroyInstance(ctx, id); err != nil {
+			fmt.Printf("[%s] Failed to destroy instance %d: %v\n", time.Now().Format("15:04:05"), id, err)
+			continue
+		}
+		manifest.MarkDestroyed(id, "run budget exhausted")
+	}
+	now := time.Now()
+	manifest.mu.Lock()
+	manifest.Budget.ExhaustedAt = &now
+	manifest.mu.Unlock()
+	if err := manifest.Save(); err != nil {
+		log.Printf("Warning: could not save run manifest: %v", err)
+	}
+	return true
+}
+
+// watchBudget без auto-destroy держит процесс, пока бюджет не исчерпан или инстансы не удалены;
+// пульсы раннеров тем временем продолжают попадать в манифест
+func watchBudget(ctx context.Context, provider Provider, manifest *RunManifest, heartbeat *HeartbeatConfig, pollInterval time.Duration) {
+	if len(manifest.Active()) == 0 {
+		return
+	}
+	fmt.Printf("\n=== WATCHING RUN BUDGET ===\n")
+	fmt.Printf("Instances will be destroyed when the run cost reaches $%.4f (Ctrl-C stops watching, instances keep running)\n",
+		manifest.Budget.MaxRunCostUSD)
+	for len(manifest.Active()) > 0 {
+		if enforceBudget(ctx, provider, manifest) {
+			return
+		}
+		trackHeartbeats(manifest, heartbeat)
+		cost, _ := manifest.Cost()
+		fmt.Printf("[%s] Accrued $%.4f of $%.4f\n", time.Now().Format("15:04:05"), cost, manifest.Budget.MaxRunCostUSD)
+		manifest.checkpoint()
+		select {
+		case <-time.After(pollInterval):
+		case <-ctx.Done():
+			return
+		}
+	}
+}
+
+// printCostBreakdown печатает стоимость по инстансам и расход бюджета
+func printCostBreakdown(m *RunManifest) {
+	m.mu.Lock()
+	instances := append([]ManifestInstance(nil), m.Instances...)
+	budget := m.Budget
+	m.mu.Unlock()
+
+	fmt.Printf("\n=== COST BREAKDOWN ===\n")
+	for _, mi := range instances {
+		state := "running"
+		if mi.DestroyedAt != nil {
+			state = "destroyed: " + mi.DestroyReason
+		} else if mi.Status == InstanceGone {
+			state = "gone"
+		}
+		cost, hours := instanceCost(mi, time.Now())
+		fmt.Printf("  Instance %d (%s): $%.4f/hour x %.2f h = $%.4f (%s)\n",
+			mi.InstanceID, mi.GPUName, mi.PricePerHour, hours, cost, state)
+	}
+	if budget != nil {
+		total, _ := m.Cost()
+		fmt.Printf("  Projected: $%.4f/hour, $%.4f over %s\n", budget.ProjectedHourlyUSD, budget.ProjectedCostUSD, budget.SessionLength)
+		if budget.MaxHourlyUSD > 0 {
+			fmt.Printf("  Hourly budget: $%.4f of $%.4f\n", budget.ProjectedHourlyUSD, budget.MaxHourlyUSD)
+		}
+		if budget.MaxRunCostUSD > 0 {
+			fmt.Printf("  Run budget: $%.4f of $%.4f used (%.0f%%)\n", total, budget.MaxRunCostUSD, total/budget.MaxRunCostUSD*100)
+		}
+	}
+}
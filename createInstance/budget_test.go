@@ -0,0 +1,71 @@
+package main
+
+import (
+	"fmt"
+	"testing"
+	"time"
+)
+
+func TestBudgetFits(t *testing.T) {
+	cases := []struct {
+		name   string
+		budget Budget
+		hourly float64
+		want   bool
+	}{
+		{"under both caps", Budget{MaxHourly: 1, MaxRunCost: 1.5, SessionLength: 2 * time.Hour}, 0.5, true},
+		{"run cost exactly at cap", Budget{MaxHourly: 1, MaxRunCost: 1.5, SessionLength: 2 * time.Hour}, 0.75, true},
+		{"hourly at cap, run cost above", Budget{MaxHourly: 1, MaxRunCost: 1.5, SessionLength: 2 * time.Hour}, 1, false},
+		{"hourly exactly at cap", Budget{MaxHourly: 1, SessionLength: 2 * time.Hour}, 1, true},
+		{"hourly above cap", Budget{MaxHourly: 1, SessionLength: 2 * time.Hour}, 1.25, false},
+		{"run cost above cap", Budget{MaxRunCost: 1, SessionLength: 2 * time.Hour}, 0.75, false},
+		{"no limits", Budget{SessionLength: 2 * time.Hour}, 100, true},
+	}
+	for _, c := range cases {
+		if got := c.budget.fits(c.hourly); got != c.want {
+			t.Errorf("%s: fits(%v) = %v, want %v", c.name, c.hourly, got, c.want)
+		}
+	}
+}
+
+func TestBudgetSelectAndProjection(t *testing.T) {
+	offers := []Offer{
+		{ID: 1, DPHTotal: 0.5},
+		{ID: 2, DPHTotal: 0.5},
+		{ID: 3, DPHTotal: 0.25},
+		{ID: 4, DPHTotal: 0.25},
+	}
+	cases := []struct {
+		name    string
+		budget  Budget
+		count   int
+		want    []int
+		hourly  float64
+		runCost float64
+	}{
+		// Второе предложение доводит сумму ровно до лимита
+		{"hourly cap met exactly", Budget{MaxHourly: 1}, 4, []int{1, 2}, 1, 2},
+		// Второе не влезает, но более дешевое третье помещается
+		{"hourly cap skips an offer", Budget{MaxHourly: 0.75}, 4, []int{1, 3}, 0.75, 1.5},
+		{"run cost cap met exactly", Budget{MaxRunCost: 1.5}, 4, []int{1, 3}, 0.75, 1.5},
+		{"no limits stop at count", Budget{}, 3, []int{1, 2, 3}, 1.25, 2.5},
+		{"every offer exceeds the cap", Budget{MaxHourly: 0.2}, 4, nil, 0, 0},
+	}
+	for _, c := range cases {
+		c.budget.SessionLength = 2 * time.Hour
+		selected := c.budget.Select(offers, c.count)
+		var ids []int
+		for _, o := range selected {
+			ids = append(ids, o.ID)
+		}
+		if fmt.Sprint(ids) != fmt.Sprint(c.want) {
+			t.Errorf("%s: selected %v, want %v", c.name, ids, c.want)
+			continue
+		}
+		p := c.budget.Projection(selected)
+		if p.ProjectedHourlyUSD != c.hourly || p.ProjectedCostUSD != c.runCost || p.SessionLength != "2h0m0s" ||
+			p.MaxHourlyUSD != c.budget.MaxHourly || p.MaxRunCostUSD != c.budget.MaxRunCost {
+			t.Errorf("%s: projection %+v, want $%v/hour and $%v", c.name, p, c.hourly, c.runCost)
+		}
+	}
+}
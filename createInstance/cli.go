@@ -0,0 +1,227 @@
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"text/tabwriter"
+
+	"highloadtest/storage"
+)
+
+const (
+	OutputTable = "table"
+	OutputJSON  = "json"
+)
+
+// resultOutput - куда печатается результат команды; при -output=json это исходный stdout
+var resultOutput io.Writer = os.Stdout
+
+type subcommand struct {
+	name    string
+	summary string
+	run     func(args []string)
+}
+
+var subcommands = []subcommand{
+	{"offers", "search offers and print them without renting anything", runOffers},
+	{"create", "create instances for a new run without waiting or deploying", runCreate},
+	{"status", "show provider status of a run's instances", runStatus},
+	{"deploy", "start tests on the running instances of a run", runDeploy},
+	{"logs", "print the test log from each instance of a run", runLogs},
+	{"destroy", "destroy instances by ID, by run or all", runDestroy},
+	{"run", "search, create, wait and optionally deploy (default)", runPool},
+	{"serve", "run the HTTP API that starts and tracks runs in the background", runServe},
+}
+
+func usage() {
+	fmt.Fprintf(os.Stderr, "Usage: createInstance <command> [flags]\n\nCommands:\n")
+	for _, c := range subcommands {
+		fmt.Fprintf(os.Stderr, "  %-8s %s\n", c.name, c.summary)
+	}
+	fmt.Fprintf(os.Stderr, "\nWithout a command the flags are passed to run. Use \"createInstance <command> -h\" for command flags.\n")
+}
+
+func main() {
+	// Старый вызов без подкоманды (createInstance -count=5 ...) работает как run
+	if len(os.Args) < 2 || strings.HasPrefix(os.Args[1], "-") {
+		runPool(os.Args[1:])
+		return
+	}
+
+	name := os.Args[1]
+	if name == "help" {
+		usage()
+		return
+	}
+	for _, c := range subcommands {
+		if c.name == name {
+			c.run(os.Args[2:])
+			return
+		}
+	}
+	fmt.Fprintf(os.Stderr, "unknown command %q\n\n", name)
+	usage()
+	os.Exit(2)
+}
+
+// commonFlags - флаги, общие для всех подкоманд
+type commonFlags struct {
+	provider      string
+	apiRate       float64
+	apiBurst      int
+	output        string
+	storageConfig string
+
+	fs *flag.FlagSet
+}
+
+// addCommonFlags регистрирует общие флаги; пустой defaultProvider означает "из манифеста запуска"
+func addCommonFlags(fs *flag.FlagSet, defaultProvider string) *commonFlags {
+	c := &commonFlags{fs: fs}
+	providerHelp := "where to rent instances: vast, fake, docker or podman"
+	if defaultProvider == "" {
+		providerHelp = "provider override (default: from the run manifest, otherwise vast)"
+	}
+	fs.StringVar(&c.provider, "provider", defaultProvider, providerHelp)
+	fs.Float64Var(&c.apiRate, "api-rate", defaultProviderOptions().APIRate, "provider API requests per second shared by all calls")
+	fs.IntVar(&c.apiBurst, "api-burst", defaultProviderOptions().APIBurst, "provider API request burst size")
+	fs.StringVar(&c.output, "output", OutputTable, "output format: table or json")
+	fs.StringVar(&c.storageConfig, "storage-config", "", "runner config file whose storage section receives the run manifest")
+	return c
+}
+
+// parse разбирает аргументы и проверяет формат вывода. При json прогресс уходит в stderr,
+// чтобы stdout содержал только результат
+func (c *commonFlags) parse(args []string) {
+	c.fs.Parse(args)
+	switch c.output {
+	case OutputTable:
+	case OutputJSON:
+		resultOutput = os.Stdout
+		os.Stdout = os.Stderr
+	default:
+		log.Fatalf("%s: -output must be %s or %s", c.fs.Name(), OutputTable, OutputJSON)
+	}
+}
+
+func (c *commonFlags) options() ProviderOptions {
+	return ProviderOptions{APIRate: c.apiRate, APIBurst: c.apiBurst}
+}
+
+// newProvider создает провайдера; для существующего запуска по умолчанию берется провайдер из манифеста
+func (c *commonFlags) newProvider(m *RunManifest) Provider {
+	name := c.provider
+	if name == "" && m != nil {
+		name = m.Provider
+	}
+	if name == "" {
+		name = ProviderVast
+	}
+	p, err := newProvider(name, c.options())
+	if err != nil {
+		log.Fatalf("Failed to initialize provider: %v", err)
+	}
+	return p
+}
+
+// openStore открывает хранилище для манифеста; при ошибке манифест пишется только локально
+func (c *commonFlags) openStore() storage.ArtifactStore {
+	store, err := openManifestStore(c.storageConfig)
+	if err != nil {
+		log.Printf("Warning: run manifest will be saved locally only: %v", err)
+		return nil
+	}
+	return store
+}
+
+func (c *commonFlags) json() bool {
+	return c.output == OutputJSON
+}
+
+func printJSON(v interface{}) {
+	data, err := json.MarshalIndent(v, "", "  ")
+	if err != nil {
+		log.Fatalf("Failed to encode output: %v", err)
+	}
+	fmt.Fprintln(resultOutput, string(data))
+}
+
+// printTable печатает выровненную таблицу в вывод результата
+func printTable(header []string, rows [][]string) {
+	w := tabwriter.NewWriter(resultOutput, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(w, strings.Join(header, "\t"))
+	for _, row := range rows {
+		fmt.Fprintln(w, strings.Join(row, "\t"))
+	}
+	w.Flush()
+}
+
+// poolFlags выбирают инстансы существующего запуска
+type poolFlags struct {
+	runID string
+	ids   string
+}
+
+func addPoolFlags(fs *flag.FlagSet) *poolFlags {
+	p := &poolFlags{}
+	fs.StringVar(&p.runID, "run", "", "run ID (runs/<run>/run_manifest.json; default: the latest run)")
+	fs.StringVar(&p.ids, "ids", "", "comma-separated instance IDs within the run (default: all live instances)")
+	return p
+}
+
+// latestRunID возвращает самый новый запуск в ./runs; ID начинается с времени, поэтому сортируется строкой
+func latestRunID() (string, error) {
+	paths, err := filepath.Glob(filepath.Join("runs", "*", "run_manifest.json"))
+	if err != nil {
+		return "", err
+	}
+	if len(paths) == 0 {
+		return "", fmt.Errorf("no runs found in ./runs")
+	}
+	sort.Strings(paths)
+	return filepath.Base(filepath.Dir(paths[len(paths)-1])), nil
+}
+
+// loadRun читает манифест запуска; пустой runID - последний запуск
+func loadRun(runID string, store storage.ArtifactStore) *RunManifest {
+	if runID == "" {
+		var err error
+		if runID, err = latestRunID(); err != nil {
+			log.Fatalf("Failed to find a run: %v", err)
+		}
+	}
+	m, err := loadRunManifest(filepath.Join("runs", runID, "run_manifest.json"), store)
+	if err != nil {
+		log.Fatalf("Failed to load run %s: %v", runID, err)
+	}
+	return m
+}
+
+// load читает манифест выбранного запуска и возвращает ID выбранных инстансов
+func (p *poolFlags) load(store storage.ArtifactStore) (*RunManifest, []int) {
+	m := loadRun(p.runID, store)
+
+	if p.ids == "" {
+		return m, m.Active()
+	}
+	var ids []int
+	for _, part := range strings.Split(p.ids, ",") {
+		id, err := strconv.Atoi(strings.TrimSpace(part))
+		if err != nil {
+			log.Fatalf("Invalid instance ID %q", part)
+		}
+		if _, ok := m.Instance(id); !ok {
+			log.Fatalf("Instance %d is not part of run %s", id, m.RunID)
+		}
+		ids = append(ids, id)
+	}
+	return m, ids
+}
@@ -0,0 +1,404 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// runOffers - подкоманда offers: поиск и отбор предложений без аренды
+func runOffers(args []string) {
+	fs := flag.NewFlagSet("offers", flag.ExitOnError)
+	limit := fs.Int("limit", 20, "how many matching offers to print")
+	filter := addOfferFlags(fs)
+	common := addCommonFlags(fs, ProviderVast)
+	common.parse(args)
+	if err := filter.Validate(); err != nil {
+		log.Fatal(err)
+	}
+
+	client := common.newProvider(nil)
+	offers, err := searchOffers(context.Background(), client, filter, *limit)
+	if err != nil {
+		log.Fatal(err)
+	}
+	if len(offers) > *limit {
+		offers = offers[:*limit]
+	}
+
+	if common.json() {
+		printJSON(offers)
+		return
+	}
+	var rows [][]string
+	for _, o := range offers {
+		rows = append(rows, []string{
+			strconv.Itoa(o.ID),
+			o.GPUName,
+			strconv.Itoa(o.NumGPUs),
+			fmt.Sprintf("%.4f", o.DPHTotal),
+			fmt.Sprintf("%.3f", o.Reliability),
+			fmt.Sprintf("%.0f/%.0f", o.InetDown, o.InetUp),
+			fmt.Sprintf("%.1f", o.CPUCores),
+			fmt.Sprintf("%.0f", o.CPURAM/1024),
+			fmt.Sprintf("%.0f", o.DiskSpace),
+			fmt.Sprintf("%.1f", o.CudaMaxGood),
+			o.Geolocation,
+			o.Verification,
+		})
+	}
+	printTable([]string{"OFFER", "GPU", "GPUS", "USD/HOUR", "RELIABILITY", "NET_MBPS", "CPU", "RAM_GB", "DISK_GB", "CUDA", "LOCATION", "VERIFICATION"}, rows)
+}
+
+// runCreate - подкоманда create: новый запуск пула без ожидания готовности и запуска тестов
+func runCreate(args []string) {
+	fs := flag.NewFlagSet("create", flag.ExitOnError)
+	count := fs.Int("count", 1, "how many instances needed")
+	parallel := fs.Int("parallel", 8, "how many instances to create concurrently")
+	filter := addOfferFlags(fs)
+	budget := addBudgetFlags(fs)
+	common := addCommonFlags(fs, ProviderVast)
+	common.parse(args)
+	if *count < 1 {
+		log.Fatal("-count must be at least 1")
+	}
+	if err := filter.Validate(); err != nil {
+		log.Fatal(err)
+	}
+	if err := budget.Validate(); err != nil {
+		log.Fatal(err)
+	}
+
+	ctx := context.Background()
+	client := common.newProvider(nil)
+	store := common.openStore()
+	if store != nil {
+		defer store.Close()
+	}
+
+	manifest := NewRunManifest(newRunID(), client.Name(), fs, store)
+	fmt.Printf("Run ID: %s\n", manifest.RunID)
+
+	offers, err := searchOffers(ctx, client, filter, *count)
+	if err != nil {
+		log.Fatal(err)
+	}
+	if len(offers) == 0 {
+		log.Fatal("No offers match the filters")
+	}
+	if len(offers) < *count {
+		fmt.Printf("Only %d suitable offers available, creating %d instances instead of %d\n", len(offers), len(offers), *count)
+		*count = len(offers)
+	}
+	selected := budget.Select(offers, *count)
+	if len(selected) == 0 {
+		log.Fatalf("No offer fits the budget (-max-total-hourly=%.2f, -max-run-cost=%.2f over %v)",
+			budget.MaxHourly, budget.MaxRunCost, budget.SessionLength)
+	}
+	manifest.SetOffers(selected)
+	manifest.SetCandidates(spareOffers(offers, selected))
+	manifest.SetBudget(budget.Projection(selected))
+	createInstances(ctx, client, selected, manifest, *parallel)
+	if err := manifest.Save(); err != nil {
+		log.Printf("Warning: could not save run manifest: %v", err)
+	}
+	fmt.Printf("\nRun manifest: %s\n", filepath.FromSlash(manifest.manifestKey()))
+
+	if common.json() {
+		printJSON(manifest)
+		return
+	}
+	var rows [][]string
+	for _, inst := range manifest.Instances {
+		rows = append(rows, []string{
+			manifest.RunID,
+			strconv.Itoa(inst.InstanceID),
+			strconv.Itoa(inst.OfferID),
+			inst.GPUName,
+			fmt.Sprintf("%.4f", inst.PricePerHour),
+		})
+	}
+	printTable([]string{"RUN", "INSTANCE", "OFFER", "GPU", "USD/HOUR"}, rows)
+	for _, failure := range manifest.Failures {
+		fmt.Fprintf(os.Stderr, "failed: %s\n", failure)
+	}
+}
+
+// InstanceStatus - строка вывода status: состояние у провайдера плюс данные манифеста
+type InstanceStatus struct {
+	RunID         string  `json:"run_id"`
+	InstanceID    int     `json:"instance_id"`
+	GPUName       string  `json:"gpu_name"`
+	PricePerHour  float64 `json:"price_per_hour"`
+	Status        string  `json:"status"`
+	StatusMessage string  `json:"status_message,omitempty"`
+	SSHHost       string  `json:"ssh_host,omitempty"`
+	SSHPort       int     `json:"ssh_port,omitempty"`
+	Stage         string  `json:"stage"`
+	TestStarted   bool    `json:"test_started"`
+	SessionStatus string  `json:"session_status,omitempty"`
+	Error         string  `json:"error,omitempty"`
+
+	Heartbeat     *Heartbeat `json:"heartbeat,omitempty"`
+	HeartbeatLost bool       `json:"heartbeat_lost,omitempty"`
+}
+
+// runStatus - подкоманда status: опрашивает провайдера по инстансам запуска и обновляет манифест
+func runStatus(args []string) {
+	fs := flag.NewFlagSet("status", flag.ExitOnError)
+	pool := addPoolFlags(fs)
+	common := addCommonFlags(fs, "")
+	common.parse(args)
+
+	store := common.openStore()
+	if store != nil {
+		defer store.Close()
+	}
+	manifest, ids := pool.load(store)
+	if pool.ids == "" {
+		// Без -ids показываем весь запуск, удаленные инстансы - по данным манифеста
+		ids = nil
+		for _, inst := range manifest.Instances {
+			ids = append(ids, inst.InstanceID)
+		}
+	}
+	client := common.newProvider(manifest)
+	ctx := context.Background()
+
+	var statuses []InstanceStatus
+	for _, id := range ids {
+		mi, _ := manifest.Instance(id)
+		s := InstanceStatus{
+			RunID:         manifest.RunID,
+			InstanceID:    id,
+			GPUName:       mi.GPUName,
+			PricePerHour:  mi.PricePerHour,
+			Status:        mi.Status,
+			SSHHost:       mi.SSHHost,
+			SSHPort:       mi.SSHPort,
+			Stage:         mi.Stage,
+			TestStarted:   mi.TestStarted,
+			SessionStatus: mi.SessionStatus,
+			Error:         mi.Error,
+			Heartbeat:     mi.Heartbeat,
+			HeartbeatLost: mi.HeartbeatLost,
+		}
+		if mi.DestroyedAt == nil && mi.Status != InstanceGone {
+			instance, err := client.GetInstance(ctx, id)
+			if err != nil {
+				s.Status = "unknown"
+				s.Error = err.Error()
+			} else {
+				s.Status = instance.Status
+				s.StatusMessage = strings.TrimSpace(instance.StatusMessage)
+				s.SSHHost = instance.SSHHost
+				s.SSHPort = instance.SSHPort
+				manifest.RecordStatus(instance)
+			}
+		}
+		statuses = append(statuses, s)
+	}
+	if err := manifest.Save(); err != nil {
+		log.Printf("Warning: could not save run manifest: %v", err)
+	}
+
+	if common.json() {
+		printJSON(statuses)
+		return
+	}
+	var rows [][]string
+	for _, s := range statuses {
+		ssh := "-"
+		if s.SSHHost != "" {
+			ssh = fmt.Sprintf("%s:%d", s.SSHHost, s.SSHPort)
+		}
+		note := s.StatusMessage
+		if s.Error != "" {
+			note = s.Error
+		} else if s.HeartbeatLost {
+			note = "heartbeat lost"
+		} else if s.Heartbeat != nil && !stageFinal(s.Stage) {
+			note = "heartbeat: " + s.Heartbeat.String()
+		}
+		rows = append(rows, []string{
+			strconv.Itoa(s.InstanceID),
+			s.GPUName,
+			fmt.Sprintf("%.4f", s.PricePerHour),
+			s.Status,
+			ssh,
+			s.Stage,
+			s.SessionStatus,
+			note,
+		})
+	}
+	fmt.Fprintf(resultOutput, "Run %s (%s)\n", manifest.RunID, manifest.Provider)
+	printTable([]string{"INSTANCE", "GPU", "USD/HOUR", "STATUS", "SSH", "STAGE", "SESSION", "NOTE"}, rows)
+}
+
+// runDeploy - подкоманда deploy: (пере)запуск тестов на уже созданных инстансах запуска
+func runDeploy(args []string) {
+	fs := flag.NewFlagSet("deploy", flag.ExitOnError)
+	pool := addPoolFlags(fs)
+	waitMinutes := fs.Int("wait", 0, "minutes to wait for instances that are not SSH-ready yet")
+	bundle := addRunnerFlags(fs)
+	lifecycle := addLifecycleFlags(fs)
+	heartbeat := addHeartbeatFlags(fs)
+	dashboardMode := addDashboardFlag(fs)
+	common := addCommonFlags(fs, "")
+	common.parse(args)
+	if err := lifecycle.Validate(); err != nil {
+		log.Fatal(err)
+	}
+	if err := heartbeat.Validate(); err != nil {
+		log.Fatal(err)
+	}
+	bundle.Heartbeat = heartbeat
+	if err := bundle.Prepare(); err != nil {
+		log.Fatal(err)
+	}
+
+	store := common.openStore()
+	if store != nil {
+		defer store.Close()
+	}
+	manifest, ids := pool.load(store)
+	client := common.newProvider(manifest)
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	if len(ids) == 0 {
+		log.Fatalf("Run %s has no live instances", manifest.RunID)
+	}
+	// Повторный deploy перезапускает тесты: уже развернутые и упавшие инстансы проходят этапы заново
+	for _, id := range ids {
+		if mi, _ := manifest.Instance(id); mi.DestroyedAt == nil && (stageBefore(StageSSHReady, mi.Stage) || mi.Stage == StageFailed) {
+			manifest.SetStage(id, StageCreated, "")
+			manifest.UpdateInstance(id, func(mi *ManifestInstance) {
+				mi.Heartbeat = nil
+				mi.HeartbeatLost = false
+			})
+		}
+	}
+	supervisor := &poolSupervisor{
+		client:    client,
+		manifest:  manifest,
+		lifecycle: lifecycle,
+		budget:    manifest.Budget.limits(),
+		bundle:    bundle,
+		target:    len(ids),
+		parallel:  1,
+		ids:       ids,
+	}
+	heartbeat.serveHeartbeats()
+	dashboard := startDashboard(*dashboardMode, manifest, store, cancel)
+	supervisor.Run(ctx, time.Duration(*waitMinutes)*time.Minute)
+	dashboard.Stop()
+	if err := manifest.Save(); err != nil {
+		log.Printf("Warning: could not save run manifest: %v", err)
+	}
+
+	var result []ManifestInstance
+	for _, id := range supervisor.ids {
+		mi, _ := manifest.Instance(id)
+		result = append(result, mi)
+	}
+	if common.json() {
+		printJSON(result)
+		return
+	}
+	var rows [][]string
+	for _, mi := range result {
+		rows = append(rows, []string{
+			strconv.Itoa(mi.InstanceID),
+			mi.Status,
+			mi.Stage,
+			mi.Error,
+		})
+	}
+	printTable([]string{"INSTANCE", "STATUS", "STAGE", "ERROR"}, rows)
+	if ctx.Err() != nil {
+		if store != nil {
+			store.Close()
+		}
+		os.Exit(130)
+	}
+}
+
+// InstanceLog - хвост лог-файла с одного инстанса
+type InstanceLog struct {
+	InstanceID int    `json:"instance_id"`
+	SSH        string `json:"ssh,omitempty"`
+	File       string `json:"file"`
+	Output     string `json:"output"`
+	Error      string `json:"error,omitempty"`
+}
+
+// runLogs - подкоманда logs: последние строки лога теста с каждого инстанса по SSH
+func runLogs(args []string) {
+	fs := flag.NewFlagSet("logs", flag.ExitOnError)
+	pool := addPoolFlags(fs)
+	lines := fs.Int("lines", 50, "how many trailing lines to print")
+	file := fs.String("file", "/tmp/test_output.log", "log file on the instance (runner output; start.sh logs to /tmp/test_startup.log)")
+	common := addCommonFlags(fs, "")
+	common.parse(args)
+
+	store := common.openStore()
+	if store != nil {
+		defer store.Close()
+	}
+	manifest, ids := pool.load(store)
+	client := common.newProvider(manifest)
+	ctx := context.Background()
+
+	mgr, err := sshSessions()
+	if err != nil {
+		log.Fatalf("SSH: %v", err)
+	}
+	defer mgr.Close()
+
+	logs := make([]InstanceLog, len(ids))
+	var wg sync.WaitGroup
+	for i, id := range ids {
+		wg.Add(1)
+		go func(i, id int) {
+			defer wg.Done()
+			entry := InstanceLog{InstanceID: id, File: *file}
+			defer func() { logs[i] = entry }()
+
+			endpoint, err := client.SSHEndpoint(ctx, id)
+			if err != nil {
+				entry.Error = err.Error()
+				return
+			}
+			entry.SSH = endpoint.String()
+			output, err := mgr.Run(ctx, endpoint.Host, endpoint.Port, "",
+				fmt.Sprintf("tail -n %d %s", *lines, shellQuote(*file)), 30*time.Second)
+			entry.Output = output
+			if err != nil {
+				entry.Error = fmt.Sprintf("ssh failed: %v", err)
+			}
+		}(i, id)
+	}
+	wg.Wait()
+
+	if common.json() {
+		printJSON(logs)
+		return
+	}
+	for _, entry := range logs {
+		fmt.Fprintf(resultOutput, "=== instance %d (%s) %s ===\n", entry.InstanceID, entry.SSH, entry.File)
+		if entry.Output != "" {
+			fmt.Fprint(resultOutput, strings.TrimRight(entry.Output, "\n")+"\n")
+		}
+		if entry.Error != "" {
+			fmt.Fprintf(resultOutput, "error: %s\n", entry.Error)
+		}
+	}
+}
@@ -0,0 +1,467 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"flag"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"os/signal"
+	"path"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"syscall"
+	"text/tabwriter"
+	"time"
+
+	"golang.org/x/term"
+
+	"highloadtest/storage"
+)
+
+// Режимы живой таблицы пула
+const (
+	DashboardAuto = "auto"
+	DashboardOn   = "on"
+	DashboardOff  = "off"
+)
+
+// dashboardRefresh - период перерисовки таблицы; хранилище со скриншотами опрашивается раз в lifecyclePollInterval
+const dashboardRefresh = time.Second
+
+// dashboardEvents - сколько последних строк вывода держит таблица
+const dashboardEvents = 200
+
+// dashboardFlag - значение -dashboard; неизвестный режим отклоняется при разборе флагов
+type dashboardFlag string
+
+func (f *dashboardFlag) String() string {
+	return string(*f)
+}
+
+func (f *dashboardFlag) Set(value string) error {
+	switch value {
+	case DashboardAuto, DashboardOn, DashboardOff:
+		*f = dashboardFlag(value)
+		return nil
+	}
+	return fmt.Errorf("must be %s, %s or %s", DashboardAuto, DashboardOn, DashboardOff)
+}
+
+func addDashboardFlag(fs *flag.FlagSet) *dashboardFlag {
+	mode := dashboardFlag(DashboardAuto)
+	fs.Var(&mode, "dashboard", "live pool table: auto (when stdout is a terminal), on or off (plain log lines)")
+	return &mode
+}
+
+// sessionProgress - что раннер инстанса уже залил в хранилище
+type sessionProgress struct {
+	SessionID   string
+	Screenshots int
+}
+
+// Dashboard показывает состояние пула. В терминале это таблица, которая перерисовывается на месте:
+// обычный вывод (fmt.Printf и log) перехватывается, целиком пишется в runs/<run>/output.log,
+// а последние строки видны под таблицей. Без терминала вывод идет как раньше, и раз в период
+// опроса печатается строка итогов
+type Dashboard struct {
+	manifest *RunManifest
+	store    storage.ArtifactStore // для сессий и скриншотов; nil - колонки пустые
+	live     bool
+
+	out       *os.File // исходный stdout, в него рисуется таблица
+	logOutput io.Writer
+	pipe      *os.File
+	logFile   *os.File
+	signals   chan os.Signal
+	cancel    context.CancelFunc // отменяет запуск по Ctrl-C
+
+	done    chan struct{}
+	loop    sync.WaitGroup
+	capture sync.WaitGroup
+
+	mu       sync.Mutex
+	events   []string
+	sessions map[int]sessionProgress
+}
+
+// startDashboard запускает таблицу для пула; Stop обязателен, он возвращает stdout на место.
+// cancel отменяет контекст запуска, когда таблица перехватила Ctrl-C
+func startDashboard(mode dashboardFlag, manifest *RunManifest, store storage.ArtifactStore, cancel context.CancelFunc) *Dashboard {
+	d := &Dashboard{
+		manifest: manifest,
+		store:    store,
+		cancel:   cancel,
+		out:      os.Stdout,
+		done:     make(chan struct{}),
+		sessions: make(map[int]sessionProgress),
+	}
+	switch mode {
+	case DashboardOn:
+		d.live = true
+	case DashboardAuto:
+		d.live = term.IsTerminal(int(os.Stdout.Fd())) && os.Getenv("TERM") != "dumb"
+	}
+	if d.live {
+		if err := d.startCapture(); err != nil {
+			log.Printf("Warning: live dashboard disabled: %v", err)
+			d.live = false
+		}
+	}
+
+	d.loop.Add(1)
+	go d.run()
+	return d
+}
+
+// startCapture подменяет stdout и вывод log трубой, из которой читает readEvents
+func (d *Dashboard) startCapture() error {
+	logPath := d.logPath()
+	if err := os.MkdirAll(filepath.Dir(logPath), 0755); err != nil {
+		return err
+	}
+	logFile, err := os.OpenFile(logPath, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0644)
+	if err != nil {
+		return err
+	}
+	r, w, err := os.Pipe()
+	if err != nil {
+		logFile.Close()
+		return err
+	}
+	d.logFile = logFile
+	d.pipe = w
+	d.logOutput = log.Writer()
+	os.Stdout = w
+	log.SetOutput(w)
+
+	d.capture.Add(1)
+	go d.readEvents(r)
+
+	// Курсор спрятан, пока таблица на экране. Ctrl-C отменяет запуск: main сохраняет манифест
+	// и вызывает Stop, который возвращает stdout и курсор. Второй Ctrl-C завершает процесс сразу
+	d.signals = make(chan os.Signal, 1)
+	signal.Notify(d.signals, os.Interrupt, syscall.SIGTERM)
+	go func() {
+		if _, ok := <-d.signals; ok {
+			signal.Stop(d.signals)
+			d.out.WriteString("\033[?25h")
+			fmt.Println("Interrupted, stopping the run...")
+			d.cancel()
+		}
+	}()
+	d.out.WriteString("\033[?25l\033[2J")
+	return nil
+}
+
+func (d *Dashboard) logPath() string {
+	return filepath.Join("runs", d.manifest.RunID, "output.log")
+}
+
+// readEvents пишет перехваченный вывод в output.log и запоминает последние непустые строки
+func (d *Dashboard) readEvents(r *os.File) {
+	defer d.capture.Done()
+	defer r.Close()
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(make([]byte, 64*1024), 1<<20)
+	for scanner.Scan() {
+		line := scanner.Text()
+		fmt.Fprintln(d.logFile, line)
+		if line = strings.TrimSpace(line); line == "" {
+			continue
+		}
+		d.mu.Lock()
+		d.events = append(d.events, line)
+		if len(d.events) > dashboardEvents {
+			d.events = append([]string(nil), d.events[len(d.events)-dashboardEvents:]...)
+		}
+		d.mu.Unlock()
+	}
+}
+
+func (d *Dashboard) run() {
+	defer d.loop.Done()
+	refresh := dashboardRefresh
+	if !d.live {
+		refresh = lifecyclePollInterval
+	}
+	ticker := time.NewTicker(refresh)
+	defer ticker.Stop()
+
+	d.refreshSessions()
+	lastSessions := time.Now()
+	if d.live {
+		d.render()
+	}
+	for {
+		select {
+		case <-d.done:
+			return
+		case <-ticker.C:
+		}
+		if time.Since(lastSessions) >= lifecyclePollInterval {
+			d.refreshSessions()
+			lastSessions = time.Now()
+		}
+		if d.live {
+			d.render()
+		} else {
+			instances, replacements := d.manifest.snapshot()
+			fmt.Printf("[%s] Pool %s: %s\n", time.Now().Format("15:04:05"), d.manifest.RunID, d.totals(instances, replacements))
+		}
+	}
+}
+
+// Stop рисует таблицу в последний раз и возвращает stdout и log на место
+func (d *Dashboard) Stop() {
+	close(d.done)
+	d.loop.Wait()
+	if !d.live {
+		return
+	}
+
+	// Сначала дочитываем трубу, чтобы в последнем кадре были все строки
+	os.Stdout = d.out
+	log.SetOutput(d.logOutput)
+	d.pipe.Close()
+	d.capture.Wait()
+	d.logFile.Close()
+	d.render()
+	signal.Stop(d.signals)
+	close(d.signals)
+
+	d.out.WriteString("\033[?25h")
+	fmt.Printf("\nFull output: %s\n", d.logPath())
+}
+
+// sessionObjectPattern находит артефакты сессий запуска: session_<ts>_run<RUN>_inst<ID>_<rand>/<файл>
+func sessionObjectPattern(runID string) *regexp.Regexp {
+	return regexp.MustCompile(`^(session_[0-9_-]+_run` + regexp.QuoteMeta(runID) + `_inst(\d+)_[0-9a-f]+)/(.+)$`)
+}
+
+// refreshSessions считает скриншоты последней сессии каждого живого инстанса в хранилище.
+// Читаются только каталоги известных сессий (по пульсу или найденные раньше), весь список
+// session_ - пока сессия какого-то инстанса не найдена; у удаленных остается последний счет
+func (d *Dashboard) refreshSessions() {
+	if d.store == nil {
+		return
+	}
+	instances, _ := d.manifest.snapshot()
+	d.mu.Lock()
+	prev := d.sessions
+	d.mu.Unlock()
+
+	sessions := make(map[int]sessionProgress)
+	known := make(map[int]string)
+	var live []int
+	for _, mi := range instances {
+		if mi.DestroyedAt != nil || mi.Status == InstanceGone {
+			if p, ok := prev[mi.InstanceID]; ok {
+				sessions[mi.InstanceID] = p
+			}
+			continue
+		}
+		live = append(live, mi.InstanceID)
+		known[mi.InstanceID] = mi.SessionID
+		if found := prev[mi.InstanceID].SessionID; found > known[mi.InstanceID] {
+			known[mi.InstanceID] = found
+		}
+	}
+
+	var objects []storage.ObjectInfo
+	for _, prefix := range sessionPrefixes(live, func(id int) string { return known[id] }) {
+		found, err := d.store.List(context.Background(), prefix)
+		if err != nil {
+			fmt.Printf("[%s] Could not list session artifacts: %v\n", time.Now().Format("15:04:05"), err)
+			return
+		}
+		objects = append(objects, found...)
+	}
+	pattern := sessionObjectPattern(d.manifest.RunID)
+	for _, obj := range objects {
+		match := pattern.FindStringSubmatch(obj.Key)
+		if match == nil {
+			continue
+		}
+		id, _ := strconv.Atoi(match[2])
+		// После повторного deploy у инстанса несколько сессий; ID начинается с времени, берем последнюю
+		p := sessions[id]
+		if match[1] > p.SessionID {
+			p = sessionProgress{SessionID: match[1]}
+		} else if match[1] < p.SessionID {
+			continue
+		}
+		if strings.HasPrefix(path.Base(match[3]), "screenshot_") {
+			p.Screenshots++
+		}
+		sessions[id] = p
+	}
+	d.mu.Lock()
+	d.sessions = sessions
+	d.mu.Unlock()
+}
+
+// totals - итоги по запуску для заголовка таблицы и строки итогов
+func (d *Dashboard) totals(instances []ManifestInstance, replacements int) string {
+	live, ready, failed, lost := 0, 0, 0, 0
+	for _, mi := range instances {
+		if mi.DestroyedAt == nil && mi.Status != InstanceGone {
+			live++
+		}
+		if mi.HeartbeatLost && mi.DestroyedAt == nil {
+			lost++
+		}
+		switch mi.Stage {
+		case StageSSHReady, StageDeployed, StageTesting, StageDone:
+			ready++
+		case StageFailed:
+			failed++
+		}
+	}
+	cost, _ := d.manifest.Cost()
+	text := fmt.Sprintf("instances: %d (live %d), ready: %d, failed: %d, replacements: %d, cost so far: $%.4f ($%.4f/hour)",
+		len(instances), live, ready, failed, replacements, cost, d.manifest.hourly())
+	if lost > 0 {
+		text += fmt.Sprintf(", lost: %d", lost)
+	}
+	if d.store != nil {
+		screenshots := 0
+		d.mu.Lock()
+		for _, p := range d.sessions {
+			screenshots += p.Screenshots
+		}
+		d.mu.Unlock()
+		text += fmt.Sprintf(", screenshots: %d", screenshots)
+	}
+	return text
+}
+
+// render перерисовывает экран: заголовок, таблица инстансов и хвост вывода, сколько влезет по высоте
+func (d *Dashboard) render() {
+	width, height, err := term.GetSize(int(d.out.Fd()))
+	if err != nil || width <= 0 || height <= 0 {
+		width, height = 120, 40
+	}
+	instances, replacements := d.manifest.snapshot()
+
+	d.mu.Lock()
+	sessions := d.sessions
+	events := d.events
+	d.mu.Unlock()
+
+	lines := []string{
+		fmt.Sprintf("Run %s (%s)  %s", d.manifest.RunID, d.manifest.Provider, time.Now().Format("15:04:05")),
+		d.totals(instances, replacements),
+		"",
+	}
+
+	var table bytes.Buffer
+	w := tabwriter.NewWriter(&table, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(w, "INSTANCE\tGPU\t$/HOUR\tSTAGE\tELAPSED\tSESSION\tSHOTS\tHEARTBEAT\tLAST EVENT")
+	for _, mi := range instances {
+		gpu := mi.GPUName
+		if mi.NumGPUs > 1 {
+			gpu = fmt.Sprintf("%s x%d", mi.GPUName, mi.NumGPUs)
+		}
+		end := time.Now()
+		if mi.DestroyedAt != nil {
+			end = *mi.DestroyedAt
+		}
+		session, shots := "-", "-"
+		if mi.SessionID != "" {
+			session = mi.SessionID
+		}
+		if d.store != nil {
+			p := sessions[mi.InstanceID]
+			if mi.SessionID == "" && p.SessionID != "" {
+				session = p.SessionID
+			}
+			shots = strconv.Itoa(p.Screenshots)
+		}
+		// Пульс приходит чаще, чем опрос хранилища, и есть без него
+		if hb := mi.Heartbeat; hb != nil && (d.store == nil || hb.Screenshots > sessions[mi.InstanceID].Screenshots) {
+			shots = strconv.Itoa(hb.Screenshots)
+		}
+		fmt.Fprintf(w, "%d\t%s\t%.4f\t%s\t%v\t%s\t%s\t%s\t%s\n", mi.InstanceID, gpu, mi.PricePerHour, mi.Stage,
+			end.Sub(mi.CreatedAt).Round(time.Second), session, shots, heartbeatColumn(mi), lastEvent(mi))
+	}
+	w.Flush()
+	lines = append(lines, strings.Split(strings.TrimRight(table.String(), "\n"), "\n")...)
+
+	// Последняя строка экрана остается пустой, иначе терминал прокрутит таблицу
+	if free := height - 1 - len(lines) - 2; free > 0 && len(events) > 0 {
+		if len(events) > free {
+			events = events[len(events)-free:]
+		}
+		lines = append(lines, "", "Recent output:")
+		lines = append(lines, events...)
+	}
+	if len(lines) > height-1 {
+		lines = lines[:height-1]
+	}
+
+	var b strings.Builder
+	b.WriteString("\033[H")
+	for _, line := range lines {
+		b.WriteString(truncateLine(line, width))
+		b.WriteString("\033[K\n")
+	}
+	b.WriteString("\033[J")
+	d.out.WriteString(b.String())
+}
+
+// heartbeatColumn - фаза раннера и возраст последнего пульса
+func heartbeatColumn(mi ManifestInstance) string {
+	switch {
+	case mi.HeartbeatLost && mi.DestroyedAt == nil:
+		return "LOST"
+	case mi.Heartbeat == nil:
+		return "-"
+	case mi.Heartbeat.final() || mi.DestroyedAt != nil:
+		return mi.Heartbeat.Phase
+	}
+	return fmt.Sprintf("%s %vs ago", mi.Heartbeat.Phase, int(time.Since(mi.Heartbeat.ReceivedAt).Seconds()))
+}
+
+// lastEvent - последняя запись истории инстанса; пока running не прошел проверку готовности - ее итог
+func lastEvent(mi ManifestInstance) string {
+	if len(mi.History) == 0 {
+		return ""
+	}
+	h := mi.History[len(mi.History)-1]
+	text := h.Stage
+	if h.Message != "" {
+		text = h.Message
+	}
+	if mi.Stage == StageRunning && mi.Probe != nil && mi.Probe.At.After(h.At) {
+		h.At, text = mi.Probe.At, "probe: "+mi.Probe.String()
+	}
+	return h.At.Format("15:04:05") + " " + text
+}
+
+func truncateLine(line string, width int) string {
+	runes := []rune(line)
+	if len(runes) <= width {
+		return line
+	}
+	if width < 1 {
+		return ""
+	}
+	return string(runes[:width-1]) + "…"
+}
+
+// snapshot возвращает копию инстансов по возрастанию ID и число замен
+func (m *RunManifest) snapshot() ([]ManifestInstance, int) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	instances := append([]ManifestInstance(nil), m.Instances...)
+	sort.Slice(instances, func(i, j int) bool { return instances[i].InstanceID < instances[j].InstanceID })
+	return instances, m.Replacements
+}
@@ -0,0 +1,518 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"highloadtest/storage"
+)
+
+const (
+	InstanceDestroyed = "destroyed"
+	InstanceGone      = "gone"
+)
+
+// loadRunManifest читает манифест, записанный прошлым запуском пула
+func loadRunManifest(path string, store storage.ArtifactStore) (*RunManifest, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read run manifest: %v", err)
+	}
+	m := &RunManifest{}
+	if err := json.Unmarshal(data, m); err != nil {
+		return nil, fmt.Errorf("failed to parse %s: %v", path, err)
+	}
+	if m.Provider == "" {
+		m.Provider = ProviderVast
+	}
+	m.store = store
+	m.normalizeStages()
+	return m, nil
+}
+
+func loadAllRunManifests(store storage.ArtifactStore) ([]*RunManifest, error) {
+	paths, err := filepath.Glob(filepath.Join("runs", "*", "run_manifest.json"))
+	if err != nil {
+		return nil, err
+	}
+	sort.Strings(paths)
+	var manifests []*RunManifest
+	for _, path := range paths {
+		m, err := loadRunManifest(path, store)
+		if err != nil {
+			log.Printf("Warning: skipping %s: %v", path, err)
+			continue
+		}
+		manifests = append(manifests, m)
+	}
+	return manifests, nil
+}
+
+// Active возвращает ID инстансов, которые еще не удалены
+func (m *RunManifest) Active() []int {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	var ids []int
+	for _, inst := range m.Instances {
+		if inst.DestroyedAt == nil && inst.Status != InstanceGone {
+			ids = append(ids, inst.InstanceID)
+		}
+	}
+	return ids
+}
+
+func (m *RunManifest) Instance(id int) (ManifestInstance, bool) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	for _, inst := range m.Instances {
+		if inst.InstanceID == id {
+			return inst, true
+		}
+	}
+	return ManifestInstance{}, false
+}
+
+// MarkDestroyed фиксирует время удаления и стоимость аренды инстанса
+func (m *RunManifest) MarkDestroyed(id int, reason string) {
+	now := time.Now()
+	m.UpdateInstance(id, func(mi *ManifestInstance) {
+		if !stageFinal(mi.Stage) {
+			mi.setStage(StageDestroyed, reason)
+		}
+		mi.setStatus(InstanceDestroyed, reason)
+		mi.DestroyedAt = &now
+		mi.DestroyReason = reason
+		mi.CostUSD, _ = instanceCost(*mi, now)
+	})
+	if inst, ok := m.Instance(id); ok && inst.SSHHost != "" {
+		if err := forgetHostKey(sshKnownHostsPath(), inst.SSHHost, inst.SSHPort); err != nil {
+			log.Printf("Warning: could not forget host key of instance %d: %v", id, err)
+		}
+	}
+}
+
+// instanceCost считает стоимость и часы аренды инстанса: удаленного - до удаления, живого - до now.
+// Для пропавшего (gone) время удаления неизвестно, он не учитывается
+func instanceCost(mi ManifestInstance, now time.Time) (cost, hours float64) {
+	end := now
+	if mi.DestroyedAt != nil {
+		end = *mi.DestroyedAt
+	} else if mi.Status == InstanceGone {
+		return 0, 0
+	}
+	hours = end.Sub(mi.CreatedAt).Hours()
+	return mi.PricePerHour * hours, hours
+}
+
+// Cost возвращает суммарную стоимость и инстанс-часы; живые инстансы считаются до текущего момента
+func (m *RunManifest) Cost() (total, hours float64) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	now := time.Now()
+	for _, inst := range m.Instances {
+		c, h := instanceCost(inst, now)
+		hours += h
+		total += c
+	}
+	return total, hours
+}
+
+// totalCost суммирует стоимость и инстанс-часы нескольких запусков
+func totalCost(manifests ...*RunManifest) (total, hours float64) {
+	for _, m := range manifests {
+		t, h := m.Cost()
+		total += t
+		hours += h
+	}
+	return total, hours
+}
+
+func printCostSummary(manifests ...*RunManifest) {
+	total, hours := totalCost(manifests...)
+	instances := 0
+	for _, m := range manifests {
+		instances += len(m.Instances)
+	}
+	fmt.Printf("Total cost: $%.4f for %d instances (%.2f instance-hours)\n", total, instances, hours)
+}
+
+// DestroyResult - итог подкоманды destroy для -output=json
+type DestroyResult struct {
+	Destroyed []int   `json:"destroyed"`
+	Failed    []int   `json:"failed"`
+	Gone      []int   `json:"gone"`
+	CostUSD   float64 `json:"total_cost_usd"`
+}
+
+func newDestroyResult() DestroyResult {
+	return DestroyResult{Destroyed: []int{}, Failed: []int{}, Gone: []int{}}
+}
+
+// runDestroy - подкоманда destroy: по ID, по запуску пула или все созданные инструментом инстансы
+func runDestroy(args []string) {
+	fs := flag.NewFlagSet("destroy", flag.ExitOnError)
+	ids := fs.String("ids", "", "comma-separated instance IDs to destroy")
+	runID := fs.String("run", "", "destroy all instances of this run (runs/<run>/run_manifest.json)")
+	all := fs.Bool("all", false, "destroy every live instance recorded in local run manifests")
+	common := addCommonFlags(fs, "")
+	common.parse(args)
+	providerName := &common.provider
+
+	modes := 0
+	for _, set := range []bool{*ids != "", *runID != "", *all} {
+		if set {
+			modes++
+		}
+	}
+	if modes != 1 {
+		log.Fatal("destroy: specify exactly one of -ids, -run or -all")
+	}
+
+	store := common.openStore()
+	if store != nil {
+		defer store.Close()
+	}
+
+	var manifests []*RunManifest
+	if *runID != "" {
+		m, err := loadRunManifest(filepath.Join("runs", *runID, "run_manifest.json"), store)
+		if err != nil {
+			log.Fatalf("destroy: %v", err)
+		}
+		manifests = []*RunManifest{m}
+	} else {
+		var err error
+		manifests, err = loadAllRunManifests(store)
+		if err != nil {
+			log.Fatalf("destroy: %v", err)
+		}
+	}
+
+	// Собираем цели по провайдерам; манифест (если есть) обновляем после удаления
+	type target struct {
+		id       int
+		manifest *RunManifest
+	}
+	targets := make(map[string][]target)
+	if *ids != "" {
+		for _, part := range strings.Split(*ids, ",") {
+			id, err := strconv.Atoi(strings.TrimSpace(part))
+			if err != nil {
+				log.Fatalf("destroy: invalid instance ID %q", part)
+			}
+			t := target{id: id}
+			provider := *providerName
+			for _, m := range manifests {
+				if _, ok := m.Instance(id); ok {
+					t.manifest = m
+					if provider == "" {
+						provider = m.Provider
+					}
+				}
+			}
+			if provider == "" {
+				provider = ProviderVast
+			}
+			targets[provider] = append(targets[provider], t)
+		}
+	} else {
+		for _, m := range manifests {
+			provider := m.Provider
+			if *providerName != "" {
+				provider = *providerName
+			}
+			for _, id := range m.Active() {
+				targets[provider] = append(targets[provider], target{id: id, manifest: m})
+			}
+		}
+	}
+
+	if len(targets) == 0 {
+		fmt.Println("Nothing to destroy: no live instances recorded")
+		if common.json() {
+			printJSON(newDestroyResult())
+		}
+		return
+	}
+
+	ctx := context.Background()
+	result := newDestroyResult()
+	touched := make(map[*RunManifest]bool)
+	for name, list := range targets {
+		provider, err := newProvider(name, common.options())
+		if err != nil {
+			log.Printf("Skipping %d instances: %v", len(list), err)
+			for _, t := range list {
+				result.Failed = append(result.Failed, t.id)
+			}
+			continue
+		}
+
+		// Для -run/-all пропускаем инстансы, которых у провайдера уже нет
+		live := make(map[int]bool)
+		checkLive := *ids == ""
+		if checkLive {
+			instances, err := provider.ListInstances(ctx)
+			if err != nil {
+				log.Printf("Could not list %s instances, trying to destroy all: %v", provider.Name(), err)
+				checkLive = false
+			}
+			for _, inst := range instances {
+				live[inst.ID] = true
+			}
+		}
+
+		for _, t := range list {
+			if checkLive && !live[t.id] {
+				fmt.Printf("Instance %d: already gone\n", t.id)
+				result.Gone = append(result.Gone, t.id)
+				if t.manifest != nil {
+					t.manifest.UpdateInstance(t.id, func(mi *ManifestInstance) { mi.setStatus(InstanceGone, "") })
+					touched[t.manifest] = true
+				}
+				continue
+			}
+			fmt.Printf("Destroying %s instance %d... ", provider.Name(), t.id)
+			if err := provider.DestroyInstance(ctx, t.id); err != nil {
+				fmt.Printf("FAILED: %v\n", err)
+				result.Failed = append(result.Failed, t.id)
+				continue
+			}
+			fmt.Printf("done\n")
+			result.Destroyed = append(result.Destroyed, t.id)
+			if t.manifest != nil {
+				t.manifest.MarkDestroyed(t.id, "destroy command")
+				touched[t.manifest] = true
+			}
+		}
+	}
+
+	var updated []*RunManifest
+	for m := range touched {
+		if err := m.Save(); err != nil {
+			log.Printf("Warning: could not save run manifest %s: %v", m.RunID, err)
+		}
+		updated = append(updated, m)
+	}
+
+	fmt.Printf("\nDestroyed: %d, failed: %d\n", len(result.Destroyed), len(result.Failed))
+	if len(updated) > 0 {
+		printCostSummary(updated...)
+		result.CostUSD, _ = totalCost(updated...)
+	}
+	if common.json() {
+		printJSON(result)
+	}
+	if len(result.Failed) > 0 {
+		os.Exit(1)
+	}
+}
+
+// sessionReportPattern находит отчеты сессий этого запуска: session_<ts>_run<RUN>_inst<ID>_<rand>/session_report.json
+func sessionReportPattern(runID string) *regexp.Regexp {
+	return regexp.MustCompile(`^session_[0-9_-]+_run` + regexp.QuoteMeta(runID) + `_inst(\d+)_[0-9a-f]+/session_report\.json$`)
+}
+
+// sessionReports ищет в хранилище отчеты завершенных сессий запуска. Раннер пишет отчет один раз,
+// в конце сессии, поэтому прочитанные отчеты кешируются по ключу и не скачиваются на каждом опросе
+type sessionReports struct {
+	store   storage.ArtifactStore
+	pattern *regexp.Regexp
+	read    map[string][2]string // ключ отчета -> (ID сессии, статус)
+}
+
+func newSessionReports(store storage.ArtifactStore, runID string) *sessionReports {
+	return &sessionReports{store: store, pattern: sessionReportPattern(runID), read: make(map[string][2]string)}
+}
+
+// sessionPrefixes - где в хранилище искать артефакты сессий инстансов ids: каталоги известных
+// сессий, а пока сессия хоть одного из них неизвестна - весь список session_. ID сессии
+// начинается со времени, поэтому сузить список до запуска по префиксу нельзя
+func sessionPrefixes(ids []int, session func(id int) string) []string {
+	var prefixes []string
+	for _, id := range ids {
+		sessionID := session(id)
+		if sessionID == "" {
+			return []string{"session_"}
+		}
+		prefixes = append(prefixes, sessionID+"/")
+	}
+	return prefixes
+}
+
+// completed возвращает для активных инстансов ID инстанса -> (ID сессии, статус).
+// Сессии, известные по пульсу, ищутся только в своих каталогах (sessionPrefixes)
+func (s *sessionReports) completed(ctx context.Context, manifest *RunManifest, active []int) (map[int][2]string, error) {
+	watched := make(map[int]bool, len(active))
+	for _, id := range active {
+		watched[id] = true
+	}
+	prefixes := sessionPrefixes(active, func(id int) string {
+		mi, _ := manifest.Instance(id)
+		return mi.SessionID
+	})
+
+	done := make(map[int][2]string)
+	for _, prefix := range prefixes {
+		objects, err := s.store.List(ctx, prefix)
+		if err != nil {
+			return done, err
+		}
+		for _, obj := range objects {
+			match := s.pattern.FindStringSubmatch(obj.Key)
+			if match == nil {
+				continue
+			}
+			id, _ := strconv.Atoi(match[1])
+			if !watched[id] {
+				continue
+			}
+			session, ok := s.read[obj.Key]
+			if !ok {
+				var report struct {
+					SessionID string `json:"session_id"`
+					Status    string `json:"status"`
+				}
+				// Отчет есть - сессия завершена, даже если прочитать его не удалось; такой перечитаем
+				data, err := storage.GetBytes(ctx, s.store, obj.Key)
+				if err == nil && json.Unmarshal(data, &report) == nil {
+					s.read[obj.Key] = [2]string{report.SessionID, report.Status}
+				}
+				session = [2]string{report.SessionID, report.Status}
+			}
+			done[id] = session
+		}
+	}
+	return done, nil
+}
+
+// runnerState проверяет по SSH процесс раннера: "running", "stopped" или "" при ошибке связи.
+// Шаблон [h]ighLoadTest не совпадает с командной строкой самой оболочки, которая выполняет проверку
+func runnerState(host string, port int) string {
+	mgr, err := sshSessions()
+	if err != nil {
+		return ""
+	}
+	output, err := mgr.Run(context.Background(), host, port, "",
+		"pgrep -f '[h]ighLoadTest' >/dev/null && echo running || echo stopped", 20*time.Second)
+	if err != nil {
+		return ""
+	}
+	return strings.TrimSpace(output)
+}
+
+// runnerStopped - процесс раннера завершился; ошибка связи - не завершение
+func runnerStopped(host string, port int) bool {
+	return runnerState(host, port) == "stopped"
+}
+
+func runnerRunning(host string, port int) bool {
+	return runnerState(host, port) == "running"
+}
+
+// autoDestroy следит за сессиями запуска и удаляет инстанс, когда его сессия завершилась
+// (отчет в хранилище или последний пульс раннера), тесты на нем не стартовали или истекло maxLifetime.
+// Потерянные по пульсу инстансы только отмечаются: их удалит maxLifetime
+func autoDestroy(ctx context.Context, provider Provider, manifest *RunManifest, store storage.ArtifactStore,
+	heartbeat *HeartbeatConfig, maxLifetime, pollInterval time.Duration) {
+	fmt.Printf("\n=== AUTO-DESTROY ===\n")
+	fmt.Printf("Watching %d instances (max lifetime %v)\n", len(manifest.Active()), maxLifetime)
+
+	destroy := func(id int, reason string) {
+		fmt.Printf("[%s] Destroying instance %d: %s\n", time.Now().Format("15:04:05"), id, reason)
+		if err := provider.DestroyInstance(ctx, id); err != nil {
+			fmt.Printf("[%s] Failed to destroy instance %d: %v\n", time.Now().Format("15:04:05"), id, err)
+			return
+		}
+		manifest.MarkDestroyed(id, reason)
+	}
+
+	var reports *sessionReports
+	if store != nil {
+		reports = newSessionReports(store, manifest.RunID)
+	}
+	for {
+		if enforceBudget(ctx, provider, manifest) {
+			break
+		}
+		active := manifest.Active()
+		if len(active) == 0 {
+			break
+		}
+
+		// Пульсы раньше отчетов: по ним известны ID сессий, в каталогах которых искать отчеты
+		trackHeartbeats(manifest, heartbeat)
+		var done map[int][2]string
+		if reports != nil {
+			var err error
+			done, err = reports.completed(ctx, manifest, active)
+			if err != nil {
+				fmt.Printf("[%s] Could not check session reports: %v\n", time.Now().Format("15:04:05"), err)
+			}
+		}
+
+		for _, id := range active {
+			inst, _ := manifest.Instance(id)
+			if session, ok := done[id]; ok {
+				manifest.UpdateInstance(id, func(mi *ManifestInstance) {
+					mi.SessionID = session[0]
+					mi.SessionStatus = session[1]
+				})
+				manifest.SetStage(id, StageDone, "")
+				destroy(id, fmt.Sprintf("session completed (%s)", session[1]))
+				continue
+			}
+			switch {
+			case inst.Heartbeat != nil && inst.Heartbeat.final() && inst.Stage == StageDone:
+				destroy(id, fmt.Sprintf("session completed (%s)", inst.Heartbeat.Status))
+			case inst.Heartbeat != nil && inst.Heartbeat.final() && inst.Stage == StageFailed:
+				destroy(id, inst.Error)
+			case !inst.TestStarted:
+				manifest.SetStage(id, StageFailed, "tests were not started")
+				destroy(id, "tests were not started")
+			case time.Since(inst.CreatedAt) > maxLifetime:
+				destroy(id, "max lifetime exceeded")
+			case store == nil && inst.SSHHost != "" && runnerStopped(inst.SSHHost, inst.SSHPort):
+				manifest.SetStage(id, StageDone, "")
+				destroy(id, "runner process exited")
+			}
+		}
+
+		if err := manifest.Save(); err != nil {
+			log.Printf("Warning: could not save run manifest: %v", err)
+		}
+		remaining := len(manifest.Active())
+		if remaining == 0 {
+			break
+		}
+		lost := 0
+		for _, id := range manifest.Active() {
+			if mi, _ := manifest.Instance(id); mi.HeartbeatLost {
+				lost++
+			}
+		}
+		if lost > 0 {
+			fmt.Printf("[%s] %d instances still running, %d of them lost (no heartbeat)\n", time.Now().Format("15:04:05"), remaining, lost)
+		} else {
+			fmt.Printf("[%s] %d instances still running\n", time.Now().Format("15:04:05"), remaining)
+		}
+
+		select {
+		case <-time.After(pollInterval):
+		case <-ctx.Done():
+			return
+		}
+	}
+
+	fmt.Printf("All instances of run %s destroyed\n", manifest.RunID)
+}
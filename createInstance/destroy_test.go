@@ -0,0 +1,137 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"flag"
+	"io"
+	"sync"
+	"testing"
+	"time"
+
+	"highloadtest/storage"
+)
+
+// countingStore считает обращения к хранилищу
+type countingStore struct {
+	storage.ArtifactStore
+	mu    sync.Mutex
+	lists []string
+	gets  map[string]int
+}
+
+func (s *countingStore) List(ctx context.Context, prefix string) ([]storage.ObjectInfo, error) {
+	s.mu.Lock()
+	s.lists = append(s.lists, prefix)
+	s.mu.Unlock()
+	return s.ArtifactStore.List(ctx, prefix)
+}
+
+func (s *countingStore) Get(ctx context.Context, key string) (io.ReadCloser, error) {
+	s.mu.Lock()
+	s.gets[key]++
+	s.mu.Unlock()
+	return s.ArtifactStore.Get(ctx, key)
+}
+
+func TestSessionReportsCachesAndScopesLookups(t *testing.T) {
+	local, err := storage.NewLocalStore(storage.LocalConfig{Dir: t.TempDir()})
+	if err != nil {
+		t.Fatal(err)
+	}
+	store := &countingStore{ArtifactStore: local, gets: make(map[string]int)}
+	ctx := context.Background()
+	put := func(key, data string) {
+		t.Helper()
+		if err := local.Put(ctx, key, bytes.NewReader([]byte(data)), int64(len(data))); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	manifest := NewRunManifest("r1", ProviderFake, flag.NewFlagSet("run", flag.ContinueOnError), nil)
+	for _, id := range []int{1, 2} {
+		manifest.AddInstance(Offer{}, &Instance{ID: id}, time.Now())
+	}
+	done1 := "session_2026-10-18_12-00-00_runr1_inst1_0a1b"
+	put(done1+"/session_report.json", `{"session_id":"`+done1+`","status":"completed"}`)
+	put(done1+"/screenshot_001.png", "png")
+	put("session_2026-10-18_12-00-00_runr2_inst1_0c1d/session_report.json", `{"status":"completed"}`)
+
+	reports := newSessionReports(store, manifest.RunID)
+	for poll := 0; poll < 3; poll++ {
+		done, err := reports.completed(ctx, manifest, []int{1, 2})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(done) != 1 || done[1] != [2]string{done1, "completed"} {
+			t.Fatalf("poll %d: done = %v", poll, done)
+		}
+	}
+	if len(store.gets) != 1 || store.gets[done1+"/session_report.json"] != 1 {
+		t.Fatalf("report downloads = %v, want one download of the run's report", store.gets)
+	}
+
+	// Когда ID сессий известны по пульсу, читаются только их каталоги
+	manifest.UpdateInstance(1, func(mi *ManifestInstance) { mi.SessionID = done1 })
+	manifest.UpdateInstance(2, func(mi *ManifestInstance) { mi.SessionID = "session_2026-10-18_12-00-01_runr1_inst2_0e1f" })
+	store.lists = nil
+	if _, err := reports.completed(ctx, manifest, []int{1, 2}); err != nil {
+		t.Fatal(err)
+	}
+	want := []string{done1 + "/", "session_2026-10-18_12-00-01_runr1_inst2_0e1f/"}
+	if len(store.lists) != 2 || store.lists[0] != want[0] || store.lists[1] != want[1] {
+		t.Fatalf("listed prefixes = %q, want %q", store.lists, want)
+	}
+}
+
+// TestDashboardScopesSessionListing: таблица читает весь список session_, только пока сессия
+// какого-то живого инстанса не найдена, а дальше - каталоги известных сессий
+func TestDashboardScopesSessionListing(t *testing.T) {
+	local, err := storage.NewLocalStore(storage.LocalConfig{Dir: t.TempDir()})
+	if err != nil {
+		t.Fatal(err)
+	}
+	store := &countingStore{ArtifactStore: local, gets: make(map[string]int)}
+	ctx := context.Background()
+	put := func(key string) {
+		t.Helper()
+		if err := local.Put(ctx, key, bytes.NewReader([]byte("png")), 3); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	manifest := NewRunManifest("r1", ProviderFake, flag.NewFlagSet("run", flag.ContinueOnError), nil)
+	for _, id := range []int{1, 2, 3} {
+		manifest.AddInstance(Offer{}, &Instance{ID: id}, time.Now())
+	}
+	session1 := "session_2026-10-18_12-00-00_runr1_inst1_0a1b"
+	session2 := "session_2026-10-18_12-00-01_runr1_inst2_0c1d"
+	put(session1 + "/screenshot_001.png")
+	put(session1 + "/screenshot_002.png")
+	put(session2 + "/screenshot_001.png")
+	manifest.UpdateInstance(2, func(mi *ManifestInstance) { mi.SessionID = session2 })
+	d := &Dashboard{manifest: manifest, store: store}
+
+	// Сессия инстанса 1 еще не найдена, у инстанса 3 ее нет вовсе
+	d.refreshSessions()
+	if len(store.lists) != 1 || store.lists[0] != "session_" {
+		t.Fatalf("first poll listed %q, want the whole session_ list", store.lists)
+	}
+	if d.sessions[1] != (sessionProgress{session1, 2}) || d.sessions[2] != (sessionProgress{session2, 1}) {
+		t.Fatalf("sessions = %v", d.sessions)
+	}
+
+	// Инстанс 3 удален: дальше читаются только каталоги найденных сессий
+	now := time.Now()
+	manifest.UpdateInstance(3, func(mi *ManifestInstance) { mi.DestroyedAt = &now })
+	store.lists = nil
+	put(session1 + "/screenshot_003.png")
+	d.refreshSessions()
+	want := []string{session1 + "/", session2 + "/"}
+	if len(store.lists) != 2 || store.lists[0] != want[0] || store.lists[1] != want[1] {
+		t.Fatalf("listed prefixes = %q, want %q", store.lists, want)
+	}
+	if d.sessions[1].Screenshots != 3 {
+		t.Fatalf("sessions = %v, want 3 screenshots for instance 1", d.sessions)
+	}
+}
@@ -0,0 +1,230 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+	"os/exec"
+	"runtime"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+const (
+	dockerLabel      = "highloadtest.instance"
+	dockerNamePrefix = "hlt-"
+	dockerSlots      = 8
+)
+
+// dockerSSHCommand поднимает sshd в чистом образе и пускает root по ключу из SSH_PUBLIC_KEY
+const dockerSSHCommand = `set -e
+export DEBIAN_FRONTEND=noninteractive
+if ! command -v sshd >/dev/null; then apt-get update -qq && apt-get install -y -qq openssh-server sudo wget >/dev/null; fi
+mkdir -p /root/.ssh /run/sshd
+echo "$SSH_PUBLIC_KEY" > /root/.ssh/authorized_keys
+chmod 700 /root/.ssh && chmod 600 /root/.ssh/authorized_keys
+exec /usr/sbin/sshd -D -e`
+
+func dockerImage() string {
+	if v := os.Getenv("HLT_DOCKER_IMAGE"); v != "" {
+		return v
+	}
+	return "ubuntu:22.04"
+}
+
+// DockerProvider запускает "инстансы" локальными контейнерами Docker или Podman
+// с опубликованным SSH портом, чтобы пул можно было прогнать на ноутбуке
+type DockerProvider struct {
+	binary string
+	image  string
+	gpus   string
+
+	mu sync.Mutex
+}
+
+func NewDockerProvider(binary, image string) (*DockerProvider, error) {
+	if _, err := exec.LookPath(binary); err != nil {
+		return nil, fmt.Errorf("%s is not installed: %v", binary, err)
+	}
+	return &DockerProvider{
+		binary: binary,
+		image:  image,
+		gpus:   os.Getenv("HLT_DOCKER_GPUS"),
+	}, nil
+}
+
+func (d *DockerProvider) Name() string {
+	return d.binary
+}
+
+func (d *DockerProvider) run(ctx context.Context, args ...string) (string, error) {
+	cmd := exec.CommandContext(ctx, d.binary, args...)
+	output, err := cmd.CombinedOutput()
+	if err != nil {
+		return "", fmt.Errorf("%s %s: %v\nOutput: %s", d.binary, args[0], err, strings.TrimSpace(string(output)))
+	}
+	return strings.TrimSpace(string(output)), nil
+}
+
+// SearchOffers возвращает свободные локальные слоты; цена нулевая
+func (d *DockerProvider) SearchOffers(ctx context.Context, query OfferQuery) ([]Offer, error) {
+	ids, err := d.containerIDs(ctx)
+	if err != nil {
+		return nil, err
+	}
+	used := make(map[int]bool)
+	for _, id := range ids {
+		used[id] = true
+	}
+
+	limit := dockerSlots
+	if query.Limit > limit {
+		limit = query.Limit
+	}
+	gpu := "local-" + d.binary
+	if d.gpus != "" {
+		gpu += " (gpus " + d.gpus + ")"
+	}
+	var offers []Offer
+	for slot := 1; slot <= limit; slot++ {
+		if used[slot] {
+			continue
+		}
+		offers = append(offers, Offer{
+			ID:           slot,
+			GPUName:      gpu,
+			NumGPUs:      0,
+			DiskSpace:    query.MinDiskGB,
+			Rentable:     true,
+			Verification: "verified",
+			Reliability:  1,
+			CPUCores:     float64(runtime.NumCPU()),
+		})
+	}
+	return offers, nil
+}
+
+// CreateInstance запускает контейнер; ID инстанса совпадает с номером слота
+func (d *DockerProvider) CreateInstance(ctx context.Context, offer Offer) (*Instance, error) {
+	pubKey, err := getOrCreateSSHKey()
+	if err != nil {
+		return nil, fmt.Errorf("failed to get SSH key: %v", err)
+	}
+
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	args := []string{"run", "-d",
+		"--name", dockerNamePrefix + strconv.Itoa(offer.ID),
+		"--label", fmt.Sprintf("%s=%d", dockerLabel, offer.ID),
+		"-p", "127.0.0.1::22",
+		"--shm-size", "1g",
+		"-e", "SSH_PUBLIC_KEY=" + strings.TrimSpace(pubKey),
+	}
+	if d.gpus != "" {
+		args = append(args, "--gpus", d.gpus)
+	}
+	args = append(args, d.image, "bash", "-c", dockerSSHCommand)
+
+	if _, err := d.run(ctx, args...); err != nil {
+		return nil, fmt.Errorf("failed to start container: %v", err)
+	}
+	return &Instance{ID: offer.ID}, nil
+}
+
+type dockerInspect struct {
+	State struct {
+		Status string `json:"Status"`
+	} `json:"State"`
+	NetworkSettings struct {
+		Ports map[string][]PortBinding `json:"Ports"`
+	} `json:"NetworkSettings"`
+}
+
+func (d *DockerProvider) GetInstance(ctx context.Context, id int) (*Instance, error) {
+	output, err := d.run(ctx, "inspect", dockerNamePrefix+strconv.Itoa(id))
+	if err != nil {
+		return nil, fmt.Errorf("failed to get instance info: %v", err)
+	}
+	var info []dockerInspect
+	if err := json.Unmarshal([]byte(output), &info); err != nil || len(info) == 0 {
+		return nil, fmt.Errorf("unexpected inspect output for instance %d", id)
+	}
+
+	instance := &Instance{ID: id, GPUName: "local-" + d.binary}
+	switch info[0].State.Status {
+	case "running":
+		instance.Status = StatusRunning
+	case "created", "restarting":
+		instance.Status = StatusLoading
+	case "exited", "dead", "removing":
+		instance.Status = StatusExited
+	default:
+		instance.Status = info[0].State.Status
+	}
+	instance.Ports = info[0].NetworkSettings.Ports
+	if instance.Status == StatusRunning {
+		for _, binding := range info[0].NetworkSettings.Ports["22/tcp"] {
+			if port, err := strconv.Atoi(binding.HostPort); err == nil {
+				instance.SSHHost = "127.0.0.1"
+				instance.SSHPort = port
+				instance.PublicIPAddr = instance.SSHHost
+				break
+			}
+		}
+	}
+	return instance, nil
+}
+
+func (d *DockerProvider) DestroyInstance(ctx context.Context, id int) error {
+	if _, err := d.run(ctx, "rm", "-f", dockerNamePrefix+strconv.Itoa(id)); err != nil {
+		return fmt.Errorf("failed to destroy instance %d: %v", id, err)
+	}
+	return nil
+}
+
+func (d *DockerProvider) containerIDs(ctx context.Context) ([]int, error) {
+	output, err := d.run(ctx, "ps", "-a", "--filter", "label="+dockerLabel,
+		"--format", fmt.Sprintf(`{{index .Labels "%s"}}`, dockerLabel))
+	if err != nil {
+		return nil, fmt.Errorf("failed to list containers: %v", err)
+	}
+	var ids []int
+	for _, line := range strings.Split(output, "\n") {
+		if id, err := strconv.Atoi(strings.TrimSpace(line)); err == nil {
+			ids = append(ids, id)
+		}
+	}
+	sort.Ints(ids)
+	return ids, nil
+}
+
+func (d *DockerProvider) ListInstances(ctx context.Context) ([]*Instance, error) {
+	ids, err := d.containerIDs(ctx)
+	if err != nil {
+		return nil, err
+	}
+	var instances []*Instance
+	for _, id := range ids {
+		instance, err := d.GetInstance(ctx, id)
+		if err != nil {
+			return nil, err
+		}
+		instances = append(instances, instance)
+	}
+	return instances, nil
+}
+
+func (d *DockerProvider) SSHEndpoint(ctx context.Context, id int) (SSHEndpoint, error) {
+	instance, err := d.GetInstance(ctx, id)
+	if err != nil {
+		return SSHEndpoint{}, err
+	}
+	if instance.SSHPort == 0 {
+		return SSHEndpoint{}, fmt.Errorf("instance %d is %s, SSH port not published", id, instance.Status)
+	}
+	return SSHEndpoint{Host: instance.SSHHost, Port: instance.SSHPort, User: "root"}, nil
+}
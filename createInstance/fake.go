@@ -0,0 +1,193 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"sort"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// FakeProvider держит инстансы в памяти: для прогона логики пула без аренды машин.
+// Инстанс проходит created -> loading -> running по таймеру; SSH указывает на
+// HLT_FAKE_SSH_HOST:HLT_FAKE_SSH_PORT (по умолчанию 127.0.0.1:22)
+type FakeProvider struct {
+	LoadingAfter time.Duration
+	RunningAfter time.Duration
+
+	mu        sync.Mutex
+	offers    []Offer
+	instances map[int]*fakeInstance
+	nextID    int
+	ssh       SSHEndpoint
+}
+
+type fakeInstance struct {
+	offer     Offer
+	createdAt time.Time
+	destroyed bool
+}
+
+func NewFakeProvider() *FakeProvider {
+	ssh := SSHEndpoint{Host: "127.0.0.1", Port: 22, User: "root"}
+	if v := os.Getenv("HLT_FAKE_SSH_HOST"); v != "" {
+		ssh.Host = v
+	}
+	if v := os.Getenv("HLT_FAKE_SSH_PORT"); v != "" {
+		if port, err := strconv.Atoi(v); err == nil {
+			ssh.Port = port
+		}
+	}
+
+	gpus := []string{"RTX 3060", "RTX 3070", "RTX A4000", "RTX 4070", "RTX 3090", "RTX 4090"}
+	locations := []string{"Oregon, US", "Sweden, SE", "Quebec, CA", "Bavaria, DE", "Texas, US"}
+	var offers []Offer
+	for i := 0; i < 30; i++ {
+		verification := "unverified"
+		if i%3 == 0 {
+			verification = "verified"
+		}
+		offers = append(offers, Offer{
+			ID:           900000 + i,
+			GPUName:      gpus[i%len(gpus)],
+			NumGPUs:      1 + i%2,
+			DiskSpace:    32 + float64(i%4)*16,
+			DPHTotal:     0.08 + float64(i%12)*0.05,
+			CudaMaxGood:  12.4,
+			Rentable:     true,
+			MinBid:       0.05 + float64(i%12)*0.03,
+			Verification: verification,
+			Reliability:  0.90 + float64(i%10)*0.01,
+			InetDown:     100 + float64(i%7)*150,
+			InetUp:       50 + float64(i%5)*100,
+			Geolocation:  locations[i%len(locations)],
+			CPUCores:     4 + float64(i%4)*4,
+			CPURAM:       16384 * float64(1+i%4),
+		})
+	}
+
+	return &FakeProvider{
+		LoadingAfter: 2 * time.Second,
+		RunningAfter: 10 * time.Second,
+		offers:       offers,
+		instances:    make(map[int]*fakeInstance),
+		nextID:       1000,
+		ssh:          ssh,
+	}
+}
+
+func (f *FakeProvider) Name() string {
+	return ProviderFake
+}
+
+func (f *FakeProvider) SearchOffers(ctx context.Context, query OfferQuery) ([]Offer, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	var result []Offer
+	for _, offer := range f.offers {
+		if query.VerifiedOnly && offer.Verification != "verified" {
+			continue
+		}
+		if offer.DiskSpace < query.MinDiskGB {
+			continue
+		}
+		result = append(result, offer)
+	}
+	sort.Slice(result, func(i, j int) bool { return result[i].DPHTotal < result[j].DPHTotal })
+	if query.Limit > 0 && len(result) > query.Limit {
+		result = result[:query.Limit]
+	}
+	return result, nil
+}
+
+func (f *FakeProvider) CreateInstance(ctx context.Context, offer Offer) (*Instance, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	for i, o := range f.offers {
+		if o.ID == offer.ID {
+			// Предложение занято, пока инстанс не удален
+			f.offers = append(f.offers[:i], f.offers[i+1:]...)
+			f.nextID++
+			f.instances[f.nextID] = &fakeInstance{offer: offer, createdAt: time.Now()}
+			return &Instance{ID: f.nextID}, nil
+		}
+	}
+	return nil, fmt.Errorf("offer %d is no longer available", offer.ID)
+}
+
+func (f *FakeProvider) GetInstance(ctx context.Context, id int) (*Instance, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	inst, ok := f.instances[id]
+	if !ok {
+		return nil, fmt.Errorf("instance %d not found", id)
+	}
+	return f.describe(id, inst), nil
+}
+
+func (f *FakeProvider) describe(id int, inst *fakeInstance) *Instance {
+	result := &Instance{
+		ID:        id,
+		DiskSpace: inst.offer.DiskSpace,
+		GPUName:   inst.offer.GPUName,
+		NumGPUs:   inst.offer.NumGPUs,
+	}
+	age := time.Since(inst.createdAt)
+	switch {
+	case inst.destroyed:
+		result.Status = StatusExited
+	case age >= f.RunningAfter:
+		result.Status = StatusRunning
+		result.SSHHost = f.ssh.Host
+		result.SSHPort = f.ssh.Port
+		result.PublicIPAddr = f.ssh.Host
+	case age >= f.LoadingAfter:
+		result.Status = StatusLoading
+	default:
+		result.Status = StatusCreated
+	}
+	return result
+}
+
+func (f *FakeProvider) DestroyInstance(ctx context.Context, id int) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	inst, ok := f.instances[id]
+	if !ok || inst.destroyed {
+		return fmt.Errorf("instance %d not found", id)
+	}
+	inst.destroyed = true
+	f.offers = append(f.offers, inst.offer)
+	return nil
+}
+
+func (f *FakeProvider) ListInstances(ctx context.Context) ([]*Instance, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	var result []*Instance
+	for id, inst := range f.instances {
+		if !inst.destroyed {
+			result = append(result, f.describe(id, inst))
+		}
+	}
+	sort.Slice(result, func(i, j int) bool { return result[i].ID < result[j].ID })
+	return result, nil
+}
+
+func (f *FakeProvider) SSHEndpoint(ctx context.Context, id int) (SSHEndpoint, error) {
+	inst, err := f.GetInstance(ctx, id)
+	if err != nil {
+		return SSHEndpoint{}, err
+	}
+	if inst.Status != StatusRunning {
+		return SSHEndpoint{}, fmt.Errorf("instance %d is %s", id, inst.Status)
+	}
+	return f.ssh, nil
+}
@@ -0,0 +1,170 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"highloadtest/storage"
+)
+
+// useTestSSHSessions подменяет общий SSHManager на время теста
+func useTestSSHSessions(t *testing.T, mgr *SSHManager) {
+	t.Helper()
+	sshSessionsOnce.Do(func() {})
+	prevMgr, prevErr := sshSessionsMgr, sshSessionsErr
+	sshSessionsMgr, sshSessionsErr = mgr, nil
+	t.Cleanup(func() { sshSessionsMgr, sshSessionsErr = prevMgr, prevErr })
+}
+
+// testRunnerBundle - вместо раннера скрипт, который живет несколько секунд и кладет
+// отчет сессии в локальное хранилище storeDir, как настоящий раннер в удаленное
+func testRunnerBundle(t *testing.T, storeDir string) *RunnerBundle {
+	t.Helper()
+	dir := t.TempDir()
+	runner := fmt.Sprintf(`#!/bin/sh
+sleep 3
+session=session_2026-10-18_12-00-00_run$2_inst$1_0a1b
+mkdir -p %[1]s/$session
+printf '{"session_id":"%%s","status":"completed"}' $session > %[1]s/$session/session_report.json
+`, storeDir)
+	files := map[string]string{
+		remoteRunnerBinary: runner,
+		remoteStartScript:  "#!/bin/sh\nexec ./" + remoteRunnerBinary + " \"$@\"\n",
+	}
+	bundle := &RunnerBundle{}
+	for _, remote := range []string{remoteRunnerBinary, remoteStartScript} {
+		local := filepath.Join(dir, remote)
+		if err := os.WriteFile(local, []byte(files[remote]), 0755); err != nil {
+			t.Fatal(err)
+		}
+		sum, size, err := fileSHA256(local)
+		if err != nil {
+			t.Fatal(err)
+		}
+		bundle.Files = append(bundle.Files, RunnerFile{Local: local, Remote: remote, Size: size, SHA256: sum, Mode: 0755})
+	}
+	return bundle
+}
+
+// TestFakeProviderPoolEndToEnd проводит инстанс фейкового провайдера через весь цикл:
+// аренда, этапы до testing с развертыванием по SSH, завершение сессии и удаление
+func TestFakeProviderPoolEndToEnd(t *testing.T) {
+	for _, tool := range []string{"pgrep", "sha256sum"} {
+		if _, err := exec.LookPath(tool); err != nil {
+			t.Skipf("%s is not installed", tool)
+		}
+	}
+	t.Setenv("HOME", t.TempDir())
+	t.Chdir(t.TempDir())
+	prevInterval := lifecyclePollInterval
+	lifecyclePollInterval = 200 * time.Millisecond
+	t.Cleanup(func() { lifecyclePollInterval = prevInterval })
+
+	mgr, clientKey := newTestSSHManager(t)
+	useTestSSHSessions(t, mgr)
+	srv := startTestSSHServer(t, "127.0.0.1:0", clientKey.PublicKey(), nil, t.TempDir())
+
+	provider := NewFakeProvider()
+	provider.LoadingAfter = 0
+	provider.RunningAfter = 300 * time.Millisecond
+	provider.ssh = SSHEndpoint{Host: srv.host, Port: srv.port, User: "root"}
+
+	store, err := storage.NewLocalStore(storage.LocalConfig{Dir: t.TempDir()})
+	if err != nil {
+		t.Fatal(err)
+	}
+	ctx := context.Background()
+
+	fs := flag.NewFlagSet("run", flag.ContinueOnError)
+	lifecycle := addLifecycleFlags(fs)
+	if err := fs.Parse([]string{"-ready-checks="}); err != nil {
+		t.Fatal(err)
+	}
+	runID := newRunID()
+	manifest := NewRunManifest(runID, provider.Name(), fs, nil)
+
+	offers, err := provider.SearchOffers(ctx, OfferQuery{Limit: 2})
+	if err != nil || len(offers) != 2 {
+		t.Fatalf("SearchOffers = %v, %v", offers, err)
+	}
+	manifest.SetOffers(offers[:1])
+	manifest.SetCandidates(offers[1:])
+
+	// Аренда
+	created := createInstances(ctx, provider, offers[:1], manifest, 1)
+	if len(created) != 1 {
+		t.Fatalf("created %d instances, want 1", len(created))
+	}
+	id := created[0].ID
+	if mi, _ := manifest.Instance(id); mi.Stage != StageCreated {
+		t.Fatalf("stage after create = %s, want %s", mi.Stage, StageCreated)
+	}
+
+	// Пул доводит инстанс до testing: статусы провайдера, проверка SSH, заливка и запуск раннера
+	supervisor := &poolSupervisor{
+		client:    provider,
+		manifest:  manifest,
+		lifecycle: lifecycle,
+		budget:    &Budget{},
+		bundle:    testRunnerBundle(t, store.Root()),
+		target:    1,
+		parallel:  1,
+		ids:       []int{id},
+	}
+	ready := supervisor.Run(ctx, time.Minute)
+	mi, _ := manifest.Instance(id)
+	if len(ready) != 1 || mi.Stage != StageTesting || !mi.TestStarted {
+		t.Fatalf("after supervise: ready %v, stage %s, test started %v, error %q", ready, mi.Stage, mi.TestStarted, mi.Error)
+	}
+	if got, want := len(manifest.Runner), 2; got != want {
+		t.Fatalf("manifest lists %d runner files, want %d", got, want)
+	}
+
+	// Отчет сессии в хранилище завершает инстанс и удаляет его
+	autoDestroy(ctx, provider, manifest, store, nil, time.Hour, lifecyclePollInterval)
+	if live, _ := provider.ListInstances(ctx); len(live) != 0 {
+		t.Fatalf("instances still live at the provider: %v", live)
+	}
+
+	// Манифест на диске - тот, по которому работает --resume
+	data, err := os.ReadFile(filepath.Join("runs", runID, "run_manifest.json"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	var saved RunManifest
+	if err := json.Unmarshal(data, &saved); err != nil {
+		t.Fatal(err)
+	}
+	if len(saved.Instances) != 1 {
+		t.Fatalf("saved manifest has %d instances", len(saved.Instances))
+	}
+	got := saved.Instances[0]
+	if got.Stage != StageDone || got.Status != InstanceDestroyed || got.DestroyedAt == nil || got.Error != "" {
+		t.Fatalf("saved instance: stage %s, status %s, destroyed at %v, error %q", got.Stage, got.Status, got.DestroyedAt, got.Error)
+	}
+	wantSession := fmt.Sprintf("session_2026-10-18_12-00-00_run%s_inst%d_0a1b", runID, id)
+	if got.SessionID != wantSession || got.SessionStatus != "completed" {
+		t.Fatalf("session = %s (%s), want %s (completed)", got.SessionID, got.SessionStatus, wantSession)
+	}
+	if got.DestroyReason != "session completed (completed)" {
+		t.Fatalf("destroy reason = %q", got.DestroyReason)
+	}
+
+	var stages []string
+	for _, h := range got.History {
+		if h.Stage != "" && (len(stages) == 0 || stages[len(stages)-1] != h.Stage) {
+			stages = append(stages, h.Stage)
+		}
+	}
+	want := []string{StageRequested, StageCreated, StageLoading, StageRunning, StageSSHReady, StageDeployed, StageTesting, StageDone}
+	if fmt.Sprint(stages) != fmt.Sprint(want) {
+		t.Fatalf("stage history = %v, want %v", stages, want)
+	}
+}
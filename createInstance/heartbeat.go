@@ -0,0 +1,361 @@
+package main
+
+import (
+	"bytes"
+	"crypto/rand"
+	"crypto/subtle"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Фазы пульса раннера (heartbeat.go в highLoadTest); done и failed - последний пульс сессии
+const (
+	PhaseDone   = "done"
+	PhaseFailed = "failed"
+)
+
+// Heartbeat - пульс раннера; хранится последний в runs/<run>/heartbeats/<instance>.json
+type Heartbeat struct {
+	SessionID   string           `json:"session_id"`
+	RunID       string           `json:"run_id"`
+	InstanceID  string           `json:"instance_id"`
+	Phase       string           `json:"phase"`
+	Screenshots int              `json:"screenshots"`
+	Errors      int              `json:"errors"`
+	LastError   string           `json:"last_error,omitempty"`
+	Status      string           `json:"status,omitempty"`
+	Stream      *HeartbeatStream `json:"stream,omitempty"`
+	StartedAt   time.Time        `json:"started_at"`
+	SentAt      time.Time        `json:"sent_at"`
+	ReceivedAt  time.Time        `json:"received_at"`
+}
+
+// HeartbeatStream - метрики стрима из последнего опроса getStats()
+type HeartbeatStream struct {
+	FPS           float64 `json:"fps"`
+	BitrateKbps   float64 `json:"bitrate_kbps"`
+	Width         int     `json:"width"`
+	Height        int     `json:"height"`
+	PacketLossPct float64 `json:"packet_loss_pct"`
+	RTTMs         float64 `json:"rtt_ms"`
+	JitterMs      float64 `json:"jitter_ms"`
+}
+
+func (hb *Heartbeat) final() bool {
+	return hb.Phase == PhaseDone || hb.Phase == PhaseFailed
+}
+
+func (hb *Heartbeat) String() string {
+	text := fmt.Sprintf("%s, %d screenshots", hb.Phase, hb.Screenshots)
+	if hb.Status != "" {
+		text += ", status " + hb.Status
+	}
+	if hb.Stream != nil && hb.Stream.FPS > 0 {
+		text += fmt.Sprintf(", %.1f fps, %.0f kbps", hb.Stream.FPS, hb.Stream.BitrateKbps)
+	}
+	if hb.LastError != "" {
+		text += ", last error: " + hb.LastError
+	}
+	return text
+}
+
+// HeartbeatConfig - куда раннеры шлют пульс и через сколько молчащий инстанс считается потерянным.
+// Пульс подписывается секретом запуска (heartbeatToken), а не токеном API
+type HeartbeatConfig struct {
+	URL      string
+	Listen   string
+	Interval time.Duration
+	Timeout  time.Duration
+	Grace    time.Duration
+}
+
+func addHeartbeatFlags(fs *flag.FlagSet) *HeartbeatConfig {
+	c := &HeartbeatConfig{}
+	fs.StringVar(&c.URL, "heartbeat-url", "", "URL the runners POST heartbeats to, reachable from the instances (default: none, heartbeats off)")
+	fs.StringVar(&c.Listen, "heartbeat-listen", "", "address to receive heartbeats on in this process, e.g. :8090 (serve receives them on its API)")
+	fs.DurationVar(&c.Interval, "heartbeat-interval", 15*time.Second, "how often the runners send heartbeats")
+	fs.DurationVar(&c.Timeout, "heartbeat-timeout", 2*time.Minute, "mark a testing instance lost when its heartbeats stop for this long")
+	fs.DurationVar(&c.Grace, "heartbeat-grace", 15*time.Minute, "how long a deployed instance may go without its first heartbeat (start.sh installs Chrome first)")
+	return c
+}
+
+func (c *HeartbeatConfig) Validate() error {
+	if c.URL == "" {
+		if c.Listen != "" {
+			return fmt.Errorf("-heartbeat-listen requires -heartbeat-url with the address the instances reach it at")
+		}
+		return nil
+	}
+	if err := checkHeartbeatURL(c.URL); err != nil {
+		return err
+	}
+	if c.Interval <= 0 || c.Timeout <= 0 || c.Grace <= 0 {
+		return fmt.Errorf("-heartbeat-interval, -heartbeat-timeout and -heartbeat-grace must be positive")
+	}
+	if c.Timeout < 2*c.Interval {
+		return fmt.Errorf("-heartbeat-timeout %v must be at least twice -heartbeat-interval %v", c.Timeout, c.Interval)
+	}
+	return nil
+}
+
+func checkHeartbeatURL(raw string) error {
+	u, err := url.Parse(raw)
+	if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		return fmt.Errorf("-heartbeat-url %q must be an absolute http(s) URL", raw)
+	}
+	return nil
+}
+
+func (c *HeartbeatConfig) enabled() bool {
+	return c != nil && c.URL != ""
+}
+
+// env - переменные для start.sh, раннер читает их поверх session.yaml. Токен в них - секрет
+// запуска runID, который годится только для POST /heartbeats этого запуска
+func (c *HeartbeatConfig) env(runID string) (string, error) {
+	if !c.enabled() {
+		return "", nil
+	}
+	token, err := heartbeatToken(runID)
+	if err != nil {
+		return "", err
+	}
+	return "HLT_HEARTBEAT_URL=" + shellQuote(c.URL) + " HLT_HEARTBEAT_INTERVAL=" + c.Interval.String() +
+		" HLT_HEARTBEAT_TOKEN=" + shellQuote(token) + " ", nil
+}
+
+func heartbeatTokenPath(runID string) string {
+	return filepath.Join("runs", runID, "heartbeat.token")
+}
+
+// heartbeatToken возвращает секрет пульса запуска и создает его при первом развертывании.
+// Файл создается с O_EXCL: параллельные развертывания и повторный deploy получают один секрет
+func heartbeatToken(runID string) (string, error) {
+	path := heartbeatTokenPath(runID)
+	if data, err := os.ReadFile(path); err == nil {
+		return strings.TrimSpace(string(data)), nil
+	}
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return "", err
+	}
+	secret := make([]byte, 32)
+	if _, err := rand.Read(secret); err != nil {
+		return "", err
+	}
+	token := hex.EncodeToString(secret)
+	f, err := os.OpenFile(path, os.O_CREATE|os.O_EXCL|os.O_WRONLY, 0600)
+	if errors.Is(err, os.ErrExist) {
+		return heartbeatToken(runID)
+	}
+	if err != nil {
+		return "", fmt.Errorf("failed to create heartbeat token: %v", err)
+	}
+	_, err = f.WriteString(token)
+	if closeErr := f.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		return "", fmt.Errorf("failed to write heartbeat token: %v", err)
+	}
+	return token, nil
+}
+
+// checkHeartbeatToken сверяет Bearer токен пульса с секретом его запуска; без секрета пульс не принимается
+func checkHeartbeatToken(r *http.Request, runID string) bool {
+	want, err := os.ReadFile(heartbeatTokenPath(runID))
+	if err != nil || len(bytes.TrimSpace(want)) == 0 {
+		return false
+	}
+	got := strings.TrimPrefix(r.Header.Get("Authorization"), "Bearer ")
+	return subtle.ConstantTimeCompare([]byte(got), bytes.TrimSpace(want)) == 1
+}
+
+func shellQuote(s string) string {
+	return "'" + strings.ReplaceAll(s, "'", `'\''`) + "'"
+}
+
+func heartbeatPath(runID string, instanceID int) string {
+	return filepath.Join("runs", runID, "heartbeats", strconv.Itoa(instanceID)+".json")
+}
+
+// handleHeartbeat принимает пульс раннера. Запуск должен существовать в ./runs, а пульс - быть
+// подписан его секретом: чужой раннер не создает каталогов и не пишет в чужой запуск
+func handleHeartbeat(w http.ResponseWriter, r *http.Request) {
+	var hb Heartbeat
+	if err := json.NewDecoder(http.MaxBytesReader(w, r.Body, 1<<20)).Decode(&hb); err != nil {
+		writeError(w, http.StatusBadRequest, "invalid heartbeat: %v", err)
+		return
+	}
+	id, err := strconv.Atoi(hb.InstanceID)
+	if err != nil || hb.RunID == "" || strings.ContainsAny(hb.RunID, "_/\\ ") || hb.RunID == "." || hb.RunID == ".." {
+		writeError(w, http.StatusBadRequest, "heartbeat needs run_id and a numeric instance_id")
+		return
+	}
+	if _, err := os.Stat(filepath.Join("runs", hb.RunID, "run_manifest.json")); err != nil {
+		writeError(w, http.StatusNotFound, "run %s not found", hb.RunID)
+		return
+	}
+	if !checkHeartbeatToken(r, hb.RunID) {
+		writeError(w, http.StatusUnauthorized, "missing or invalid heartbeat token")
+		return
+	}
+	hb.ReceivedAt = time.Now()
+	data, _ := json.MarshalIndent(hb, "", "  ")
+	if err := writeFileAtomic(heartbeatPath(hb.RunID, id), data); err != nil {
+		writeError(w, http.StatusInternalServerError, "%v", err)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// serveHeartbeats принимает пульс на -heartbeat-listen, пока работает процесс запуска
+func (c *HeartbeatConfig) serveHeartbeats() {
+	if !c.enabled() || c.Listen == "" {
+		return
+	}
+	mux := http.NewServeMux()
+	mux.HandleFunc("POST /heartbeats", handleHeartbeat)
+	srv := &http.Server{Addr: c.Listen, Handler: mux, ReadHeaderTimeout: 10 * time.Second}
+	go func() {
+		if err := srv.ListenAndServe(); err != nil && !errors.Is(err, http.ErrServerClosed) {
+			fmt.Printf("Heartbeat listener on %s stopped: %v\n", c.Listen, err)
+		}
+	}()
+	fmt.Printf("Receiving heartbeats on %s (runners send them to %s)\n", c.Listen, c.URL)
+}
+
+// deployedAt - когда инстанс последний раз перешел в deployed; более ранний пульс остался
+// от прошлого развертывания
+func (mi *ManifestInstance) deployedAt() time.Time {
+	for i := len(mi.History) - 1; i >= 0; i-- {
+		if mi.History[i].Stage == StageDeployed && (i == 0 || mi.History[i-1].Stage != StageDeployed) {
+			return mi.History[i].At
+		}
+	}
+	return time.Time{}
+}
+
+func readHeartbeat(runID string, instanceID int) *Heartbeat {
+	data, err := os.ReadFile(heartbeatPath(runID, instanceID))
+	if err != nil {
+		return nil
+	}
+	var hb Heartbeat
+	if json.Unmarshal(data, &hb) != nil {
+		return nil
+	}
+	return &hb
+}
+
+// trackHeartbeats переносит последние пульсы в манифест и отмечает инстансы, чей раннер замолчал:
+// после -heartbeat-timeout с последнего пульса или -heartbeat-grace после запуска без единого пульса.
+// Пульс подтверждает процесс раннера (deployed -> testing), последний пульс завершает этап
+func trackHeartbeats(m *RunManifest, c *HeartbeatConfig) {
+	if !c.enabled() {
+		return
+	}
+	for _, id := range m.Active() {
+		hb := readHeartbeat(m.RunID, id)
+		var stage, stageMsg, note string // SetStage пишет сообщение в Error, для done оно пустое
+		m.UpdateInstance(id, func(mi *ManifestInstance) {
+			if mi.Stage != StageDeployed && mi.Stage != StageTesting {
+				return
+			}
+			if hb != nil && hb.ReceivedAt.Before(mi.deployedAt()) {
+				hb = nil
+			}
+			if hb != nil && (mi.Heartbeat == nil || hb.ReceivedAt.After(mi.Heartbeat.ReceivedAt)) {
+				mi.Heartbeat = hb
+				if hb.SessionID != "" {
+					mi.SessionID = hb.SessionID
+				}
+			}
+			if mi.Heartbeat != nil && mi.Heartbeat.final() {
+				mi.HeartbeatLost = false
+				mi.SessionStatus = mi.Heartbeat.Status
+				stage = StageDone
+				if mi.Heartbeat.Phase == PhaseFailed {
+					stage, stageMsg = StageFailed, "runner failed: "+mi.Heartbeat.LastError
+				}
+				return
+			}
+			if mi.Heartbeat != nil && mi.Stage == StageDeployed {
+				stage = StageTesting
+			}
+
+			silent, limit := time.Since(mi.StageChangedAt), c.Grace
+			if mi.Heartbeat != nil {
+				silent, limit = time.Since(mi.Heartbeat.ReceivedAt), c.Timeout
+			}
+			lost := silent > limit
+			if lost == mi.HeartbeatLost {
+				return
+			}
+			mi.HeartbeatLost = lost
+			if lost {
+				note = fmt.Sprintf("heartbeat lost: none for %v", silent.Round(time.Second))
+				if mi.Heartbeat != nil {
+					note += " (last: " + mi.Heartbeat.String() + ")"
+				}
+			} else {
+				note = "heartbeat is back: " + mi.Heartbeat.String()
+			}
+			mi.setStatus(mi.Status, note)
+		})
+		if note != "" {
+			fmt.Printf("  [%s] Instance %d: %s\n", time.Now().Format("15:04:05"), id, note)
+		}
+		if stage != "" {
+			m.SetStage(id, stage, stageMsg)
+		}
+	}
+}
+
+// printHeartbeats - сводка по пульсу раннеров запуска; без пульса ни от одного инстанса ничего не печатает
+func printHeartbeats(m *RunManifest) {
+	instances, _ := m.snapshot()
+	var lines []string
+	reporting, lost, finished := 0, 0, 0
+	for _, mi := range instances {
+		if !mi.TestStarted {
+			continue
+		}
+		hb := mi.Heartbeat
+		line := fmt.Sprintf("  Instance %d: ", mi.InstanceID)
+		switch {
+		case mi.HeartbeatLost && hb != nil:
+			lost++
+			line += fmt.Sprintf("LOST, last heartbeat %v ago (%s)", time.Since(hb.ReceivedAt).Round(time.Second), hb)
+		case mi.HeartbeatLost:
+			lost++
+			line += "LOST, no heartbeat since deploy"
+		case hb == nil:
+			line += "no heartbeat"
+		case hb.final():
+			finished++
+			line += hb.String()
+		default:
+			reporting++
+			line += fmt.Sprintf("%s (%v ago)", hb, time.Since(hb.ReceivedAt).Round(time.Second))
+		}
+		lines = append(lines, line)
+	}
+	if reporting+lost+finished == 0 {
+		return
+	}
+	fmt.Printf("\n=== HEARTBEATS ===\n")
+	for _, line := range lines {
+		fmt.Println(line)
+	}
+	fmt.Printf("Reporting: %d, finished: %d, lost: %d\n", reporting, finished, lost)
+}
@@ -0,0 +1,453 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Этапы жизненного цикла инстанса. requested - запрос на аренду ушел (в манифест инстанс попадает
+// с ID уже на created); created, loading и running ведет провайдер; ssh-ready, deployed и testing
+// отмечает пул после проверки SSH, запуска start.sh и появления процесса раннера.
+// done, failed и destroyed - конечные. --resume продолжает с того этапа, где остановился прошлый процесс
+const (
+	StageRequested = "requested"
+	StageCreated   = "created"
+	StageLoading   = "loading"
+	StageRunning   = "running"
+	StageSSHReady  = "ssh-ready"
+	StageDeployed  = "deployed"
+	StageTesting   = "testing"
+	StageDone      = "done"
+	StageFailed    = "failed"
+	StageDestroyed = "destroyed"
+)
+
+var stageOrder = map[string]int{
+	StageRequested: 1,
+	StageCreated:   2,
+	StageLoading:   3,
+	StageRunning:   4,
+	StageSSHReady:  5,
+	StageDeployed:  6,
+	StageTesting:   7,
+	StageDone:      8,
+}
+
+// providerStages - этапы, которые задает статус у провайдера
+var providerStages = map[string]string{
+	StatusCreated: StageCreated,
+	StatusLoading: StageLoading,
+	StatusRunning: StageRunning,
+}
+
+// legacyStages - этапы из манифестов, записанных до жизненного цикла
+var legacyStages = map[string]string{
+	"ready":         StageSSHReady,
+	"deploying":     StageSSHReady,
+	"deploy_failed": StageFailed,
+}
+
+func stageFinal(stage string) bool {
+	return stage == StageDone || stage == StageFailed || stage == StageDestroyed
+}
+
+func stageBefore(a, b string) bool {
+	return stageOrder[a] < stageOrder[b]
+}
+
+// normalizeStages переводит этапы старых манифестов в текущие; время этапа без отметки считается от загрузки
+func (m *RunManifest) normalizeStages() {
+	for i := range m.Instances {
+		mi := &m.Instances[i]
+		if stage, ok := legacyStages[mi.Stage]; ok {
+			mi.Stage = stage
+		}
+		if mi.StageChangedAt.IsZero() {
+			mi.StageChangedAt = time.Now()
+		}
+	}
+}
+
+// Lifecycle - сколько инстанс может пробыть на этапе и сколько упавших инстансов можно заменить
+type Lifecycle struct {
+	CreateTimeout   time.Duration
+	LoadTimeout     time.Duration
+	SSHTimeout      time.Duration
+	StartTimeout    time.Duration
+	MaxReplacements int
+
+	// ReadyChecks - проверки из probe.go, которые должны пройти для ssh-ready
+	ReadyChecks   listFlag
+	MinFreeDiskGB float64
+}
+
+// lifecyclePollInterval - период опроса провайдера и инстансов
+var lifecyclePollInterval = 30 * time.Second
+
+func addLifecycleFlags(fs *flag.FlagSet) *Lifecycle {
+	l := &Lifecycle{ReadyChecks: append(listFlag(nil), readyChecks...)}
+	fs.DurationVar(&l.CreateTimeout, "create-timeout", 10*time.Minute, "fail an instance that stays created longer than this (0: no limit)")
+	fs.DurationVar(&l.LoadTimeout, "load-timeout", 20*time.Minute, "fail an instance that stays loading longer than this (0: no limit)")
+	fs.DurationVar(&l.SSHTimeout, "ssh-timeout", 5*time.Minute, "fail a running instance that does not pass the readiness probe within this (0: no limit)")
+	fs.DurationVar(&l.StartTimeout, "start-timeout", 15*time.Minute, "fail a deployed instance whose runner process does not appear within this (0: no limit)")
+	fs.IntVar(&l.MaxReplacements, "max-replacements", -1, "how many failed instances to replace with spare offers (-1: as many as -count, 0: none)")
+	fs.Var(&l.ReadyChecks, "ready-checks", "comma-separated readiness checks after the SSH handshake: display, apt, disk (empty: handshake only)")
+	fs.Float64Var(&l.MinFreeDiskGB, "min-free-disk", 5, "free disk space in GB the disk readiness check requires")
+	return l
+}
+
+func (l *Lifecycle) Validate() error {
+	if l.CreateTimeout < 0 || l.LoadTimeout < 0 || l.SSHTimeout < 0 || l.StartTimeout < 0 {
+		return fmt.Errorf("stage timeouts must not be negative")
+	}
+	if l.MaxReplacements < -1 {
+		return fmt.Errorf("-max-replacements must be -1 or more")
+	}
+	for _, check := range l.ReadyChecks {
+		known := false
+		for _, name := range readyChecks {
+			known = known || check == name
+		}
+		if !known {
+			return fmt.Errorf("unknown readiness check %q (available: %s)", check, strings.Join(readyChecks, ", "))
+		}
+	}
+	if l.MinFreeDiskGB < 0 {
+		return fmt.Errorf("-min-free-disk must not be negative")
+	}
+	return nil
+}
+
+// timeout возвращает предел для этапа; 0 - без предела
+func (l *Lifecycle) timeout(stage string) time.Duration {
+	switch stage {
+	case StageCreated:
+		return l.CreateTimeout
+	case StageLoading:
+		return l.LoadTimeout
+	case StageRunning:
+		return l.SSHTimeout
+	case StageDeployed:
+		return l.StartTimeout
+	}
+	return 0
+}
+
+func (m *RunManifest) SetCandidates(offers []Offer) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.Candidates = append([]Offer(nil), offers...)
+}
+
+// takeCandidate забирает из запасных первое предложение, которое проходит fits
+func (m *RunManifest) takeCandidate(fits func(Offer) bool) (Offer, bool) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	for i, offer := range m.Candidates {
+		if fits(offer) {
+			m.Candidates = append(m.Candidates[:i:i], m.Candidates[i+1:]...)
+			return offer, true
+		}
+	}
+	return Offer{}, false
+}
+
+// hourly возвращает почасовую цену живых инстансов
+func (m *RunManifest) hourly() float64 {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	total := 0.0
+	for _, mi := range m.Instances {
+		if mi.DestroyedAt == nil && mi.Status != InstanceGone {
+			total += mi.PricePerHour
+		}
+	}
+	return total
+}
+
+// poolSupervisor ведет инстансы пула по этапам: отмечает переходы, валит зависшие по таймаутам,
+// удаляет упавшие и арендует им замену из запасных предложений, пока исправных инстансов
+// не станет target или не кончатся замены
+type poolSupervisor struct {
+	client    Provider
+	manifest  *RunManifest
+	lifecycle *Lifecycle
+	budget    *Budget
+	bundle    *RunnerBundle // nil - без развертывания, пул ведется до ssh-ready
+	target    int
+	parallel  int
+	ids       []int
+}
+
+// goal - этап, до которого пул доводит инстансы
+func (s *poolSupervisor) goal() string {
+	if s.bundle != nil {
+		return StageTesting
+	}
+	return StageSSHReady
+}
+
+func (s *poolSupervisor) maxReplacements() int {
+	if s.lifecycle.MaxReplacements < 0 {
+		return s.target
+	}
+	return s.lifecycle.MaxReplacements
+}
+
+func (s *poolSupervisor) canReplace() bool {
+	s.manifest.mu.Lock()
+	defer s.manifest.mu.Unlock()
+	return s.manifest.Replacements < s.maxReplacements() && len(s.manifest.Candidates) > 0
+}
+
+// counts возвращает число исправных инстансов и сколько из них дошло до цели
+func (s *poolSupervisor) counts() (healthy, atGoal int) {
+	for _, id := range s.ids {
+		mi, _ := s.manifest.Instance(id)
+		if mi.DestroyedAt != nil || mi.Status == InstanceGone || mi.Stage == StageFailed || mi.Stage == StageDestroyed {
+			continue
+		}
+		healthy++
+		if !stageBefore(mi.Stage, s.goal()) {
+			atGoal++
+		}
+	}
+	return healthy, atGoal
+}
+
+// Run ведет пул до цели, но не дольше wait; возвращает ID инстансов, дошедших до цели
+func (s *poolSupervisor) Run(ctx context.Context, wait time.Duration) []int {
+	deadline := time.Now().Add(wait)
+	fmt.Printf("\nWaiting up to %v for %d healthy instances to reach %s...\n", wait, s.target, s.goal())
+	for round := 1; ; round++ {
+		fmt.Printf("\n  [%s] Round %d - checking instance stages:\n", time.Now().Format("15:04:05"), round)
+		s.poll(ctx)
+		if s.bundle != nil {
+			s.deploy()
+		}
+		s.destroyFailed(ctx)
+		if enforceBudget(ctx, s.client, s.manifest) {
+			return nil
+		}
+		s.replace(ctx)
+		s.manifest.checkpoint()
+
+		healthy, atGoal := s.counts()
+		fmt.Printf("  Healthy: %d/%d, %s: %d, replacements: %d/%d\n",
+			healthy, s.target, s.goal(), atGoal, s.manifest.Replacements, s.maxReplacements())
+		if atGoal == healthy && (healthy >= s.target || !s.canReplace()) {
+			break
+		}
+		if time.Now().After(deadline) {
+			fmt.Printf("  Wait time is over\n")
+			break
+		}
+		select {
+		case <-time.After(lifecyclePollInterval):
+		case <-ctx.Done():
+			return nil
+		}
+	}
+	return s.report()
+}
+
+// poll обновляет статусы у провайдера, проверяет готовность и раннер по SSH и валит инстансы,
+// зависшие на этапе
+func (s *poolSupervisor) poll(ctx context.Context) {
+	instances := make(map[int]*Instance)
+	for _, id := range s.ids {
+		mi, _ := s.manifest.Instance(id)
+		if mi.DestroyedAt != nil || mi.Status == InstanceGone || stageFinal(mi.Stage) {
+			continue
+		}
+		instance, err := s.client.GetInstance(ctx, id)
+		if err != nil {
+			fmt.Printf("    Instance %d: ERROR checking status: %v\n", id, err)
+			continue
+		}
+		s.manifest.RecordStatus(instance)
+		instances[id] = instance
+	}
+
+	s.checkSSH(ctx)
+	if s.bundle != nil {
+		trackHeartbeats(s.manifest, s.bundle.Heartbeat)
+	}
+
+	for _, id := range s.ids {
+		instance, ok := instances[id]
+		if !ok {
+			continue
+		}
+		mi, _ := s.manifest.Instance(id)
+		inStage := time.Since(mi.StageChangedAt)
+		if limit := s.lifecycle.timeout(mi.Stage); limit > 0 && inStage > limit {
+			msg := fmt.Sprintf("stuck in %s for more than %v", mi.Stage, limit)
+			if mi.Stage == StageRunning && mi.Probe != nil {
+				msg += ": " + mi.Probe.String()
+			}
+			s.manifest.SetStage(id, StageFailed, msg)
+			mi, _ = s.manifest.Instance(id)
+			inStage = 0
+		}
+
+		icon := "⏳"
+		switch mi.Stage {
+		case StageCreated:
+			icon = "🆕"
+		case StageLoading:
+			icon = "🔄"
+		case StageSSHReady, StageDeployed, StageTesting:
+			icon = "✅"
+		case StageFailed:
+			icon = "❌"
+		}
+		note := ""
+		if mi.Error != "" {
+			note = " - " + mi.Error
+		} else if mi.Stage == StageRunning && mi.Probe != nil {
+			note = " - " + mi.Probe.String()
+		} else if mi.HeartbeatLost {
+			note = " - heartbeat lost"
+		} else if mi.Stage == StageTesting && mi.Heartbeat != nil {
+			note = " - " + mi.Heartbeat.String()
+		} else if msg := strings.TrimSpace(instance.StatusMessage); msg != "" && mi.Stage != StageTesting {
+			note = " - " + msg
+		}
+		fmt.Printf("    %s Instance %d: %s (%s for %v)%s\n", icon, id, instance.Status, mi.Stage, inStage.Round(time.Second), note)
+	}
+}
+
+// checkSSH параллельно проверяет готовность running инстансов и процесс раннера на deployed:
+// каждое рукопожатие может ждать таймаут, последовательно большой пул опрашивался бы минутами
+func (s *poolSupervisor) checkSSH(ctx context.Context) {
+	mgr, err := sshSessions()
+	if err != nil {
+		fmt.Printf("    SSH checks skipped: %v\n", err)
+		return
+	}
+	var wg sync.WaitGroup
+	for _, id := range s.ids {
+		mi, _ := s.manifest.Instance(id)
+		if mi.SSHHost == "" || mi.DestroyedAt != nil {
+			continue
+		}
+		switch mi.Stage {
+		case StageRunning:
+			wg.Add(1)
+			go func(id int, host string, port int) {
+				defer wg.Done()
+				probe := probeInstance(ctx, mgr, id, host, port, s.lifecycle.ReadyChecks, s.lifecycle.MinFreeDiskGB)
+				s.manifest.UpdateInstance(id, func(mi *ManifestInstance) { mi.Probe = &probe })
+				if probe.Ready {
+					s.manifest.SetStage(id, StageSSHReady, "")
+				}
+			}(id, mi.SSHHost, mi.SSHPort)
+		case StageDeployed:
+			wg.Add(1)
+			go func(id int, host string, port int) {
+				defer wg.Done()
+				if runnerRunning(host, port) {
+					s.manifest.SetStage(id, StageTesting, "")
+				}
+			}(id, mi.SSHHost, mi.SSHPort)
+		}
+	}
+	wg.Wait()
+}
+
+// destroyFailed удаляет упавшие инстансы, чтобы они не оплачивались
+func (s *poolSupervisor) destroyFailed(ctx context.Context) {
+	for _, id := range s.ids {
+		mi, _ := s.manifest.Instance(id)
+		if mi.Stage != StageFailed || mi.DestroyedAt != nil || mi.Status == InstanceGone {
+			continue
+		}
+		fmt.Printf("  [%s] Destroying failed instance %d: %s\n", time.Now().Format("15:04:05"), id, mi.Error)
+		if err := s.client.DestroyInstance(ctx, id); err != nil {
+			fmt.Printf("  [%s] Failed to destroy instance %d: %v\n", time.Now().Format("15:04:05"), id, err)
+			continue
+		}
+		s.manifest.MarkDestroyed(id, "failed: "+mi.Error)
+	}
+}
+
+// replace арендует запасные предложения, пока исправных инстансов меньше target. Предложение,
+// которое не удалось арендовать, выбывает из запасных, но замены не расходует
+func (s *poolSupervisor) replace(ctx context.Context) {
+	for {
+		healthy, _ := s.counts()
+		if healthy >= s.target || !s.canReplace() {
+			return
+		}
+		hourly := s.manifest.hourly()
+		offer, ok := s.manifest.takeCandidate(func(o Offer) bool { return s.budget.fits(hourly + o.DPHTotal) })
+		if !ok {
+			fmt.Printf("  No spare offer fits the budget, the pool stays at %d instances\n", healthy)
+			return
+		}
+		fmt.Printf("  [%s] Pool has %d of %d healthy instances, renting spare offer %d (%s, $%.4f/hour)\n",
+			time.Now().Format("15:04:05"), healthy, s.target, offer.ID, offer.GPUName, offer.DPHTotal)
+		for _, instance := range createInstances(ctx, s.client, []Offer{offer}, s.manifest, s.parallel) {
+			s.ids = append(s.ids, instance.ID)
+			s.manifest.mu.Lock()
+			s.manifest.Replacements++
+			s.manifest.mu.Unlock()
+		}
+	}
+}
+
+// deploy запускает тесты на инстансах, дошедших до ssh-ready
+func (s *poolSupervisor) deploy() {
+	var ready []*Instance
+	for _, id := range s.ids {
+		mi, _ := s.manifest.Instance(id)
+		if mi.Stage == StageSSHReady && mi.DestroyedAt == nil {
+			ready = append(ready, &Instance{ID: id, Status: mi.Status, SSHHost: mi.SSHHost, SSHPort: mi.SSHPort})
+		}
+	}
+	if len(ready) == 0 {
+		return
+	}
+	if err := startTestsOnInstances(ready, s.manifest.RunID, s.manifest, s.bundle); err != nil {
+		fmt.Printf("Error starting tests: %v\n", err)
+	}
+}
+
+// report печатает этапы инстансов пула и возвращает ID дошедших до цели
+func (s *poolSupervisor) report() []int {
+	fmt.Printf("\n=== POOL STAGES ===\n")
+	fmt.Printf("Timestamp: %s\n", time.Now().Format("2006-01-02 15:04:05"))
+	byStage := make(map[string]int)
+	var ready []int
+	for _, id := range s.ids {
+		mi, _ := s.manifest.Instance(id)
+		byStage[mi.Stage]++
+		line := fmt.Sprintf("  Instance %d: %s", id, mi.Stage)
+		if mi.SSHHost != "" {
+			line += fmt.Sprintf(" (Host: %s, Port: %d)", mi.SSHHost, mi.SSHPort)
+		}
+		if mi.Error != "" {
+			line += " - " + mi.Error
+		}
+		if mi.HeartbeatLost {
+			line += " [heartbeat lost]"
+		}
+		fmt.Println(line)
+		if mi.DestroyedAt == nil && !stageFinal(mi.Stage) && !stageBefore(mi.Stage, s.goal()) {
+			ready = append(ready, id)
+		}
+	}
+	var stages []string
+	for stage, n := range byStage {
+		stages = append(stages, fmt.Sprintf("%s: %d", stage, n))
+	}
+	sort.Strings(stages)
+	fmt.Printf("Stages: %s\n", strings.Join(stages, ", "))
+	return ready
+}
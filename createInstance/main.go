@@ -1,324 +1,19 @@
 package main
 
 import (
-	"bytes"
-	"encoding/json"
+	"context"
 	"flag"
 	"fmt"
-	"io"
 	"log"
-	"net/http"
-	"net/url"
 	"os"
 	"os/exec"
+	"path/filepath"
 	"sort"
-	"strconv"
 	"strings"
 	"sync"
 	"time"
 )
 
-const (
-	VASTAI_API_KEY = "1e19fbc2284ced113253ec19f519fc9aebbb2a36a917ee97b5c71376b2a2cf38"
-	VASTAI_API_URL = "https://console.vast.ai/api/v0"
-)
-
-type VastClient struct {
-	apiKey string
-	client *http.Client
-}
-
-type Instance struct {
-	ID           int     `json:"id"`
-	Status       string  `json:"actual_status"`
-	SSHHost      string  `json:"ssh_host"`
-	SSHPort      int     `json:"ssh_port"`
-	PublicIPAddr string  `json:"public_ipaddr"`
-	DiskSpace    float64 `json:"disk_space"`
-	GPUName      string  `json:"gpu_name"`
-	NumGPUs      int     `json:"num_gpus"`
-}
-
-type CreateInstanceRequest struct {
-	ClientID      string `json:"client_id"`
-	Image         string `json:"image"`
-	DiskSpace     int    `json:"disk"`
-	OnStart       string `json:"onstart_cmd"`
-	RunType       string `json:"runtype"`
-	ImageLogin    string `json:"image_login"`
-	PythonVersion string `json:"python_utf8"`
-	CudaVersion   string `json:"cuda_version"`
-	UseSSHKey     bool   `json:"use_ssh_key"`
-}
-
-type Offer struct {
-	ID           int     `json:"id"`
-	GPUName      string  `json:"gpu_name"`
-	NumGPUs      int     `json:"num_gpus"`
-	DiskSpace    float64 `json:"disk_space"`
-	DPHTotal     float64 `json:"dph_total"`
-	CudaMaxGood  float64 `json:"cuda_max_good"`
-	Rentable     bool    `json:"rentable"`
-	MinBid       float64 `json:"min_bid"`
-	Verification string  `json:"verification"`
-}
-
-func NewVastClient(apiKey string) *VastClient {
-	return &VastClient{
-		apiKey: apiKey,
-		client: &http.Client{Timeout: 30 * time.Second},
-	}
-}
-
-func (v *VastClient) makeRequest(method, endpoint string, body interface{}) ([]byte, error) {
-	url := VASTAI_API_URL + endpoint
-
-	var reqBody io.Reader
-	if body != nil {
-		jsonBody, err := json.Marshal(body)
-		if err != nil {
-			return nil, err
-		}
-		reqBody = bytes.NewBuffer(jsonBody)
-	}
-
-	req, err := http.NewRequest(method, url, reqBody)
-	if err != nil {
-		return nil, err
-	}
-
-	// Используем те же заголовки что и UI
-	req.Header.Set("Authorization", "Bearer "+v.apiKey)
-	req.Header.Set("Content-Type", "application/json")
-	req.Header.Set("Accept", "application/json, text/plain, */*")
-	req.Header.Set("Accept-Language", "en-GB,en;q=0.9,ru-RU;q=0.8,ru;q=0.7,en-US;q=0.6")
-	req.Header.Set("Cache-Control", "no-cache")
-	req.Header.Set("Origin", "https://cloud.vast.ai")
-	req.Header.Set("Pragma", "no-cache")
-	req.Header.Set("Sec-Ch-Ua", `"Chromium";v="140", "Not=A?Brand";v="24", "Google Chrome";v="140"`)
-	req.Header.Set("Sec-Ch-Ua-Mobile", "?0")
-	req.Header.Set("Sec-Ch-Ua-Platform", `"macOS"`)
-	req.Header.Set("Sec-Fetch-Dest", "empty")
-	req.Header.Set("Sec-Fetch-Mode", "cors")
-	req.Header.Set("Sec-Fetch-Site", "same-origin")
-	req.Header.Set("User-Agent", "Mozilla/5.0 (Macintosh; Intel Mac OS X 10_15_7) AppleWebKit/537.36 (KHTML, like Gecko) Chrome/140.0.0.0 Safari/537.36")
-
-	resp, err := v.client.Do(req)
-	if err != nil {
-		return nil, err
-	}
-	defer resp.Body.Close()
-
-	respBody, err := io.ReadAll(resp.Body)
-	if err != nil {
-		return nil, err
-	}
-
-	if resp.StatusCode >= 400 {
-		return nil, fmt.Errorf("API error (status %d): %s", resp.StatusCode, string(respBody))
-	}
-
-	return respBody, nil
-}
-
-func (v *VastClient) SearchOffers(verifiedOnly bool) ([]Offer, error) {
-	searchQuery := map[string]interface{}{
-		"rentable":             map[string]bool{"eq": true},
-		"disk_space":           map[string]float64{"gte": 30.0},
-		"gpu_display_active":   map[string]bool{"eq": true},
-	}
-	
-	// Добавляем фильтр верификации если нужно
-	if verifiedOnly {
-		searchQuery["verification"] = map[string]string{"eq": "verified"}
-	}
-
-	queryJSON, _ := json.Marshal(searchQuery)
-	endpoint := fmt.Sprintf("/bundles?q=%s&order=dph_total&type=on-demand&limit=10", url.QueryEscape(string(queryJSON)))
-
-	data, err := v.makeRequest("GET", endpoint, nil)
-	if err != nil {
-		return nil, err
-	}
-
-	var result struct {
-		Offers []Offer `json:"offers"`
-	}
-
-	if err := json.Unmarshal(data, &result); err != nil {
-		return nil, err
-	}
-
-	return result.Offers, nil
-}
-
-var sshKeySetOnce sync.Once
-var globalSSHKeyError error
-
-func (v *VastClient) CreateInstance(offer Offer) (*Instance, error) {
-	// Устанавливаем SSH ключ только один раз глобально
-	sshKeySetOnce.Do(func() {
-		sshKey, err := getOrCreateSSHKey()
-		if err != nil {
-			globalSSHKeyError = fmt.Errorf("failed to get SSH key: %v", err)
-			return
-		}
-
-		// Skip API attempt, use CLI directly
-		fmt.Println("Setting SSH key via CLI...")
-		cmd := exec.Command("vastai", "create", "ssh-key", sshKey)
-		output, cmdErr := cmd.CombinedOutput()
-		if cmdErr != nil {
-			globalSSHKeyError = fmt.Errorf("failed to add SSH key via CLI: %v\nOutput: %s", cmdErr, string(output))
-			return
-		}
-		fmt.Printf("SSH key added via CLI: %s\n", string(output))
-		fmt.Println("SSH key configured successfully")
-	})
-
-	if globalSSHKeyError != nil {
-		return nil, globalSSHKeyError
-	}
-
-	fmt.Println("Creating instance via API...")
-	
-	// Создаем через API с правильной структурой как в UI
-	portalConfig := "localhost:1111:11111:/:Instance Portal|localhost:6100:16100:/:Selkies Low Latency Desktop|localhost:6200:16200:/guacamole:Apache Guacamole Desktop (VNC)|localhost:8080:8080:/:Jupyter|localhost:8080:8080:/terminals/1:Jupyter Terminal|localhost:8384:18384:/:Syncthing"
-	
-	endpoint := "/asks/" + fmt.Sprintf("%d", offer.ID) + "/"
-	
-	// Используем точную структуру из curl запроса
-	data := map[string]interface{}{
-		"template_id":      246282,
-		"template_hash_id": "5ba61f6f48c1ffe49ca7163d4e3a6460",
-		"client_id":        "me",
-		"image":            "vastai/linux-desktop:@vastai-automatic-tag",
-		"env": map[string]string{
-			"-p 1111:1111":      "1",
-			"-p 6100:6100":      "1",
-			"-p 73478:73478":    "1",
-			"-p 8384:8384":      "1",
-			"-p 72299:72299":    "1",
-			"-p 6200:6200":      "1",
-			"-p 5900:5900":      "1",
-			"OPEN_BUTTON_TOKEN": "1",
-			"JUPYTER_DIR":       "/",
-			"DATA_DIRECTORY":    "/workspace/",
-			"PORTAL_CONFIG":     portalConfig,
-			"OPEN_BUTTON_PORT":  "1111",
-			"SELKIES_ENCODER":   "x264enc",
-		},
-		"args_str":              "",
-		"onstart":               "entrypoint.sh",
-		"runtype":               "jupyter_direc ssh_direc ssh_proxy",
-		"image_login":           nil,
-		"use_jupyter_lab":       false,
-		"jupyter_dir":           nil,
-		"python_utf8":           nil,
-		"lang_utf8":             nil,
-		"disk":                  32,
-		"last_known_min_bid":    offer.MinBid,
-		"min_duration":          259200,
-	}
-	
-	respBody, err := v.makeRequest("PUT", endpoint, data)
-	if err != nil {
-		return nil, fmt.Errorf("failed to create instance via API: %v", err)
-	}
-	
-	var result map[string]interface{}
-	if err := json.Unmarshal(respBody, &result); err != nil {
-		return nil, fmt.Errorf("failed to parse API response: %v", err)
-	}
-	
-	contractID, ok := result["new_contract"].(float64)
-	if !ok {
-		return nil, fmt.Errorf("failed to get contract ID from API response: %v", result)
-	}
-
-	fmt.Printf("Instance created with ID: %d\n", int(contractID))
-	return &Instance{ID: int(contractID)}, nil
-}
-
-func (v *VastClient) SetSSHKey(publicKey string) error {
-	endpoint := "/users/current/"
-
-	updateData := map[string]string{
-		"ssh_key": publicKey,
-	}
-
-	_, err := v.makeRequest("PUT", endpoint, updateData)
-	return err
-}
-
-func (v *VastClient) GetInstance(instanceID int) (*Instance, error) {
-	cmd := exec.Command("vastai", "show", "instance", fmt.Sprintf("%d", instanceID))
-	output, err := cmd.CombinedOutput()
-	if err != nil {
-		return nil, fmt.Errorf("failed to get instance info: %v\nOutput: %s", err, string(output))
-	}
-
-	lines := strings.Split(string(output), "\n")
-	if len(lines) < 2 {
-		return nil, fmt.Errorf("unexpected output format: %s", string(output))
-	}
-
-	fields := strings.Fields(lines[1])
-	if len(fields) < 11 {
-		return nil, fmt.Errorf("not enough fields in output: %s", lines[1])
-	}
-
-	instance := &Instance{
-		ID:     instanceID,
-		Status: fields[2],
-	}
-
-	// Если инстанс готов, получаем правильные SSH данные через ssh-url
-	if fields[2] == "running" {
-		sshCmd := exec.Command("vastai", "ssh-url", fmt.Sprintf("%d", instanceID))
-		sshOutput, err := sshCmd.CombinedOutput()
-		if err != nil {
-			return nil, fmt.Errorf("failed to get SSH URL: %v\nOutput: %s", err, string(sshOutput))
-		}
-		
-		// Парсим ssh://root@host:port
-		sshURL := strings.TrimSpace(string(sshOutput))
-		if strings.HasPrefix(sshURL, "ssh://root@") {
-			hostPort := strings.TrimPrefix(sshURL, "ssh://root@")
-			parts := strings.Split(hostPort, ":")
-			if len(parts) == 2 {
-				instance.SSHHost = parts[0]
-				port, err := strconv.Atoi(parts[1])
-				if err != nil {
-					return nil, fmt.Errorf("invalid SSH port: %s", parts[1])
-				}
-				instance.SSHPort = port
-			}
-		}
-	}
-
-	return instance, nil
-}
-
-func (v *VastClient) WaitForInstance(instanceID int, maxMinutes int) (*Instance, error) {
-	maxAttempts := maxMinutes * 12 // 12 attempts per minute (every 5 seconds)
-	for i := 0; i < maxAttempts; i++ {
-		instance, err := v.GetInstance(instanceID)
-		if err != nil {
-			return nil, err
-		}
-
-		if instance.Status == "running" && instance.SSHHost != "" {
-			return instance, nil
-		}
-
-		fmt.Printf("Instance status: %s (attempt %d/%d)\n", instance.Status, i+1, maxAttempts)
-		time.Sleep(5 * time.Second)
-	}
-
-	return nil, fmt.Errorf("instance did not become ready in %d minutes", maxMinutes)
-}
-
 func getOrCreateSSHKey() (string, error) {
 	homeDir, err := os.UserHomeDir()
 	if err != nil {
@@ -326,7 +21,7 @@ func getOrCreateSSHKey() (string, error) {
 	}
 
 	sshDir := fmt.Sprintf("%s/.ssh", homeDir)
-	keyPath := fmt.Sprintf("%s/vastai_rsa", sshDir)
+	keyPath := sshKeyPath()
 	pubKeyPath := keyPath + ".pub"
 
 	if _, err := os.Stat(pubKeyPath); os.IsNotExist(err) {
@@ -350,141 +45,105 @@ func getOrCreateSSHKey() (string, error) {
 	return string(pubKey), nil
 }
 
-func connectSSH(instance *Instance) error {
-	homeDir, _ := os.UserHomeDir()
-	keyPath := fmt.Sprintf("%s/.ssh/vastai_rsa", homeDir)
-	cmdStr := fmt.Sprintf("git clone https://github.com/kryuchenko/highLoadTest.git; cd highLoadTest; bash start.sh %v", instance.ID)
-	sshTarget := fmt.Sprintf("root@%s", instance.SSHHost)
-	fmt.Printf("\nConnecting to instance via SSH...\n")
-
-	cmd := exec.Command("ssh",
-		"-i", keyPath,
-		"-o", "StrictHostKeyChecking=no",
-		"-o", "UserKnownHostsFile=/dev/null",
-		"-p", strconv.Itoa(instance.SSHPort),
-		sshTarget, cmdStr)
-
-	cmd.Stdin = os.Stdin
-	cmd.Stdout = os.Stdout
-	cmd.Stderr = os.Stderr
-
-	return cmd.Run()
-}
-
-func startTestsOnInstances(instances []*Instance) error {
+func startTestsOnInstances(instances []*Instance, runID string, manifest *RunManifest, bundle *RunnerBundle) error {
 	fmt.Printf("\n=== STARTING TESTS ON %d INSTANCES ===\n", len(instances))
 	fmt.Printf("Timestamp: %s\n", time.Now().Format("2006-01-02 15:04:05"))
-	
+	fmt.Printf("Run ID: %s\n", runID)
+
 	// Выводим детали всех инстансов
 	fmt.Printf("\nInstance details:\n")
 	for i, inst := range instances {
 		fmt.Printf("  [%d] ID: %d, SSH: %s:%d\n", i+1, inst.ID, inst.SSHHost, inst.SSHPort)
 	}
 	fmt.Printf("\n")
-	
+
+	mgr, err := sshSessions()
+	if err != nil {
+		return err
+	}
+	ctx := context.Background()
+	manifest.SetRunner(bundle.Files)
+
 	var wg sync.WaitGroup
 	var successCount, failCount int
 	var mu sync.Mutex
-	
+
 	for i, instance := range instances {
 		wg.Add(1)
 		go func(idx int, inst *Instance) {
 			defer wg.Done()
-			
-			fmt.Printf("[Instance %d/%d] [%s] Starting test on ID %d (%s:%d)...\n", 
-				idx+1, len(instances), time.Now().Format("15:04:05"), inst.ID, inst.SSHHost, inst.SSHPort)
-			
-			// Первый этап - проверка подключения
-			fmt.Printf("[Instance %d] [%s] Testing SSH connection...\n", inst.ID, time.Now().Format("15:04:05"))
-			testCmd := exec.Command("ssh", 
-				"-o", "StrictHostKeyChecking=no",
-				"-o", "ConnectTimeout=10",
-				"-p", strconv.Itoa(inst.SSHPort),
-				fmt.Sprintf("root@%s", inst.SSHHost),
-				"echo 'SSH connection successful'")
-			
-			output, err := testCmd.CombinedOutput()
-			if err != nil {
-				fmt.Printf("[Instance %d] [%s] SSH connection failed: %v, output: %s\n", 
-					inst.ID, time.Now().Format("15:04:05"), err, string(output))
+			prefix := fmt.Sprintf("[Instance %d]", inst.ID)
+			fail := func(format string, err error) {
+				fmt.Printf("%s [%s] %s: %v\n", prefix, time.Now().Format("15:04:05"), format, err)
+				manifest.SetStage(inst.ID, StageFailed, fmt.Sprintf("%s: %v", strings.ToLower(format), err))
+				manifest.checkpoint()
 				mu.Lock()
 				failCount++
 				mu.Unlock()
+			}
+
+			fmt.Printf("[Instance %d/%d] [%s] Starting test on ID %d (%s:%d)...\n",
+				idx+1, len(instances), time.Now().Format("15:04:05"), inst.ID, inst.SSHHost, inst.SSHPort)
+
+			// Первый этап - подключение; дальше все команды идут через это же соединение
+			fmt.Printf("%s [%s] Testing SSH connection...\n", prefix, time.Now().Format("15:04:05"))
+			if err := mgr.Connect(inst.SSHHost, inst.SSHPort); err != nil {
+				fail("SSH connection failed", err)
 				return
 			}
-			fmt.Printf("[Instance %d] [%s] SSH connection OK: %s\n", 
-				inst.ID, time.Now().Format("15:04:05"), strings.TrimSpace(string(output)))
-			
-			// Второй этап - скачивание файлов
-			fmt.Printf("[Instance %d] [%s] Downloading test files...\n", inst.ID, time.Now().Format("15:04:05"))
-			downloadCmd := exec.Command("ssh", 
-				"-o", "StrictHostKeyChecking=no",
-				"-o", "ConnectTimeout=30",
-				"-p", strconv.Itoa(inst.SSHPort),
-				fmt.Sprintf("root@%s", inst.SSHHost),
-				`wget -O highLoadTest https://github.com/kryuchenko/highLoadTest/raw/add-price-limit/highLoadTest && 
-				 wget -O start.sh https://github.com/kryuchenko/highLoadTest/raw/add-price-limit/start.sh && 
-				 chmod +x start.sh && 
-				 ls -la highLoadTest start.sh`)
-			
-			output, err = downloadCmd.CombinedOutput()
+			fmt.Printf("%s [%s] SSH connection OK\n", prefix, time.Now().Format("15:04:05"))
+
+			// Второй этап - заливка раннера, собранного локально
+			fmt.Printf("%s [%s] Uploading runner files...\n", prefix, time.Now().Format("15:04:05"))
+			for _, f := range bundle.Files {
+				uploaded, err := mgr.Upload(ctx, inst.SSHHost, inst.SSHPort, f, runnerUploadTimeout)
+				if err != nil {
+					fail("Upload failed", fmt.Errorf("%s: %v", f.Remote, err))
+					return
+				}
+				state := "already up to date"
+				if uploaded {
+					state = "uploaded and verified"
+				}
+				fmt.Printf("%s [%s] %s %s\n", prefix, time.Now().Format("15:04:05"), f.Remote, state)
+			}
+
+			// Третий этап - запуск теста
+			fmt.Printf("%s [%s] Starting test execution...\n", prefix, time.Now().Format("15:04:05"))
+			command, err := bundle.startCommand(inst.ID, runID)
 			if err != nil {
-				fmt.Printf("[Instance %d] [%s] Download failed: %v, output: %s\n", 
-					inst.ID, time.Now().Format("15:04:05"), err, string(output))
-				mu.Lock()
-				failCount++
-				mu.Unlock()
+				fail("Test start failed", err)
 				return
 			}
-			fmt.Printf("[Instance %d] [%s] Download successful:\n%s\n", 
-				inst.ID, time.Now().Format("15:04:05"), string(output))
-			
-			// Третий этап - запуск теста
-			fmt.Printf("[Instance %d] [%s] Starting test execution...\n", inst.ID, time.Now().Format("15:04:05"))
-			runCmd := exec.Command("ssh", 
-				"-o", "StrictHostKeyChecking=no",
-				"-o", "ConnectTimeout=30",
-				"-p", strconv.Itoa(inst.SSHPort),
-				fmt.Sprintf("root@%s", inst.SSHHost),
-				fmt.Sprintf(`nohup ./start.sh %d > test_output.log 2>&1 & echo "Test started with PID: $!"`, inst.ID))
-			
-			output, err = runCmd.CombinedOutput()
+			// Вывод start.sh (PID раннера) идет в лог построчно с префиксом инстанса
+			output, err := mgr.Run(ctx, inst.SSHHost, inst.SSHPort, prefix, command, 30*time.Second)
 			if err != nil {
-				fmt.Printf("[Instance %d] [%s] Test start failed: %v, output: %s\n", 
-					inst.ID, time.Now().Format("15:04:05"), err, string(output))
-				mu.Lock()
-				failCount++
-				mu.Unlock()
+				fail("Test start failed", fmt.Errorf("%v, output: %s", err, strings.TrimSpace(output)))
 				return
 			}
-			
-			fmt.Printf("[Instance %d] [%s] Test started successfully! %s\n", 
-				inst.ID, time.Now().Format("15:04:05"), strings.TrimSpace(string(output)))
-			
-			// Проверяем что процесс действительно запустился
+
+			fmt.Printf("%s [%s] Test started successfully!\n", prefix, time.Now().Format("15:04:05"))
+			manifest.SetStage(inst.ID, StageDeployed, "")
+
+			// Проверяем что процесс действительно запустился; если нет, пул проверит позже (-start-timeout)
 			time.Sleep(2 * time.Second)
-			checkCmd := exec.Command("ssh", 
-				"-o", "StrictHostKeyChecking=no",
-				"-o", "ConnectTimeout=10",
-				"-p", strconv.Itoa(inst.SSHPort),
-				fmt.Sprintf("root@%s", inst.SSHHost),
-				"ps aux | grep highLoadTest | grep -v grep")
-			
-			output, err = checkCmd.CombinedOutput()
+			output, err = mgr.Run(ctx, inst.SSHHost, inst.SSHPort, "",
+				"pgrep -af '[h]ighLoadTest'", 15*time.Second)
 			if err == nil && len(output) > 0 {
-				fmt.Printf("[Instance %d] [%s] Process confirmed running: %s\n", 
-					inst.ID, time.Now().Format("15:04:05"), strings.TrimSpace(string(output)))
+				fmt.Printf("%s [%s] Process confirmed running: %s\n",
+					prefix, time.Now().Format("15:04:05"), strings.TrimSpace(output))
+				manifest.SetStage(inst.ID, StageTesting, "")
 			} else {
-				fmt.Printf("[Instance %d] [%s] Warning: Process not found in ps output\n", 
-					inst.ID, time.Now().Format("15:04:05"))
+				fmt.Printf("%s [%s] Runner process not found yet, start.sh may still be installing dependencies\n",
+					prefix, time.Now().Format("15:04:05"))
 			}
-			
+			manifest.checkpoint()
 			mu.Lock()
 			successCount++
 			mu.Unlock()
 		}(i, instance)
 	}
-	
+
 	fmt.Printf("\nWaiting for all test deployments to complete...\n")
 	wg.Wait()
 	
@@ -494,72 +153,35 @@ func startTestsOnInstances(instances []*Instance) error {
 	fmt.Printf("Successfully started: %d\n", successCount)
 	fmt.Printf("Failed: %d\n", failCount)
 	fmt.Printf("Success rate: %.1f%%\n", float64(successCount)/float64(len(instances))*100)
-	fmt.Printf("\nMonitor results at: storage@kryuchenko.org:~/files/\n")
-	fmt.Printf("Expected session IDs format: session_YYYY-MM-DD_HH-MM-SS_instINSTANCE_ID_RANDOM\n")
+
+	// Prepare уже проверил конфиг раннера, здесь он читается только ради подсказок
+	session, err := loadRunnerSession(bundle.Config)
+	if err != nil {
+		log.Printf("Warning: %v", err)
+	} else {
+		fmt.Printf("\nMonitor results at: %s\n", session.Storage.Target())
+	}
+	fmt.Printf("Expected session IDs format: session_YYYY-MM-DD_HH-MM-SS_run%s_instINSTANCE_ID_RANDOM\n", runID)
 	
 	if successCount > 0 {
 		fmt.Printf("\n=== NEXT STEPS ===\n")
 		fmt.Printf("1. Wait 1-2 minutes for Chrome to start and GDPR consent\n")
-		fmt.Printf("2. Check remote storage for new session folders\n")
-		fmt.Printf("3. Screenshots will be saved every 15 seconds\n")
-		fmt.Printf("4. Tests will run for 5 minutes each\n")
+		if bundle.Heartbeat.enabled() {
+			fmt.Printf("2. Runners report to %s; instances silent for %v are marked lost\n", bundle.Heartbeat.URL, bundle.Heartbeat.Timeout)
+		} else {
+			fmt.Printf("2. Check remote storage for new session folders\n")
+		}
+		if err == nil {
+			fmt.Printf("3. Screenshots will be saved every %v\n", session.ScreenshotInterval)
+			fmt.Printf("4. Tests will run for %v each\n", session.Duration)
+		}
 	}
 	
 	return nil
 }
 
-func main() {
-	count := flag.Int("count", 1, "how many instances needed")
-	waitMinutes := flag.Int("wait", 5, "minutes to wait for instances to be ready")
-	maxPrice := flag.Float64("max-price", 0.50, "maximum price per hour in USD")
-	startTests := flag.Bool("start-tests", false, "start tests on created instances after waiting")
-	verifiedOnly := flag.Bool("verified", false, "use only verified instances")
-	flag.Parse()
-	client := NewVastClient(VASTAI_API_KEY)
-
-	if *verifiedOnly {
-		fmt.Println("Searching for VERIFIED GPU instances...")
-	} else {
-		fmt.Println("Searching for ALL available GPU instances...")
-	}
-	offers, err := client.SearchOffers(*verifiedOnly)
-	if err != nil {
-		log.Fatalf("Failed to search offers: %v", err)
-	}
-
-	if len(offers) == 0 {
-		log.Fatal("No suitable offers found")
-	}
-
-	fmt.Printf("Found %d offers. Filtering by max price $%.2f/hour...\n", len(offers), *maxPrice)
-
-	// Filter by price first
-	var filteredOffers []Offer
-	for _, offer := range offers {
-		if offer.DPHTotal <= *maxPrice {
-			filteredOffers = append(filteredOffers, offer)
-		}
-	}
-	
-	if len(filteredOffers) == 0 {
-		log.Fatalf("No offers found under $%.2f/hour", *maxPrice)
-	}
-	
-	fmt.Printf("Found %d offers under $%.2f/hour. Selecting the cheapest ones...\n", len(filteredOffers), *maxPrice)
-
-	sort.Slice(filteredOffers, func(i, j int) bool {
-		return filteredOffers[i].DPHTotal < filteredOffers[j].DPHTotal
-	})
-	
-	// Remove expensive GPUs  
-	for i := len(filteredOffers) - 1; i >= 0; i-- {
-		if strings.Contains(filteredOffers[i].GPUName, "3090") || strings.Contains(filteredOffers[i].GPUName, "4090") {
-			filteredOffers = append(filteredOffers[:i], filteredOffers[i+1:]...)
-		}
-	}
-	
-	offers = filteredOffers
-	offersSlice := offers[:*count]
+// createInstances параллельно создает инстансы по предложениям и записывает их в манифест
+func createInstances(ctx context.Context, client Provider, offersSlice []Offer, manifest *RunManifest, parallel int) []*Instance {
 	var wg sync.WaitGroup
 	var mu sync.Mutex
 	var createdInstances []*Instance
@@ -567,8 +189,11 @@ func main() {
 	// Параллельное создание экземпляров с ограничением одновременных запросов
 	fmt.Printf("Creating %d instances with rate limiting...\n", len(offersSlice))
 
-	// Канал для ограничения количества одновременных запросов (учитываем rate limit)
-	maxConcurrent := 1 // Максимум 1 запрос за раз для избежания 429 ошибок
+	// Частоту запросов ограничивает лимитер провайдера, здесь - только число одновременных созданий
+	maxConcurrent := parallel
+	if maxConcurrent < 1 {
+		maxConcurrent = 1
+	}
 	semaphore := make(chan struct{}, maxConcurrent)
 
 	for i, offer := range offersSlice {
@@ -590,20 +215,22 @@ func main() {
 
 			fmt.Printf("[Instance %d] [%s] Creating instance...\n", offerIndex+1, time.Now().Format("15:04:05"))
 
-			// Фиксированная задержка для соблюдения rate limit (4 req/sec = 250ms между запросами)
-			time.Sleep(250 * time.Millisecond)
-
-			instance, err := client.CreateInstance(offer)
+			requestedAt := time.Now()
+			instance, err := client.CreateInstance(ctx, offer)
 			if err != nil {
 				fmt.Printf("[Instance %d] [%s] Failed to create instance: %v\n", offerIndex+1, time.Now().Format("15:04:05"), err)
+				manifest.AddFailure("offer %d: %v", offer.ID, err)
 				return
 			}
 
 			fmt.Printf("[Instance %d] [%s] Instance created successfully!\n", offerIndex+1, time.Now().Format("15:04:05"))
 			fmt.Printf("  Instance ID: %d\n", instance.ID)
 			fmt.Printf("  Offer ID: %d\n", offer.ID)
-			fmt.Printf("  Expected session format: session_*_inst%d_*\n", instance.ID)
+			fmt.Printf("  Expected session format: session_*_run%s_inst%d_*\n", manifest.RunID, instance.ID)
 
+			manifest.AddInstance(offer, instance, requestedAt)
+			// Сразу фиксируем инстанс на диске: он уже оплачивается
+			manifest.checkpoint()
 			mu.Lock()
 			createdInstances = append(createdInstances, instance)
 			mu.Unlock()
@@ -612,105 +239,267 @@ func main() {
 
 	// Ждем завершения создания всех экземпляров
 	wg.Wait()
+	sort.Slice(createdInstances, func(i, j int) bool { return createdInstances[i].ID < createdInstances[j].ID })
+	return createdInstances
+}
 
-	fmt.Printf("\n=== ALL INSTANCES CREATED ===\n")
-	fmt.Printf("Timestamp: %s\n", time.Now().Format("2006-01-02 15:04:05"))
-	fmt.Printf("Created %d instances, waiting %d minutes for them to be ready...\n", len(createdInstances), *waitMinutes)
-	fmt.Printf("Expected initialization time: 2-10 minutes depending on instance type\n")
-	
-	if len(createdInstances) > 0 {
-		fmt.Printf("\nCreated instance IDs:\n")
-		for i, inst := range createdInstances {
-			fmt.Printf("  [%d] Instance ID: %d\n", i+1, inst.ID)
+// runPool - подкоманда run (и вызов без подкоманды): поиск, создание, ожидание и запуск тестов
+func runPool(args []string) {
+	fs := flag.NewFlagSet("run", flag.ExitOnError)
+	count := fs.Int("count", 1, "how many instances needed")
+	waitMinutes := fs.Int("wait", 30, "minutes to wait for the pool to reach -count healthy instances; failed instances are replaced meanwhile")
+	startTests := fs.Bool("start-tests", false, "start tests on instances as soon as their SSH is ready")
+	autoDestroyFlag := fs.Bool("auto-destroy", false, "destroy each instance once its test session completes (requires -start-tests)")
+	maxLifetime := fs.Duration("max-lifetime", 30*time.Minute, "with -auto-destroy, destroy instances older than this regardless of session state")
+	parallel := fs.Int("parallel", 8, "how many instances to create concurrently")
+	resume := fs.Bool("resume", false, "continue an interrupted run from its pool state instead of creating instances")
+	runFlag := fs.String("run", "", "with -resume, the run ID to continue (default: the latest run); otherwise the ID of the new run (default: generated)")
+	filter := addOfferFlags(fs)
+	budget := addBudgetFlags(fs)
+	bundle := addRunnerFlags(fs)
+	lifecycle := addLifecycleFlags(fs)
+	heartbeat := addHeartbeatFlags(fs)
+	dashboardMode := addDashboardFlag(fs)
+	common := addCommonFlags(fs, ProviderVast)
+	common.parse(args)
+
+	manifestStore := common.openStore()
+	if manifestStore != nil {
+		defer manifestStore.Close()
+	}
+	var manifest *RunManifest
+	if *resume {
+		manifest = loadRun(*runFlag, manifestStore)
+		restoreRunFlags(fs, manifest)
+	}
+
+	if *autoDestroyFlag && !*startTests {
+		log.Fatal("-auto-destroy requires -start-tests")
+	}
+	if err := filter.Validate(); err != nil {
+		log.Fatal(err)
+	}
+	if err := budget.Validate(); err != nil {
+		log.Fatal(err)
+	}
+	if err := lifecycle.Validate(); err != nil {
+		log.Fatal(err)
+	}
+	if err := heartbeat.Validate(); err != nil {
+		log.Fatal(err)
+	}
+	if !*resume && *runFlag != "" {
+		if err := checkNewRunID(*runFlag); err != nil {
+			log.Fatal(err)
+		}
+	}
+	if *startTests {
+		if err := bundle.Prepare(); err != nil {
+			log.Fatal(err)
 		}
+		bundle.Heartbeat = heartbeat
+		heartbeat.serveHeartbeats()
 	}
 
-	fmt.Printf("\nWaiting %d minutes for instance initialization...\n", *waitMinutes)
-	for i := 1; i <= *waitMinutes; i++ {
-		fmt.Printf("\n  [%s] Minute %d/%d - Checking instance statuses:\n", time.Now().Format("15:04:05"), i, *waitMinutes)
-		
-		// Проверяем статус каждого инстанса каждую минуту
-		readyCount := 0
-		for _, instance := range createdInstances {
-			statusInstance, err := client.GetInstance(instance.ID)
-			if err != nil {
-				fmt.Printf("    Instance %d: ERROR checking status\n", instance.ID)
-				continue
-			}
-			
-			statusIcon := "⏳"
-			if statusInstance.Status == "running" {
-				statusIcon = "✅"
-				readyCount++
-			} else if statusInstance.Status == "loading" {
-				statusIcon = "🔄"
-			} else if statusInstance.Status == "created" {
-				statusIcon = "🆕"
-			} else if statusInstance.Status == "error" {
-				statusIcon = "❌"
-			}
-			
-			sshStatus := ""
-			if statusInstance.Status == "running" && statusInstance.SSHHost != "" {
-				// Быстрая проверка SSH доступности
-				cmd := exec.Command("nc", "-z", "-w", "1", statusInstance.SSHHost, strconv.Itoa(statusInstance.SSHPort))
-				if err := cmd.Run(); err == nil {
-					sshStatus = " (SSH ready)"
-				} else {
-					sshStatus = " (SSH not ready)"
-				}
-			}
-			
-			fmt.Printf("    %s Instance %d: %s%s\n", statusIcon, instance.ID, statusInstance.Status, sshStatus)
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	client := common.newProvider(nil)
+	fmt.Printf("Provider: %s\n", client.Name())
+
+	saveManifest := func() {
+		if err := manifest.Save(); err != nil {
+			log.Printf("Warning: could not save run manifest: %v", err)
+		}
+	}
+
+	var createdInstances []*Instance
+	var dashboard *Dashboard
+	if manifest != nil {
+		fmt.Printf("Resuming run %s\n", manifest.RunID)
+		fmt.Printf("\n=== RESUMED POOL STATE ===\n")
+		for _, id := range manifest.Active() {
+			mi, _ := manifest.Instance(id)
+			fmt.Printf("  Instance %d: status %s, stage %s\n", id, mi.Status, mi.Stage)
+			createdInstances = append(createdInstances, &Instance{ID: id})
+		}
+		if len(createdInstances) == 0 && len(manifest.Candidates) == 0 {
+			log.Fatalf("Run %s has no live instances or spare offers to resume", manifest.RunID)
+		}
+		manifest.SetBudget(budget.Projection(manifest.Offers))
+		dashboard = startDashboard(*dashboardMode, manifest, manifestStore, cancel)
+	} else {
+		runID := *runFlag
+		if runID == "" {
+			runID = newRunID()
+		}
+		fmt.Printf("Run ID: %s\n", runID)
+		manifest = NewRunManifest(runID, client.Name(), fs, manifestStore)
+
+		offers, err := searchOffers(ctx, client, filter, *count)
+		if err != nil {
+			log.Fatal(err)
 		}
-		
-		fmt.Printf("  Ready: %d/%d instances\n", readyCount, len(createdInstances))
-		
-		// Если все готовы, можем прервать ожидание
-		if readyCount == len(createdInstances) && i < *waitMinutes {
-			fmt.Printf("\n  🎉 All instances ready! Proceeding early...\n")
-			break
+		if len(offers) == 0 {
+			log.Fatal("No offers match the filters")
 		}
-		
-		if i < *waitMinutes {
-			time.Sleep(1 * time.Minute)
+		if len(offers) < *count {
+			fmt.Printf("Only %d suitable offers available, creating %d instances instead of %d\n", len(offers), len(offers), *count)
+			*count = len(offers)
+		}
+		offersSlice := budget.Select(offers, *count)
+		if len(offersSlice) == 0 {
+			log.Fatalf("No offer fits the budget (-max-total-hourly=%.2f, -max-run-cost=%.2f over %v)",
+				budget.MaxHourly, budget.MaxRunCost, budget.SessionLength)
+		}
+		manifest.SetOffers(offersSlice)
+		manifest.SetCandidates(spareOffers(offers, offersSlice))
+		manifest.SetBudget(budget.Projection(offersSlice))
+		fmt.Printf("Projected cost: $%.4f/hour, $%.4f over %v\n",
+			manifest.Budget.ProjectedHourlyUSD, manifest.Budget.ProjectedCostUSD, budget.SessionLength)
+
+		dashboard = startDashboard(*dashboardMode, manifest, manifestStore, cancel)
+		createdInstances = createInstances(ctx, client, offersSlice, manifest, *parallel)
+		saveManifest()
+
+		fmt.Printf("\n=== ALL INSTANCES CREATED ===\n")
+		fmt.Printf("Timestamp: %s\n", time.Now().Format("2006-01-02 15:04:05"))
+		fmt.Printf("Created %d instances, waiting up to %d minutes for them to be ready...\n", len(createdInstances), *waitMinutes)
+		fmt.Printf("Expected initialization time: 2-10 minutes depending on instance type\n")
+
+		if len(createdInstances) > 0 {
+			fmt.Printf("\nCreated instance IDs:\n")
+			for i, inst := range createdInstances {
+				fmt.Printf("  [%d] Instance ID: %d\n", i+1, inst.ID)
+			}
 		}
 	}
 
-	// Собираем готовые экземпляры
-	fmt.Printf("\n=== CHECKING READY INSTANCES ===\n")
-	fmt.Printf("Timestamp: %s\n", time.Now().Format("2006-01-02 15:04:05"))
-	var readyInstances []*Instance
+	supervisor := &poolSupervisor{
+		client:    client,
+		manifest:  manifest,
+		lifecycle: lifecycle,
+		budget:    budget,
+		target:    *count,
+		parallel:  *parallel,
+	}
+	for _, inst := range createdInstances {
+		supervisor.ids = append(supervisor.ids, inst.ID)
+	}
+	// Тесты запускаются на каждом инстансе, как только он готов; после --resume уже
+	// запущенные (deployed, testing) не перезапускаются
+	if *startTests {
+		supervisor.bundle = bundle
+	}
+	var readyInstances []int
+	if ctx.Err() == nil {
+		readyInstances = supervisor.Run(ctx, time.Duration(*waitMinutes)*time.Minute)
+	}
 
-	for i, instance := range createdInstances {
-		fmt.Printf("[%d/%d] [%s] Checking instance %d... ", i+1, len(createdInstances), time.Now().Format("15:04:05"), instance.ID)
-		readyInstance, err := client.GetInstance(instance.ID)
-		if err != nil {
-			fmt.Printf("ERROR: %v\n", err)
-			continue
+	fmt.Printf("\n=== POOL READY ===\n")
+	fmt.Printf("Ready instances: %d/%d\n", len(readyInstances), *count)
+	if len(readyInstances) == 0 {
+		fmt.Printf("\nNo ready instances to connect to.\n")
+	}
+
+	saveManifest()
+
+	switch {
+	case ctx.Err() != nil:
+		// Прерван по Ctrl-C: инстансы не трогаем, запуск продолжается через -resume
+		fmt.Printf("\nRun %s interrupted, instances keep running; continue it with --resume --run %s\n", manifest.RunID, manifest.RunID)
+	case *autoDestroyFlag:
+		autoDestroy(ctx, client, manifest, manifestStore, bundle.Heartbeat, *maxLifetime, time.Minute)
+		saveManifest()
+	case budget.MaxRunCost > 0:
+		watchBudget(ctx, client, manifest, bundle.Heartbeat, time.Minute)
+		saveManifest()
+	default:
+		// Без наблюдения пульсы последний раз читались в пуле; сводка должна показать свежие
+		trackHeartbeats(manifest, bundle.Heartbeat)
+		saveManifest()
+	}
+	dashboard.Stop()
+
+	fmt.Printf("\nRun manifest: %s\n", filepath.FromSlash(manifest.manifestKey()))
+	printCostBreakdown(manifest)
+	printHeartbeats(manifest)
+	printCostSummary(manifest)
+	printAPIMetrics(client)
+
+	if common.json() {
+		printJSON(manifest)
+	}
+	if ctx.Err() != nil {
+		if manifestStore != nil {
+			manifestStore.Close()
 		}
+		os.Exit(130)
+	}
+}
 
-		if readyInstance.Status == "running" && readyInstance.SSHHost != "" {
-			fmt.Printf("READY (Host: %s, Port: %d)\n", readyInstance.SSHHost, readyInstance.SSHPort)
-			readyInstances = append(readyInstances, readyInstance)
-		} else {
-			fmt.Printf("NOT READY (Status: %s)\n", readyInstance.Status)
+// checkNewRunID проверяет заданный ID нового запуска: он встраивается в ID сессии и путь ./runs/<run>
+func checkNewRunID(runID string) error {
+	if strings.ContainsAny(runID, "_/\\ ") || runID == "." || runID == ".." {
+		return fmt.Errorf("invalid run ID %q: underscores, slashes and spaces are not allowed", runID)
+	}
+	if _, err := os.Stat(filepath.Join("runs", runID, "run_manifest.json")); err == nil {
+		return fmt.Errorf("run %s already exists; continue it with -resume", runID)
+	}
+	return nil
+}
+
+// spareOffers возвращает подходящие предложения, не попавшие в пул, в порядке ранга
+func spareOffers(offers, selected []Offer) []Offer {
+	taken := make(map[int]bool)
+	for _, o := range selected {
+		taken[o.ID] = true
+	}
+	var spare []Offer
+	for _, o := range offers {
+		if !taken[o.ID] {
+			spare = append(spare, o)
 		}
 	}
+	return spare
+}
 
-	fmt.Printf("\n=== POOL READY ===\n")
-	fmt.Printf("Ready instances: %d/%d\n", len(readyInstances), len(createdInstances))
+// restoreRunFlags берет флаги возобновляемого запуска из манифеста, кроме заданных в командной строке
+func restoreRunFlags(fs *flag.FlagSet, m *RunManifest) {
+	explicit := make(map[string]bool)
+	fs.Visit(func(f *flag.Flag) {
+		explicit[f.Name] = true
+	})
+	for name, value := range m.Flags {
+		switch name {
+		case "resume", "run", "output", "dashboard":
+			continue
+		}
+		if explicit[name] || fs.Lookup(name) == nil {
+			continue
+		}
+		if err := fs.Set(name, value); err != nil {
+			log.Printf("Warning: ignoring saved flag -%s=%s: %v", name, value, err)
+		}
+	}
+}
 
-	// Подключение к готовым экземплярам
-	if len(readyInstances) > 0 {
-		// Запускаем тесты если флаг установлен
-		if *startTests {
-			err := startTestsOnInstances(readyInstances)
-			if err != nil {
-				fmt.Printf("Error starting tests: %v\n", err)
-			}
+// printAPIMetrics печатает статистику запросов, если провайдер ее собирает
+func printAPIMetrics(p Provider) {
+	reporter, ok := p.(interface{ APIMetrics() []EndpointMetrics })
+	if !ok {
+		return
+	}
+	metrics := reporter.APIMetrics()
+	if len(metrics) == 0 {
+		return
+	}
+	fmt.Printf("\n=== API METRICS ===\n")
+	for _, m := range metrics {
+		avg := time.Duration(0)
+		if m.Requests > 0 {
+			avg = m.TotalTime / time.Duration(m.Requests)
 		}
-	} else {
-		fmt.Printf("\nNo ready instances to connect to.\n")
+		fmt.Printf("  %-28s requests: %d, retries: %d, 429s: %d, errors: %d, avg: %v, max: %v\n",
+			m.Endpoint, m.Requests, m.Retries, m.RateLimited, m.Errors, avg.Round(time.Millisecond), m.MaxTime.Round(time.Millisecond))
 	}
 }
@@ -0,0 +1,343 @@
+package main
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	"gopkg.in/yaml.v3"
+
+	"highloadtest/runnerconfig"
+	"highloadtest/storage"
+)
+
+// newRunID создает ID запуска пула; без подчеркиваний, т.к. встраивается в ID сессии
+func newRunID() string {
+	randomBytes := make([]byte, 2)
+	rand.Read(randomBytes)
+	return fmt.Sprintf("%s-%x", time.Now().Format("20060102-150405"), randomBytes)
+}
+
+type ManifestInstance struct {
+	InstanceID   int       `json:"instance_id"`
+	OfferID      int       `json:"offer_id"`
+	GPUName      string    `json:"gpu_name"`
+	NumGPUs      int       `json:"num_gpus"`
+	PricePerHour float64   `json:"price_per_hour"`
+	Verification string    `json:"verification"`
+	CreatedAt    time.Time `json:"created_at"`
+	Status       string    `json:"status,omitempty"`
+	SSHHost      string    `json:"ssh_host,omitempty"`
+	SSHPort      int       `json:"ssh_port,omitempty"`
+	TestStarted  bool      `json:"test_started"`
+	Error        string    `json:"error,omitempty"`
+
+	Stage          string         `json:"stage"`
+	StageChangedAt time.Time      `json:"stage_changed_at"`
+	History        []StatusChange `json:"history,omitempty"`
+	// Probe - последняя проверка готовности перед развертыванием
+	Probe *ProbeResult `json:"probe,omitempty"`
+	// Heartbeat - последний пульс раннера; HeartbeatLost - пульс пропал дольше -heartbeat-timeout
+	Heartbeat     *Heartbeat `json:"heartbeat,omitempty"`
+	HeartbeatLost bool       `json:"heartbeat_lost,omitempty"`
+
+	SessionID     string     `json:"session_id,omitempty"`
+	SessionStatus string     `json:"session_status,omitempty"`
+	DestroyedAt   *time.Time `json:"destroyed_at,omitempty"`
+	DestroyReason string     `json:"destroy_reason,omitempty"`
+	CostUSD       float64    `json:"cost_usd,omitempty"`
+}
+
+// StatusChange - запись истории инстанса: статус у провайдера и этап жизненного цикла
+type StatusChange struct {
+	At      time.Time `json:"at"`
+	Status  string    `json:"status"`
+	Stage   string    `json:"stage,omitempty"`
+	Message string    `json:"message,omitempty"`
+}
+
+// setStatus меняет статус и добавляет запись в историю, если статус или сообщение изменились
+func (mi *ManifestInstance) setStatus(status, message string) {
+	if mi.Status == status && len(mi.History) > 0 && mi.History[len(mi.History)-1].Message == message {
+		return
+	}
+	mi.Status = status
+	mi.History = append(mi.History, StatusChange{At: time.Now(), Status: status, Stage: mi.Stage, Message: message})
+}
+
+// setStage переводит инстанс на этап и записывает переход в историю
+func (mi *ManifestInstance) setStage(stage, message string) {
+	if mi.Stage == stage {
+		return
+	}
+	mi.Stage = stage
+	mi.StageChangedAt = time.Now()
+	mi.History = append(mi.History, StatusChange{At: mi.StageChangedAt, Status: mi.Status, Stage: stage, Message: message})
+}
+
+// RunManifest описывает один запуск пула: что выбрали, что создали и с какими флагами
+type RunManifest struct {
+	RunID     string             `json:"run_id"`
+	Provider  string             `json:"provider"`
+	CreatedAt time.Time          `json:"created_at"`
+	UpdatedAt time.Time          `json:"updated_at"`
+	Host      string             `json:"host"`
+	Flags     map[string]string  `json:"flags"`
+	Offers    []Offer            `json:"offers"`
+	Instances []ManifestInstance `json:"instances"`
+	Failures  []string           `json:"failures,omitempty"`
+	CostUSD   float64            `json:"total_cost_usd"`
+	Budget    *RunBudget         `json:"budget,omitempty"`
+	Runner    []RunnerFile       `json:"runner,omitempty"`
+
+	Candidates   []Offer `json:"candidates,omitempty"` // запасные предложения для замены упавших инстансов
+	Replacements int     `json:"replacements,omitempty"`
+
+	mu    sync.Mutex
+	store storage.ArtifactStore
+
+	// Снимки нумеруются под mu. Запись на диск и загрузка в хранилище идут под своими мьютексами
+	// и пропускают снимок старше уже записанного: иначе параллельный Checkpoint мог бы
+	// переименовать старый снимок поверх нового
+	seq      uint64
+	writeMu  sync.Mutex
+	written  uint64
+	uploadMu sync.Mutex
+	uploaded uint64
+}
+
+func NewRunManifest(runID, provider string, fs *flag.FlagSet, store storage.ArtifactStore) *RunManifest {
+	host, _ := os.Hostname()
+	flags := make(map[string]string)
+	fs.VisitAll(func(f *flag.Flag) {
+		flags[f.Name] = f.Value.String()
+	})
+	return &RunManifest{
+		RunID:     runID,
+		Provider:  provider,
+		CreatedAt: time.Now(),
+		Host:      host,
+		Flags:     flags,
+		store:     store,
+	}
+}
+
+func (m *RunManifest) SetOffers(offers []Offer) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.Offers = append([]Offer(nil), offers...)
+}
+
+// AddInstance записывает созданный инстанс; requestedAt - когда ушел запрос на аренду
+func (m *RunManifest) AddInstance(offer Offer, instance *Instance, requestedAt time.Time) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	mi := ManifestInstance{
+		InstanceID:   instance.ID,
+		OfferID:      offer.ID,
+		GPUName:      offer.GPUName,
+		NumGPUs:      offer.NumGPUs,
+		PricePerHour: offer.DPHTotal,
+		Verification: offer.Verification,
+		CreatedAt:    time.Now(),
+		Status:       StatusCreated,
+		Stage:        StageRequested,
+		History:      []StatusChange{{At: requestedAt, Stage: StageRequested}},
+	}
+	mi.setStage(StageCreated, "")
+	m.Instances = append(m.Instances, mi)
+}
+
+// RecordStatus сохраняет статус и SSH адрес, полученные от провайдера, и продвигает этап:
+// created, loading и running следуют за статусом, error и exited переводят инстанс в failed
+func (m *RunManifest) RecordStatus(instance *Instance) {
+	m.UpdateInstance(instance.ID, func(mi *ManifestInstance) {
+		message := strings.TrimSpace(instance.StatusMessage)
+		mi.setStatus(instance.Status, message)
+		mi.SSHHost = instance.SSHHost
+		mi.SSHPort = instance.SSHPort
+		switch {
+		case stageFinal(mi.Stage):
+		case instance.Status == StatusError || instance.Status == StatusExited:
+			mi.Error = strings.TrimSpace(fmt.Sprintf("instance %s %s", instance.Status, message))
+			mi.setStage(StageFailed, mi.Error)
+		default:
+			// Этапы только растут: устаревший ответ провайдера не откатывает инстанс назад
+			if stage, ok := providerStages[instance.Status]; ok && stageBefore(mi.Stage, stage) {
+				mi.setStage(stage, "")
+			}
+		}
+	})
+}
+
+// SetStage переводит инстанс на этап; errMsg пишется в Error (пустой - очищает)
+func (m *RunManifest) SetStage(id int, stage, errMsg string) {
+	m.UpdateInstance(id, func(mi *ManifestInstance) {
+		mi.setStage(stage, errMsg)
+		mi.Error = errMsg
+		if stage == StageDeployed {
+			mi.TestStarted = true
+		}
+	})
+}
+
+func (m *RunManifest) AddFailure(format string, args ...interface{}) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.Failures = append(m.Failures, fmt.Sprintf(format, args...))
+}
+
+func (m *RunManifest) UpdateInstance(id int, update func(*ManifestInstance)) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	for i := range m.Instances {
+		if m.Instances[i].InstanceID == id {
+			update(&m.Instances[i])
+			return
+		}
+	}
+}
+
+func (m *RunManifest) manifestKey() string {
+	return fmt.Sprintf("runs/%s/run_manifest.json", m.RunID)
+}
+
+// Checkpoint атомарно перезаписывает локальный манифест в ./runs: он же файл состояния пула,
+// по которому --resume продолжает запуск после падения процесса
+func (m *RunManifest) Checkpoint() ([]byte, error) {
+	data, _, err := m.checkpointSeq()
+	return data, err
+}
+
+// checkpointSeq делает Checkpoint и возвращает номер снимка
+func (m *RunManifest) checkpointSeq() ([]byte, uint64, error) {
+	cost, _ := m.Cost()
+	m.mu.Lock()
+	m.seq++
+	seq := m.seq
+	m.UpdatedAt = time.Now()
+	m.CostUSD = cost
+	// Начисленная стоимость живых инстансов, удаленным ее фиксирует MarkDestroyed
+	for i := range m.Instances {
+		if mi := &m.Instances[i]; mi.DestroyedAt == nil && mi.Status != InstanceGone {
+			mi.CostUSD, _ = instanceCost(*mi, m.UpdatedAt)
+		}
+	}
+	data, err := json.MarshalIndent(m, "", "  ")
+	m.mu.Unlock()
+	if err != nil {
+		return nil, 0, err
+	}
+
+	m.writeMu.Lock()
+	defer m.writeMu.Unlock()
+	if seq < m.written {
+		return data, seq, nil
+	}
+	if err := writeFileAtomic(filepath.FromSlash(m.manifestKey()), data); err != nil {
+		return nil, 0, err
+	}
+	m.written = seq
+	return data, seq, nil
+}
+
+// writeFileAtomic пишет во временный файл рядом и переименовывает, чтобы не оставить обрезанный JSON
+func writeFileAtomic(path string, data []byte) error {
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return err
+	}
+	tmp, err := os.CreateTemp(filepath.Dir(path), "."+strings.TrimSuffix(filepath.Base(path), filepath.Ext(path))+"-*.tmp")
+	if err != nil {
+		return fmt.Errorf("failed to write %s: %v", path, err)
+	}
+	if _, err := tmp.Write(data); err == nil {
+		err = tmp.Sync()
+	}
+	if closeErr := tmp.Close(); err == nil {
+		err = closeErr
+	}
+	if err == nil {
+		err = os.Rename(tmp.Name(), path)
+	}
+	if err != nil {
+		os.Remove(tmp.Name())
+		return fmt.Errorf("failed to write %s: %v", path, err)
+	}
+	return nil
+}
+
+// checkpoint - Checkpoint для промежуточных точек, где ошибка записи не должна прерывать работу
+func (m *RunManifest) checkpoint() {
+	if _, err := m.Checkpoint(); err != nil {
+		log.Printf("Warning: could not checkpoint pool state: %v", err)
+	}
+}
+
+// Save пишет манифест локально в ./runs и, если настроено, в хранилище артефактов
+func (m *RunManifest) Save() error {
+	data, seq, err := m.checkpointSeq()
+	if err != nil {
+		return err
+	}
+
+	if m.store != nil {
+		m.uploadMu.Lock()
+		defer m.uploadMu.Unlock()
+		if seq < m.uploaded {
+			return nil
+		}
+		ctx, cancel := context.WithTimeout(context.Background(), time.Minute)
+		defer cancel()
+		if err := storage.PutBytes(ctx, m.store, m.manifestKey(), data); err != nil {
+			return fmt.Errorf("failed to upload run manifest to %s: %v", m.store, err)
+		}
+		m.uploaded = seq
+	}
+	return nil
+}
+
+// openManifestStore читает секцию storage из конфига раннера (тот же формат YAML/JSON)
+func openManifestStore(configPath string) (storage.ArtifactStore, error) {
+	if configPath == "" {
+		return nil, nil
+	}
+	cfg, err := loadStorageConfig(configPath)
+	if err != nil {
+		return nil, err
+	}
+	return storage.New(context.Background(), cfg)
+}
+
+// loadStorageConfig читает секцию storage из конфига сессии раннера
+func loadStorageConfig(configPath string) (storage.Config, error) {
+	session, err := loadRunnerSession(configPath)
+	return session.Storage, err
+}
+
+// runnerSession - то, что оркестратору нужно из конфига сессии раннера
+type runnerSession struct {
+	Storage            storage.Config `yaml:"storage"`
+	Duration           time.Duration  `yaml:"duration"`
+	ScreenshotInterval time.Duration  `yaml:"screenshot_interval"`
+}
+
+// loadRunnerSession читает конфиг сессии раннера; незаданные интервалы - умолчания раннера
+func loadRunnerSession(configPath string) (runnerSession, error) {
+	session := runnerSession{Duration: runnerconfig.DefaultDuration, ScreenshotInterval: runnerconfig.DefaultScreenshotInterval}
+	data, err := os.ReadFile(configPath)
+	if err != nil {
+		return runnerSession{}, fmt.Errorf("failed to read %s: %v", configPath, err)
+	}
+	if err := yaml.Unmarshal(data, &session); err != nil {
+		return runnerSession{}, fmt.Errorf("failed to parse %s: %v", configPath, err)
+	}
+	return session, nil
+}
@@ -0,0 +1,88 @@
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+
+	"highloadtest/runnerconfig"
+	"highloadtest/storage"
+)
+
+// TestManifestConcurrentSavesKeepLatestSnapshot: параллельные Checkpoint и Save не должны
+// оставить на диске или в хранилище снимок старше последнего
+func TestManifestConcurrentSavesKeepLatestSnapshot(t *testing.T) {
+	t.Chdir(t.TempDir())
+	store, err := storage.NewLocalStore(storage.LocalConfig{Dir: t.TempDir()})
+	if err != nil {
+		t.Fatal(err)
+	}
+	m := NewRunManifest(newRunID(), ProviderFake, flag.NewFlagSet("run", flag.ContinueOnError), store)
+
+	const writers = 50
+	var wg sync.WaitGroup
+	for i := 0; i < writers; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			m.mu.Lock()
+			m.Replacements++
+			m.mu.Unlock()
+			if i%2 == 0 {
+				m.checkpoint()
+			} else if err := m.Save(); err != nil {
+				t.Error(err)
+			}
+		}(i)
+	}
+	wg.Wait()
+
+	check := func(where string, data []byte) {
+		t.Helper()
+		var saved RunManifest
+		if err := json.Unmarshal(data, &saved); err != nil {
+			t.Fatalf("%s: %v", where, err)
+		}
+		if saved.Replacements != writers {
+			t.Fatalf("%s holds a stale snapshot: %d of %d updates", where, saved.Replacements, writers)
+		}
+	}
+	data, err := os.ReadFile(filepath.FromSlash(m.manifestKey()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	check("local manifest", data)
+
+	// Последний Save мог загрузить снимок до последнего checkpoint; еще один Save выравнивает хранилище
+	if err := m.Save(); err != nil {
+		t.Fatal(err)
+	}
+	data, err = os.ReadFile(filepath.Join(store.Root(), filepath.FromSlash(m.manifestKey())))
+	if err != nil {
+		t.Fatal(err)
+	}
+	check("uploaded manifest", data)
+}
+
+func TestLoadRunnerSession(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "session.yaml")
+	config := "duration: 10m\nstorage:\n  backend: sftp\n  sftp:\n    host: storage.example.com\n    user: load\n    password: secret\n    dir: files\n"
+	if err := os.WriteFile(path, []byte(config), 0644); err != nil {
+		t.Fatal(err)
+	}
+	session, err := loadRunnerSession(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// screenshot_interval не задан - остается умолчание раннера
+	if session.Duration != 10*time.Minute || session.ScreenshotInterval != runnerconfig.DefaultScreenshotInterval {
+		t.Fatalf("duration %v, screenshot interval %v", session.Duration, session.ScreenshotInterval)
+	}
+	if got := session.Storage.Target(); got != "sftp://load@storage.example.com/files" {
+		t.Fatalf("storage target = %q", got)
+	}
+}
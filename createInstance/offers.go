@@ -0,0 +1,238 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"math"
+	"sort"
+	"strings"
+)
+
+// Способы ранжирования предложений
+const (
+	RankPrice               = "price"
+	RankPricePerReliability = "price-per-reliability"
+	RankReliability         = "reliability"
+)
+
+// maxOfferSearch - предел, до которого searchOffers увеличивает выборку, если фильтры отсеивают почти все
+const maxOfferSearch = 1000
+
+// OfferFilter - требования к машине пула. Числовые границы провайдер может применить на своей
+// стороне (см. OfferQuery), но Match перепроверяет все: у провайдеров разная полнота поиска
+type OfferFilter struct {
+	VerifiedOnly   bool
+	MaxPrice       float64
+	GPUs           listFlag // подстроки названия GPU, хотя бы одна должна совпасть
+	ExcludeGPUs    listFlag
+	Countries      listFlag // код страны ("US") или часть geolocation ("California")
+	MinReliability float64
+	MinInetDown    float64 // Mbps
+	MinInetUp      float64 // Mbps
+	MinCPUCores    float64
+	MinRAMGB       float64
+	MinCUDA        float64
+	Rank           string
+}
+
+// listFlag - флаг со списком через запятую; Set заменяет значение целиком
+type listFlag []string
+
+func (l *listFlag) String() string {
+	return strings.Join(*l, ",")
+}
+
+func (l *listFlag) Set(value string) error {
+	*l = nil
+	for _, part := range strings.Split(value, ",") {
+		if part = strings.TrimSpace(part); part != "" {
+			*l = append(*l, part)
+		}
+	}
+	return nil
+}
+
+// addOfferFlags регистрирует флаги отбора предложений для offers, create и run
+func addOfferFlags(fs *flag.FlagSet) *OfferFilter {
+	f := &OfferFilter{ExcludeGPUs: listFlag{"3090", "4090"}}
+	fs.BoolVar(&f.VerifiedOnly, "verified", false, "use only verified instances")
+	fs.Float64Var(&f.MaxPrice, "max-price", 0.50, "maximum price per hour in USD")
+	fs.Var(&f.GPUs, "gpu", "comma-separated GPU names to allow, matched as substrings (default: any)")
+	fs.Var(&f.ExcludeGPUs, "exclude-gpu", "comma-separated GPU names to skip, matched as substrings")
+	fs.Var(&f.Countries, "country", "comma-separated country codes or location names to allow (default: any)")
+	fs.Float64Var(&f.MinReliability, "min-reliability", 0, "minimum host reliability, 0..1")
+	fs.Float64Var(&f.MinInetDown, "min-download", 0, "minimum download bandwidth in Mbps")
+	fs.Float64Var(&f.MinInetUp, "min-upload", 0, "minimum upload bandwidth in Mbps")
+	fs.Float64Var(&f.MinCPUCores, "min-cpu", 0, "minimum effective CPU cores")
+	fs.Float64Var(&f.MinRAMGB, "min-ram", 0, "minimum system RAM in GB")
+	fs.Float64Var(&f.MinCUDA, "min-cuda", 0, "minimum supported CUDA version, e.g. 12.2")
+	fs.StringVar(&f.Rank, "rank", RankPrice, "offer ranking: price, price-per-reliability or reliability")
+	return f
+}
+
+func (f *OfferFilter) Validate() error {
+	switch f.Rank {
+	case RankPrice, RankPricePerReliability, RankReliability:
+	default:
+		return fmt.Errorf("-rank must be %s, %s or %s", RankPrice, RankPricePerReliability, RankReliability)
+	}
+	if f.MinReliability < 0 || f.MinReliability > 1 {
+		return fmt.Errorf("-min-reliability must be between 0 and 1")
+	}
+	if f.MaxPrice <= 0 {
+		return fmt.Errorf("-max-price must be positive")
+	}
+	return nil
+}
+
+// Query строит запрос к провайдеру на limit предложений
+func (f *OfferFilter) Query(limit int) OfferQuery {
+	return OfferQuery{
+		VerifiedOnly:   f.VerifiedOnly,
+		Limit:          limit,
+		MaxPrice:       f.MaxPrice,
+		MinReliability: f.MinReliability,
+		MinInetDown:    f.MinInetDown,
+		MinInetUp:      f.MinInetUp,
+		MinCPUCores:    f.MinCPUCores,
+		MinRAMGB:       f.MinRAMGB,
+		MinCUDA:        f.MinCUDA,
+	}
+}
+
+func containsFold(s string, substrings []string) bool {
+	s = strings.ToLower(s)
+	for _, sub := range substrings {
+		if strings.Contains(s, strings.ToLower(sub)) {
+			return true
+		}
+	}
+	return false
+}
+
+// countryMatches сравнивает код страны в конце geolocation ("Oregon, US"); длинные значения
+// ищутся подстрокой, коды - только целиком, чтобы "US" не совпал с "Russia"
+func countryMatches(geolocation string, countries []string) bool {
+	code := geolocation
+	if i := strings.LastIndexByte(code, ','); i >= 0 {
+		code = code[i+1:]
+	}
+	code = strings.TrimSpace(code)
+	for _, country := range countries {
+		if strings.EqualFold(code, country) {
+			return true
+		}
+		if len(country) > 3 && containsFold(geolocation, []string{country}) {
+			return true
+		}
+	}
+	return false
+}
+
+// Match проверяет предложение; при отказе возвращает причину
+func (f *OfferFilter) Match(o Offer) (bool, string) {
+	switch {
+	case o.DPHTotal > f.MaxPrice:
+		return false, "price"
+	case f.VerifiedOnly && o.Verification != "verified":
+		return false, "verification"
+	case len(f.GPUs) > 0 && !containsFold(o.GPUName, f.GPUs):
+		return false, "gpu"
+	case containsFold(o.GPUName, f.ExcludeGPUs):
+		return false, "excluded gpu"
+	case len(f.Countries) > 0 && !countryMatches(o.Geolocation, f.Countries):
+		return false, "country"
+	case o.Reliability < f.MinReliability:
+		return false, "reliability"
+	case o.InetDown < f.MinInetDown:
+		return false, "download"
+	case o.InetUp < f.MinInetUp:
+		return false, "upload"
+	case o.CPUCores < f.MinCPUCores:
+		return false, "cpu"
+	case o.CPURAM/1024 < f.MinRAMGB:
+		return false, "ram"
+	case o.CudaMaxGood < f.MinCUDA:
+		return false, "cuda"
+	}
+	return true, ""
+}
+
+// rankScore - чем меньше, тем лучше
+func (f *OfferFilter) rankScore(o Offer) float64 {
+	switch f.Rank {
+	case RankPricePerReliability:
+		if o.Reliability <= 0 {
+			return math.Inf(1)
+		}
+		return o.DPHTotal / o.Reliability
+	case RankReliability:
+		return -o.Reliability
+	default:
+		return o.DPHTotal
+	}
+}
+
+// Select оставляет подходящие предложения, отсортированные по рангу; печатает, что и почему отсеяно
+func (f *OfferFilter) Select(offers []Offer) []Offer {
+	var selected []Offer
+	rejected := make(map[string]int)
+	for _, offer := range offers {
+		if ok, reason := f.Match(offer); ok {
+			selected = append(selected, offer)
+		} else {
+			rejected[reason]++
+		}
+	}
+
+	if len(rejected) > 0 {
+		var reasons []string
+		for reason, n := range rejected {
+			reasons = append(reasons, fmt.Sprintf("%s: %d", reason, n))
+		}
+		sort.Strings(reasons)
+		fmt.Printf("Found %d offers, %d match the filters (rejected by %s)\n", len(offers), len(selected), strings.Join(reasons, ", "))
+	} else {
+		fmt.Printf("Found %d offers, all match the filters\n", len(offers))
+	}
+
+	sort.SliceStable(selected, func(i, j int) bool {
+		si, sj := f.rankScore(selected[i]), f.rankScore(selected[j])
+		if si != sj {
+			return si < sj
+		}
+		return selected[i].DPHTotal < selected[j].DPHTotal
+	})
+	return selected
+}
+
+// searchOffers ищет предложения, пока подходящих не наберется count: выборка у провайдера
+// удваивается, если фильтры отсеяли слишком много. Подходящих может оказаться меньше count
+func searchOffers(ctx context.Context, client Provider, filter *OfferFilter, count int) ([]Offer, error) {
+	if filter.VerifiedOnly {
+		fmt.Println("Searching for VERIFIED GPU instances...")
+	} else {
+		fmt.Println("Searching for ALL available GPU instances...")
+	}
+
+	limit := count * 2
+	if limit < 32 {
+		limit = 32
+	}
+	for {
+		offers, err := client.SearchOffers(ctx, filter.Query(limit))
+		if err != nil {
+			return nil, fmt.Errorf("failed to search offers: %v", err)
+		}
+		selected := filter.Select(offers)
+		if len(selected) >= count || len(offers) < limit || limit >= maxOfferSearch {
+			return selected, nil
+		}
+		limit *= 2
+		if limit > maxOfferSearch {
+			limit = maxOfferSearch
+		}
+		fmt.Printf("Only %d of %d needed offers match, fetching up to %d offers...\n", len(selected), count, limit)
+	}
+}
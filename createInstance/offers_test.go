@@ -0,0 +1,97 @@
+package main
+
+import (
+	"fmt"
+	"testing"
+)
+
+func TestCountryMatches(t *testing.T) {
+	cases := []struct {
+		geolocation string
+		countries   []string
+		want        bool
+	}{
+		{"Oregon, US", []string{"US"}, true},
+		{"Oregon, US", []string{"us"}, true},
+		{"Moscow, RU", []string{"US"}, false},
+		{"Russia", []string{"US"}, false}, // код сравнивается целиком, не подстрокой
+		{"California, US", []string{"california"}, true},
+		{"Quebec, CA", []string{"DE", "CA"}, true},
+		{"", []string{"US"}, false},
+	}
+	for _, c := range cases {
+		if got := countryMatches(c.geolocation, c.countries); got != c.want {
+			t.Errorf("countryMatches(%q, %v) = %v, want %v", c.geolocation, c.countries, got, c.want)
+		}
+	}
+}
+
+func TestOfferFilterMatch(t *testing.T) {
+	base := Offer{
+		GPUName: "RTX 4090", DPHTotal: 0.5, Verification: "verified", Reliability: 0.99,
+		Geolocation: "Oregon, US", InetDown: 500, InetUp: 200, CPUCores: 16, CPURAM: 64 * 1024, CudaMaxGood: 12.2,
+	}
+	filter := OfferFilter{
+		VerifiedOnly: true, MaxPrice: 0.5, GPUs: listFlag{"4090", "A100"}, ExcludeGPUs: listFlag{"Ti"},
+		Countries: listFlag{"US"}, MinReliability: 0.95, MinInetDown: 500, MinInetUp: 100,
+		MinCPUCores: 8, MinRAMGB: 64, MinCUDA: 12,
+	}
+	cases := []struct {
+		name   string
+		change func(o *Offer)
+		reason string
+	}{
+		{"all limits met exactly", func(o *Offer) {}, ""},
+		{"price above max", func(o *Offer) { o.DPHTotal = 0.51 }, "price"},
+		{"unverified", func(o *Offer) { o.Verification = "unverified" }, "verification"},
+		{"other gpu", func(o *Offer) { o.GPUName = "RTX 3090" }, "gpu"},
+		{"gpu matched case-insensitively", func(o *Offer) { o.GPUName = "a100 sxm4" }, ""},
+		{"excluded gpu", func(o *Offer) { o.GPUName = "RTX 4090 Ti" }, "excluded gpu"},
+		{"other country", func(o *Offer) { o.Geolocation = "Bavaria, DE" }, "country"},
+		{"low reliability", func(o *Offer) { o.Reliability = 0.9 }, "reliability"},
+		{"slow download", func(o *Offer) { o.InetDown = 499 }, "download"},
+		{"slow upload", func(o *Offer) { o.InetUp = 99 }, "upload"},
+		{"few cores", func(o *Offer) { o.CPUCores = 4 }, "cpu"},
+		{"little ram", func(o *Offer) { o.CPURAM = 32 * 1024 }, "ram"},
+		{"old cuda", func(o *Offer) { o.CudaMaxGood = 11.8 }, "cuda"},
+	}
+	for _, c := range cases {
+		offer := base
+		c.change(&offer)
+		ok, reason := filter.Match(offer)
+		if ok != (c.reason == "") || reason != c.reason {
+			t.Errorf("%s: Match = %v, %q; want reason %q", c.name, ok, reason, c.reason)
+		}
+	}
+}
+
+func TestOfferFilterSelectRanks(t *testing.T) {
+	offers := []Offer{
+		{ID: 1, DPHTotal: 0.30, Reliability: 0.90},
+		{ID: 2, DPHTotal: 0.20, Reliability: 0.50},
+		{ID: 3, DPHTotal: 0.40, Reliability: 0.99},
+		{ID: 4, DPHTotal: 0.90, Reliability: 0.99}, // дороже -max-price
+		{ID: 5, DPHTotal: 0.10, Reliability: 0},    // без надежности - в конец price-per-reliability
+		{ID: 6, DPHTotal: 0.30, Reliability: 0.99},
+	}
+	cases := []struct {
+		rank string
+		want []int
+	}{
+		{RankPrice, []int{5, 2, 1, 6, 3}},
+		// 0.30/0.99 < 0.30/0.90 < 0.20/0.50 < 0.40/0.99
+		{RankPricePerReliability, []int{6, 1, 2, 3, 5}},
+		// Равная надежность - дешевле раньше
+		{RankReliability, []int{6, 3, 1, 2, 5}},
+	}
+	for _, c := range cases {
+		filter := OfferFilter{MaxPrice: 0.5, Rank: c.rank}
+		var got []int
+		for _, o := range filter.Select(offers) {
+			got = append(got, o.ID)
+		}
+		if fmt.Sprint(got) != fmt.Sprint(c.want) {
+			t.Errorf("rank %s: selected %v, want %v", c.rank, got, c.want)
+		}
+	}
+}
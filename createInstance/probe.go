@@ -0,0 +1,131 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Проверки готовности инстанса к запуску браузера, выполняются после SSH рукопожатия
+const (
+	CheckDisplay = "display" // X сервер на :20, на нем start.sh запускает Chrome
+	CheckApt     = "apt"     // блокировки apt/dpkg свободны: start.sh ставит Chrome через apt
+	CheckDisk    = "disk"    // свободное место в домашнем каталоге под раннер и артефакты
+)
+
+var readyChecks = []string{CheckDisplay, CheckApt, CheckDisk}
+
+// ProbeCheck - результат одной проверки
+type ProbeCheck struct {
+	Name   string `json:"name"`
+	OK     bool   `json:"ok"`
+	Detail string `json:"detail,omitempty"`
+}
+
+// ProbeResult - последняя проверка готовности инстанса
+type ProbeResult struct {
+	At     time.Time    `json:"at"`
+	Ready  bool         `json:"ready"`
+	Checks []ProbeCheck `json:"checks"`
+}
+
+func (r ProbeResult) String() string {
+	var parts []string
+	for _, c := range r.Checks {
+		part := c.Name + " ok"
+		if !c.OK {
+			part = c.Name + " FAIL"
+		}
+		if c.Detail != "" {
+			part += " (" + c.Detail + ")"
+		}
+		parts = append(parts, part)
+	}
+	return strings.Join(parts, ", ")
+}
+
+// probeScript печатает по строке на проверку: "display yes|socket|no", "apt <держатели блокировок>",
+// "disk <свободно KB>". X сервер должен ответить на :20 (DISPLAY из start.sh), а не просто оставить сокет;
+// без xdpyinfo и xset остается только сокет. apt занят, пока кто-то держит блокировку dpkg или apt:
+// держатели ищутся по инодам файлов блокировок в /proc/locks, а не по именам процессов
+const probeScript = `if command -v xdpyinfo >/dev/null 2>&1; then
+  DISPLAY=:20 xdpyinfo >/dev/null 2>&1 && echo display yes || echo display no
+elif command -v xset >/dev/null 2>&1; then
+  DISPLAY=:20 xset q >/dev/null 2>&1 && echo display yes || echo display no
+else
+  [ -S /tmp/.X11-unix/X20 ] && echo display socket || echo display no
+fi
+holders=""
+for f in /var/lib/dpkg/lock-frontend /var/lib/dpkg/lock /var/lib/apt/lists/lock; do
+  [ -e "$f" ] || continue
+  holders="$holders $(awk -v ino="$(stat -c %i "$f")" '$2 != "->" { split($6, id, ":"); if (id[3] == ino) print $5 }' /proc/locks)"
+done
+echo apt $(for pid in $holders; do ps -o comm= -p "$pid"; done | sort -u)
+echo disk $(df -Pk . | awk 'NR==2 {print $4}')`
+
+// probeInstance проходит SSH рукопожатие ключом vastai и выполняет проверки checks; вывод проверок
+// идет в лог с префиксом инстанса id. Соединение остается в менеджере, развертывание продолжит через него
+func probeInstance(ctx context.Context, mgr *SSHManager, id int, host string, port int, checks []string, minFreeDiskGB float64) ProbeResult {
+	result := ProbeResult{At: time.Now()}
+	if err := mgr.Connect(host, port); err != nil {
+		result.Checks = append(result.Checks, ProbeCheck{Name: "ssh", Detail: err.Error()})
+		return result
+	}
+	result.Checks = append(result.Checks, ProbeCheck{Name: "ssh", OK: true})
+	if len(checks) == 0 {
+		result.Ready = true
+		return result
+	}
+
+	output, err := mgr.Run(ctx, host, port, fmt.Sprintf("[Instance %d]", id), probeScript, 15*time.Second)
+	if err != nil {
+		result.Checks = append(result.Checks, ProbeCheck{Name: "checks", Detail: err.Error()})
+		return result
+	}
+	values := make(map[string]string)
+	for _, line := range strings.Split(output, "\n") {
+		name, value, _ := strings.Cut(strings.TrimSpace(line), " ")
+		values[name] = strings.TrimSpace(value)
+	}
+
+	result.Ready = true
+	for _, name := range checks {
+		check := evaluateCheck(name, values[name], minFreeDiskGB)
+		result.Ready = result.Ready && check.OK
+		result.Checks = append(result.Checks, check)
+	}
+	return result
+}
+
+func evaluateCheck(name, value string, minFreeDiskGB float64) ProbeCheck {
+	check := ProbeCheck{Name: name}
+	switch name {
+	case CheckDisplay:
+		switch value {
+		case "yes":
+			check.OK = true
+		case "socket":
+			check.OK = true
+			check.Detail = "X socket on :20, no xdpyinfo or xset to query it"
+		default:
+			check.Detail = "X server on :20 does not answer"
+		}
+	case CheckApt:
+		check.OK = value == ""
+		if !check.OK {
+			check.Detail = "dpkg lock held by " + strings.Join(strings.Fields(value), ", ")
+		}
+	case CheckDisk:
+		kb, err := strconv.ParseFloat(value, 64)
+		if err != nil {
+			check.Detail = fmt.Sprintf("could not read free space: %q", value)
+			break
+		}
+		free := kb / (1 << 20)
+		check.OK = free >= minFreeDiskGB
+		check.Detail = fmt.Sprintf("%.1f GB free", free)
+	}
+	return check
+}
@@ -0,0 +1,154 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"time"
+)
+
+// Статусы инстанса, общие для всех провайдеров
+const (
+	StatusCreated = "created"
+	StatusLoading = "loading"
+	StatusRunning = "running"
+	StatusExited  = "exited"
+	StatusError   = "error"
+)
+
+// Instance - арендованная машина; JSON-теги совпадают с ответами vast.ai
+type Instance struct {
+	ID             int                      `json:"id"`
+	Status         string                   `json:"actual_status"`
+	IntendedStatus string                   `json:"intended_status"`
+	StatusMessage  string                   `json:"status_msg"`
+	SSHHost        string                   `json:"ssh_host"`
+	SSHPort        int                      `json:"ssh_port"`
+	PublicIPAddr   string                   `json:"public_ipaddr"`
+	Ports          map[string][]PortBinding `json:"ports"`
+	DiskSpace      float64                  `json:"disk_space"`
+	GPUName        string                   `json:"gpu_name"`
+	NumGPUs        int                      `json:"num_gpus"`
+	DPHTotal       float64                  `json:"dph_total"`
+	ImageRuntype   string                   `json:"image_runtype"`
+	Label          string                   `json:"label"`
+}
+
+// PortBinding - проброс порта контейнера ("22/tcp") на хост, формат как у Docker
+type PortBinding struct {
+	HostIP   string `json:"HostIp"`
+	HostPort string `json:"HostPort"`
+}
+
+// Offer - предложение свободной машины у провайдера
+type Offer struct {
+	ID           int     `json:"id"`
+	GPUName      string  `json:"gpu_name"`
+	NumGPUs      int     `json:"num_gpus"`
+	DiskSpace    float64 `json:"disk_space"`
+	DPHTotal     float64 `json:"dph_total"`
+	CudaMaxGood  float64 `json:"cuda_max_good"`
+	Rentable     bool    `json:"rentable"`
+	MinBid       float64 `json:"min_bid"`
+	Verification string  `json:"verification"`
+	Reliability  float64 `json:"reliability2"`
+	InetDown     float64 `json:"inet_down"`
+	InetUp       float64 `json:"inet_up"`
+	Geolocation  string  `json:"geolocation"`
+	CPUCores     float64 `json:"cpu_cores_effective"`
+	CPURAM       float64 `json:"cpu_ram"` // MB
+}
+
+// OfferQuery - параметры поиска свободных машин; нулевые границы не применяются
+type OfferQuery struct {
+	VerifiedOnly   bool
+	MinDiskGB      float64
+	Limit          int
+	MaxPrice       float64
+	MinReliability float64
+	MinInetDown    float64
+	MinInetUp      float64
+	MinCPUCores    float64
+	MinRAMGB       float64
+	MinCUDA        float64
+}
+
+type SSHEndpoint struct {
+	Host string
+	Port int
+	User string
+}
+
+func (e SSHEndpoint) String() string {
+	return fmt.Sprintf("%s@%s:%d", e.User, e.Host, e.Port)
+}
+
+// Provider - облако (или локальная замена), в котором пул арендует машины
+type Provider interface {
+	Name() string
+	SearchOffers(ctx context.Context, query OfferQuery) ([]Offer, error)
+	CreateInstance(ctx context.Context, offer Offer) (*Instance, error)
+	GetInstance(ctx context.Context, id int) (*Instance, error)
+	DestroyInstance(ctx context.Context, id int) error
+	ListInstances(ctx context.Context) ([]*Instance, error)
+	SSHEndpoint(ctx context.Context, id int) (SSHEndpoint, error)
+}
+
+const (
+	ProviderVast   = "vast"
+	ProviderFake   = "fake"
+	ProviderDocker = "docker"
+	ProviderPodman = "podman"
+)
+
+// ProviderOptions - настройки, общие для провайдеров с HTTP API
+type ProviderOptions struct {
+	APIRate  float64
+	APIBurst int
+}
+
+func defaultProviderOptions() ProviderOptions {
+	// vast.ai отвечает 429 примерно после 4-5 запросов в секунду
+	return ProviderOptions{APIRate: 4, APIBurst: 4}
+}
+
+func newProvider(name string, opts ProviderOptions) (Provider, error) {
+	switch strings.ToLower(name) {
+	case ProviderVast, "vastai", "vast.ai":
+		if opts.APIRate <= 0 {
+			return nil, fmt.Errorf("API rate must be positive")
+		}
+		return NewVastClient(VASTAI_API_KEY, opts.APIRate, opts.APIBurst), nil
+	case ProviderFake:
+		return NewFakeProvider(), nil
+	case ProviderDocker, ProviderPodman:
+		return NewDockerProvider(strings.ToLower(name), dockerImage())
+	default:
+		return nil, fmt.Errorf("unknown provider %q (expected %s, %s, %s or %s)", name,
+			ProviderVast, ProviderFake, ProviderDocker, ProviderPodman)
+	}
+}
+
+// waitForInstance опрашивает провайдера, пока инстанс не запустится и не получит SSH адрес
+func waitForInstance(ctx context.Context, p Provider, instanceID int, maxMinutes int) (*Instance, error) {
+	maxAttempts := maxMinutes * 12 // 12 attempts per minute (every 5 seconds)
+	for i := 0; i < maxAttempts; i++ {
+		instance, err := p.GetInstance(ctx, instanceID)
+		if err != nil {
+			return nil, err
+		}
+
+		if instance.Status == StatusRunning && instance.SSHHost != "" {
+			return instance, nil
+		}
+
+		fmt.Printf("Instance status: %s (attempt %d/%d)\n", instance.Status, i+1, maxAttempts)
+		select {
+		case <-time.After(5 * time.Second):
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		}
+	}
+
+	return nil, fmt.Errorf("instance did not become ready in %d minutes", maxMinutes)
+}
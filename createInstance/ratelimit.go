@@ -0,0 +1,163 @@
+package main
+
+import (
+	"context"
+	"math/rand"
+	"net/http"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// tokenBucket пропускает в среднем rate запросов в секунду с всплесками до burst
+type tokenBucket struct {
+	mu     sync.Mutex
+	rate   float64
+	burst  float64
+	tokens float64
+	last   time.Time
+}
+
+func newTokenBucket(rate float64, burst int) *tokenBucket {
+	if burst < 1 {
+		burst = 1
+	}
+	return &tokenBucket{rate: rate, burst: float64(burst), tokens: float64(burst), last: time.Now()}
+}
+
+// Wait блокирует, пока не освободится токен или не отменится контекст
+func (b *tokenBucket) Wait(ctx context.Context) error {
+	for {
+		b.mu.Lock()
+		now := time.Now()
+		b.tokens += now.Sub(b.last).Seconds() * b.rate
+		if b.tokens > b.burst {
+			b.tokens = b.burst
+		}
+		b.last = now
+		if b.tokens >= 1 {
+			b.tokens--
+			b.mu.Unlock()
+			return nil
+		}
+		wait := time.Duration((1 - b.tokens) / b.rate * float64(time.Second))
+		b.mu.Unlock()
+
+		select {
+		case <-time.After(wait):
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	}
+}
+
+// retryPolicy - повторы при 429 и 5xx с экспоненциальной задержкой и джиттером
+type retryPolicy struct {
+	MaxRetries     int
+	InitialBackoff time.Duration
+	MaxBackoff     time.Duration
+}
+
+func defaultRetryPolicy() retryPolicy {
+	return retryPolicy{MaxRetries: 5, InitialBackoff: time.Second, MaxBackoff: 30 * time.Second}
+}
+
+func (p retryPolicy) backoff(attempt int) time.Duration {
+	d := p.InitialBackoff << uint(attempt)
+	if d <= 0 || d > p.MaxBackoff {
+		d = p.MaxBackoff
+	}
+	// Джиттер ±20%, чтобы параллельные запросы не повторялись синхронно
+	jitter := time.Duration(rand.Int63n(int64(d)/5+1)) - d/10
+	return d + jitter
+}
+
+// retryAfter разбирает заголовок Retry-After: секунды или HTTP-дата
+func retryAfter(resp *http.Response) (time.Duration, bool) {
+	value := strings.TrimSpace(resp.Header.Get("Retry-After"))
+	if value == "" {
+		return 0, false
+	}
+	if seconds, err := strconv.Atoi(value); err == nil && seconds >= 0 {
+		return time.Duration(seconds) * time.Second, true
+	}
+	if at, err := http.ParseTime(value); err == nil {
+		if d := time.Until(at); d > 0 {
+			return d, true
+		}
+		return 0, true
+	}
+	return 0, false
+}
+
+type EndpointMetrics struct {
+	Endpoint    string        `json:"endpoint"`
+	Requests    int           `json:"requests"`
+	Retries     int           `json:"retries"`
+	RateLimited int           `json:"rate_limited"`
+	Errors      int           `json:"errors"`
+	TotalTime   time.Duration `json:"total_time_ns"`
+	MaxTime     time.Duration `json:"max_time_ns"`
+}
+
+// apiMetrics считает запросы по эндпоинтам; ID в пути заменяются на :id
+type apiMetrics struct {
+	mu        sync.Mutex
+	endpoints map[string]*EndpointMetrics
+}
+
+var endpointIDPattern = regexp.MustCompile(`/\d+`)
+
+func newAPIMetrics() *apiMetrics {
+	return &apiMetrics{endpoints: make(map[string]*EndpointMetrics)}
+}
+
+func endpointKey(method, endpoint string) string {
+	if i := strings.IndexByte(endpoint, '?'); i >= 0 {
+		endpoint = endpoint[:i]
+	}
+	return method + " " + endpointIDPattern.ReplaceAllString(endpoint, "/:id")
+}
+
+func (m *apiMetrics) get(key string) *EndpointMetrics {
+	e, ok := m.endpoints[key]
+	if !ok {
+		e = &EndpointMetrics{Endpoint: key}
+		m.endpoints[key] = e
+	}
+	return e
+}
+
+// observe записывает одну попытку запроса; status 0 - сетевая ошибка
+func (m *apiMetrics) observe(key string, status int, elapsed time.Duration, retry bool) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	e := m.get(key)
+	e.Requests++
+	e.TotalTime += elapsed
+	if elapsed > e.MaxTime {
+		e.MaxTime = elapsed
+	}
+	if retry {
+		e.Retries++
+	}
+	if status == http.StatusTooManyRequests {
+		e.RateLimited++
+	} else if status == 0 || status >= 400 {
+		e.Errors++
+	}
+}
+
+func (m *apiMetrics) snapshot() []EndpointMetrics {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	result := make([]EndpointMetrics, 0, len(m.endpoints))
+	for _, e := range m.endpoints {
+		result = append(result, *e)
+	}
+	sort.Slice(result, func(i, j int) bool { return result[i].Endpoint < result[j].Endpoint })
+	return result
+}
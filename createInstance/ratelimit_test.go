@@ -0,0 +1,126 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestRetryPolicyBackoff(t *testing.T) {
+	p := retryPolicy{MaxRetries: 5, InitialBackoff: time.Second, MaxBackoff: 8 * time.Second}
+	for attempt, base := range []time.Duration{1, 2, 4, 8, 8} {
+		base *= time.Second
+		for i := 0; i < 100; i++ {
+			// Джиттер ±10% от удвоенной задержки, потолок MaxBackoff
+			if d := p.backoff(attempt); d < base*9/10 || d > base*11/10 {
+				t.Fatalf("backoff(%d) = %v, want %v ±10%%", attempt, d, base)
+			}
+		}
+	}
+	// Сдвиг за пределы int64 не дает отрицательной задержки
+	if d := p.backoff(70); d < p.MaxBackoff*9/10 || d > p.MaxBackoff*11/10 {
+		t.Fatalf("backoff(70) = %v, want about %v", d, p.MaxBackoff)
+	}
+}
+
+func TestRetryAfter(t *testing.T) {
+	cases := []struct {
+		value    string
+		min, max time.Duration
+		ok       bool
+	}{
+		{"", 0, 0, false},
+		{"3", 3 * time.Second, 3 * time.Second, true},
+		{" 0 ", 0, 0, true},
+		{"-1", 0, 0, false},
+		{"soon", 0, 0, false},
+		{time.Now().Add(10 * time.Second).UTC().Format(http.TimeFormat), 8 * time.Second, 10 * time.Second, true},
+		// Дата в прошлом - повторять сразу
+		{time.Now().Add(-time.Minute).UTC().Format(http.TimeFormat), 0, 0, true},
+	}
+	for _, c := range cases {
+		resp := &http.Response{Header: http.Header{}}
+		resp.Header.Set("Retry-After", c.value)
+		d, ok := retryAfter(resp)
+		if ok != c.ok || d < c.min || d > c.max {
+			t.Errorf("Retry-After %q = %v, %v; want %v-%v, %v", c.value, d, ok, c.min, c.max, c.ok)
+		}
+	}
+}
+
+func TestTokenBucketWait(t *testing.T) {
+	b := newTokenBucket(20, 2)
+	ctx := context.Background()
+	started := time.Now()
+	for i := 0; i < 3; i++ {
+		if err := b.Wait(ctx); err != nil {
+			t.Fatal(err)
+		}
+	}
+	// Два токена из всплеска сразу, третий - через 1/20 с
+	if elapsed := time.Since(started); elapsed < 40*time.Millisecond {
+		t.Fatalf("three tokens took %v, want at least 50ms", elapsed)
+	}
+
+	cancelled, cancel := context.WithCancel(ctx)
+	cancel()
+	if err := newTokenBucket(0.001, 1).Wait(cancelled); err != nil {
+		t.Fatalf("burst token: %v", err)
+	}
+	if err := b.Wait(cancelled); err != context.Canceled {
+		t.Fatalf("Wait on a cancelled context = %v, want %v", err, context.Canceled)
+	}
+}
+
+func TestVastClientRetriesRateLimitsAndServerErrors(t *testing.T) {
+	cases := []struct {
+		name     string
+		method   string
+		statuses []int // ответы по порядку, последний повторяется
+		requests int
+		ok       bool
+	}{
+		{"429 then success", http.MethodGet, []int{429, 200}, 2, true},
+		{"429 on POST is retried", http.MethodPost, []int{429, 200}, 2, true},
+		{"5xx on GET is retried", http.MethodGet, []int{503, 502, 200}, 3, true},
+		{"5xx on POST is not retried", http.MethodPost, []int{503, 200}, 1, false},
+		{"4xx is not retried", http.MethodGet, []int{404, 200}, 1, false},
+		{"retries run out", http.MethodDelete, []int{500}, 4, false},
+	}
+	for _, c := range cases {
+		var mu sync.Mutex
+		requests := 0
+		srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			mu.Lock()
+			status := c.statuses[min(requests, len(c.statuses)-1)]
+			requests++
+			mu.Unlock()
+			if status == http.StatusTooManyRequests {
+				w.Header().Set("Retry-After", "0")
+			}
+			w.WriteHeader(status)
+			w.Write([]byte(`{}`))
+		}))
+		v := &VastClient{
+			baseURL: srv.URL,
+			client:  srv.Client(),
+			limiter: newTokenBucket(1000, 10),
+			retry:   retryPolicy{MaxRetries: 3, InitialBackoff: time.Millisecond, MaxBackoff: 5 * time.Millisecond},
+			metrics: newAPIMetrics(),
+		}
+		_, err := v.makeRequest(context.Background(), c.method, "/instances/42/", nil)
+		srv.Close()
+
+		if (err == nil) != c.ok || requests != c.requests {
+			t.Errorf("%s: %d requests, err %v; want %d requests, ok %v", c.name, requests, err, c.requests, c.ok)
+			continue
+		}
+		m := v.metrics.snapshot()
+		if len(m) != 1 || m[0].Endpoint != c.method+" /instances/:id/" || m[0].Requests != c.requests || m[0].Retries != c.requests-1 {
+			t.Errorf("%s: metrics %+v", c.name, m)
+		}
+	}
+}
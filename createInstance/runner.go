@@ -0,0 +1,182 @@
+package main
+
+import (
+	"crypto/sha256"
+	"debug/elf"
+	"encoding/hex"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"time"
+)
+
+// RunnerFile - файл, который заливается на инстанс перед запуском теста
+type RunnerFile struct {
+	Local  string      `json:"local"`
+	Remote string      `json:"remote"`
+	Size   int64       `json:"size"`
+	SHA256 string      `json:"sha256"`
+	Mode   os.FileMode `json:"-"`
+}
+
+// RunnerBundle - то, что запускается на инстансах: собранный локально раннер, start.sh и конфиг сессии
+type RunnerBundle struct {
+	Binary      string
+	StartScript string
+	Config      string
+	Build       bool
+	Heartbeat   *HeartbeatConfig // nil или без URL - раннер не шлет пульс
+
+	Files []RunnerFile
+}
+
+// Имена файлов в домашнем каталоге инстанса; start.sh ищет ./highLoadTest
+const (
+	remoteRunnerBinary = "highLoadTest"
+	remoteStartScript  = "start.sh"
+	remoteRunnerConfig = "session.yaml"
+)
+
+// runnerUploadTimeout - на заливку одного файла; бинарник весит десятки мегабайт
+const runnerUploadTimeout = 10 * time.Minute
+
+func addRunnerFlags(fs *flag.FlagSet) *RunnerBundle {
+	b := &RunnerBundle{}
+	fs.StringVar(&b.Binary, "runner", filepath.Join("..", "highLoadTest"), "linux/amd64 runner binary uploaded to the instances")
+	fs.StringVar(&b.StartScript, "start-script", filepath.Join("..", "start.sh"), "bootstrap script uploaded to the instances")
+	fs.StringVar(&b.Config, "runner-config", "", "session config uploaded to the instances and passed to the runner; its storage section is required")
+	fs.BoolVar(&b.Build, "build-runner", false, "build the runner like `make build-linux` before deploying")
+	return b
+}
+
+// Prepare собирает раннер (с -build-runner), проверяет файлы и считает их контрольные суммы.
+// Вызывается до создания инстансов, чтобы не платить за пул, на который нечего залить
+func (b *RunnerBundle) Prepare() error {
+	if b.Build {
+		if err := buildRunner(b.Binary); err != nil {
+			return err
+		}
+	}
+	if err := checkRunnerBinary(b.Binary); err != nil {
+		return err
+	}
+	if err := checkRunnerStorage(b.Config); err != nil {
+		return err
+	}
+
+	b.Files = nil
+	add := func(local, remote string, mode os.FileMode) error {
+		sum, size, err := fileSHA256(local)
+		if err != nil {
+			return err
+		}
+		b.Files = append(b.Files, RunnerFile{Local: local, Remote: remote, Size: size, SHA256: sum, Mode: mode})
+		return nil
+	}
+	if err := add(b.Binary, remoteRunnerBinary, 0755); err != nil {
+		return err
+	}
+	if err := add(b.StartScript, remoteStartScript, 0755); err != nil {
+		return err
+	}
+	if b.Config != "" {
+		// В конфиге могут быть пароли хранилища
+		if err := add(b.Config, remoteRunnerConfig, 0600); err != nil {
+			return err
+		}
+	}
+
+	fmt.Printf("Runner bundle:\n")
+	for _, f := range b.Files {
+		fmt.Printf("  %s -> ~/%s (%.1f MB, sha256 %s)\n", f.Local, f.Remote, float64(f.Size)/(1<<20), f.SHA256[:12])
+	}
+	return nil
+}
+
+// startCommand запускает start.sh в фоне; stdin отвязан, иначе сессия ждет фоновый процесс
+func (b *RunnerBundle) startCommand(instanceID int, runID string) (string, error) {
+	env, err := b.Heartbeat.env(runID)
+	if err != nil {
+		return "", err
+	}
+	if b.Config != "" {
+		env += "HLT_CONFIG=./" + remoteRunnerConfig + " "
+	}
+	return fmt.Sprintf(`%snohup ./%s %d %s > test_output.log 2>&1 < /dev/null & echo "Test started with PID: $!"`,
+		env, remoteStartScript, instanceID, runID), nil
+}
+
+// buildRunner повторяет цель build-linux из Makefile: статический linux/amd64 бинарник из каталога,
+// в котором он лежит
+func buildRunner(binary string) error {
+	dir := filepath.Dir(binary)
+	fmt.Printf("Building runner in %s...\n", dir)
+	cmd := exec.Command("go", "build", "-a", "-ldflags", `-extldflags "-static"`, "-o", filepath.Base(binary), ".")
+	cmd.Dir = dir
+	cmd.Env = append(os.Environ(), "CGO_ENABLED=0", "GOOS=linux", "GOARCH=amd64")
+	cmd.Stdout = os.Stdout
+	cmd.Stderr = os.Stderr
+	if err := cmd.Run(); err != nil {
+		return fmt.Errorf("failed to build runner: %v", err)
+	}
+	return nil
+}
+
+// checkRunnerBinary не дает залить бинарник, собранный под другую платформу
+func checkRunnerBinary(path string) error {
+	f, err := elf.Open(path)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return fmt.Errorf("runner binary %s not found; build it with `make build-linux` or pass -build-runner", path)
+		}
+		return fmt.Errorf("runner binary %s is not a linux executable: %v", path, err)
+	}
+	defer f.Close()
+	if f.Machine != elf.EM_X86_64 {
+		return fmt.Errorf("runner binary %s is built for %v, instances need x86-64", path, f.Machine)
+	}
+	return nil
+}
+
+// checkRunnerStorage требует хранилище артефактов в конфиге раннера: у start.sh нет хранилища
+// по умолчанию, а без него скриншоты пропадут вместе с инстансом
+func checkRunnerStorage(configPath string) error {
+	if configPath == "" {
+		return fmt.Errorf("-runner-config is required: instances have no default artifact storage")
+	}
+	cfg, err := loadStorageConfig(configPath)
+	if err != nil {
+		return err
+	}
+	if cfg.Backend == "" {
+		return fmt.Errorf("%s has no storage section; artifacts would be lost with the instance", configPath)
+	}
+	if err := cfg.Validate(); err != nil {
+		return fmt.Errorf("%s: %v", configPath, err)
+	}
+	return nil
+}
+
+func fileSHA256(path string) (string, int64, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return "", 0, err
+	}
+	defer f.Close()
+	h := sha256.New()
+	size, err := io.Copy(h, f)
+	if err != nil {
+		return "", 0, fmt.Errorf("failed to read %s: %v", path, err)
+	}
+	return hex.EncodeToString(h.Sum(nil)), size, nil
+}
+
+// SetRunner записывает в манифест, что именно залито на инстансы
+func (m *RunManifest) SetRunner(files []RunnerFile) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.Runner = append([]RunnerFile(nil), files...)
+}
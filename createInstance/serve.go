@@ -0,0 +1,891 @@
+package main
+
+import (
+	"context"
+	"crypto/subtle"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"log"
+	"net"
+	"net/http"
+	"net/url"
+	"os"
+	"os/exec"
+	"os/signal"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+
+	"gopkg.in/yaml.v3"
+
+	"highloadtest/storage"
+)
+
+// Состояния запуска, которым управляет serve
+const (
+	RunStateRunning    = "running"
+	RunStateFinished   = "finished"
+	RunStateFailed     = "failed"
+	RunStateDestroying = "destroying"
+	RunStateDestroyed  = "destroyed"
+)
+
+// Типы событий в потоке /events
+const (
+	EventRun    = "run"    // состояние запуска в serve
+	EventStage  = "stage"  // переход инстанса на этап жизненного цикла
+	EventStatus = "status" // смена статуса инстанса у провайдера
+)
+
+// serveWatchInterval - как часто serve перечитывает манифесты запусков ради событий
+const serveWatchInterval = 2 * time.Second
+
+// RunSpec - тело POST /runs. Поля соответствуют флагам run, остальные флаги (verified, country,
+// max-run-cost, ready-checks...) передаются в Flags
+type RunSpec struct {
+	Count         int               `json:"count"`
+	MaxPrice      float64           `json:"max_price,omitempty"`
+	GPUs          []string          `json:"gpus,omitempty"`
+	StartTests    bool              `json:"start_tests,omitempty"`
+	AutoDestroy   bool              `json:"auto_destroy,omitempty"`
+	WaitMinutes   int               `json:"wait_minutes,omitempty"`
+	SessionConfig string            `json:"session_config,omitempty"` // YAML конфиг сессии раннера
+	Scenario      []interface{}     `json:"scenario,omitempty"`       // шаги сценария, заменяют scenario из SessionConfig
+	Flags         map[string]string `json:"flags,omitempty"`
+}
+
+// specFlags - флаги, которые задаются полями RunSpec или самим serve
+var specFlags = map[string]string{
+	"count":         "count",
+	"max-price":     "max_price",
+	"gpu":           "gpus",
+	"start-tests":   "start_tests",
+	"auto-destroy":  "auto_destroy",
+	"wait":          "wait_minutes",
+	"runner-config": "session_config",
+	"run":           "",
+	"resume":        "",
+	"dashboard":     "",
+	"output":        "",
+	// пульс раннеров принимает сам serve
+	"heartbeat-url":    "",
+	"heartbeat-listen": "",
+}
+
+func (s *RunSpec) Validate() error {
+	if s.Count < 1 {
+		return fmt.Errorf("count must be at least 1")
+	}
+	if s.MaxPrice < 0 || s.WaitMinutes < 0 {
+		return fmt.Errorf("max_price and wait_minutes must not be negative")
+	}
+	if s.AutoDestroy && !s.StartTests {
+		return fmt.Errorf("auto_destroy requires start_tests")
+	}
+	if (s.SessionConfig != "" || len(s.Scenario) > 0) && !s.StartTests {
+		return fmt.Errorf("session_config and scenario require start_tests")
+	}
+	for name := range s.Flags {
+		if field, ok := specFlags[strings.TrimLeft(name, "-")]; ok {
+			if field == "" {
+				return fmt.Errorf("flag %q is set by serve", name)
+			}
+			return fmt.Errorf("flag %q is set by the %s field", name, field)
+		}
+	}
+	config, err := s.sessionConfig()
+	if err != nil {
+		return err
+	}
+	if s.StartTests {
+		// Как и -runner-config в run: без хранилища артефакты останутся на инстансе
+		var cfg struct {
+			Storage storage.Config `yaml:"storage"`
+		}
+		if err := yaml.Unmarshal(config, &cfg); err != nil {
+			return fmt.Errorf("session_config: %v", err)
+		}
+		if cfg.Storage.Backend == "" {
+			return fmt.Errorf("start_tests requires a storage section in session_config")
+		}
+		if err := cfg.Storage.Validate(); err != nil {
+			return fmt.Errorf("session_config: %v", err)
+		}
+	}
+	return nil
+}
+
+// sessionConfig собирает конфиг сессии для -runner-config; nil - конфиг не нужен
+func (s *RunSpec) sessionConfig() ([]byte, error) {
+	if s.SessionConfig == "" && len(s.Scenario) == 0 {
+		return nil, nil
+	}
+	cfg := make(map[string]interface{})
+	if err := yaml.Unmarshal([]byte(s.SessionConfig), &cfg); err != nil {
+		return nil, fmt.Errorf("session_config: %v", err)
+	}
+	if len(s.Scenario) > 0 {
+		cfg["scenario"] = s.Scenario
+	}
+	return yaml.Marshal(cfg)
+}
+
+// args - флаги подкоманды run для спецификации
+func (s *RunSpec) args(configPath string) []string {
+	args := []string{fmt.Sprintf("-count=%d", s.Count)}
+	if s.MaxPrice > 0 {
+		args = append(args, "-max-price="+strconv.FormatFloat(s.MaxPrice, 'f', -1, 64))
+	}
+	if len(s.GPUs) > 0 {
+		args = append(args, "-gpu="+strings.Join(s.GPUs, ","))
+	}
+	if s.StartTests {
+		args = append(args, "-start-tests")
+	}
+	if s.AutoDestroy {
+		args = append(args, "-auto-destroy")
+	}
+	if s.WaitMinutes > 0 {
+		args = append(args, fmt.Sprintf("-wait=%d", s.WaitMinutes))
+	}
+	if configPath != "" {
+		args = append(args, "-runner-config="+configPath)
+	}
+	var names []string
+	for name := range s.Flags {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		args = append(args, "-"+strings.TrimLeft(name, "-")+"="+s.Flags[name])
+	}
+	return args
+}
+
+// ServedRun - запуск, которым управляет serve. Хранится в runs/<run>/serve.json, чтобы после
+// перезапуска демона продолжить за ним следить
+type ServedRun struct {
+	RunID      string     `json:"run_id"`
+	Spec       RunSpec    `json:"spec"`
+	Args       []string   `json:"args"`
+	State      string     `json:"state"`
+	PID        int        `json:"pid,omitempty"`
+	StartedAt  time.Time  `json:"started_at"`
+	FinishedAt *time.Time `json:"finished_at,omitempty"`
+	Error      string     `json:"error,omitempty"`
+	Resumes    int        `json:"resumes,omitempty"`
+}
+
+func servedRunPath(runID string) string {
+	return filepath.Join("runs", runID, "serve.json")
+}
+
+func outputLogPath(runID string) string {
+	return filepath.Join("runs", runID, "output.log")
+}
+
+// RunStatus - ответ GET /runs и GET /runs/{id}; манифест только в ответе по одному запуску
+type RunStatus struct {
+	ServedRun
+	Instances int          `json:"instances"`
+	Live      int          `json:"live"`
+	CostUSD   float64      `json:"cost_usd"`
+	Manifest  *RunManifest `json:"manifest,omitempty"`
+}
+
+// LifecycleEvent - событие потока /events
+type LifecycleEvent struct {
+	Type       string    `json:"type"`
+	RunID      string    `json:"run_id"`
+	InstanceID int       `json:"instance_id,omitempty"`
+	At         time.Time `json:"at"`
+	State      string    `json:"state,omitempty"`
+	Status     string    `json:"status,omitempty"`
+	Stage      string    `json:"stage,omitempty"`
+	Message    string    `json:"message,omitempty"`
+}
+
+// eventHub раздает события подписчикам SSE; медленный подписчик теряет события, а не тормозит serve
+type eventHub struct {
+	mu     sync.Mutex
+	subs   map[chan LifecycleEvent]string // канал -> ID запуска ("" - все запуски)
+	closed bool
+}
+
+// subscribe возвращает канал событий; его закрытие значит, что serve останавливается
+func (h *eventHub) subscribe(runID string) chan LifecycleEvent {
+	ch := make(chan LifecycleEvent, 64)
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	if h.closed {
+		close(ch)
+		return ch
+	}
+	h.subs[ch] = runID
+	return ch
+}
+
+// close закрывает каналы подписчиков, чтобы потоки SSE завершились до srv.Shutdown:
+// иначе Shutdown ждет их до своего таймаута
+func (h *eventHub) close() {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	h.closed = true
+	for ch := range h.subs {
+		close(ch)
+		delete(h.subs, ch)
+	}
+}
+
+func (h *eventHub) unsubscribe(ch chan LifecycleEvent) {
+	h.mu.Lock()
+	delete(h.subs, ch)
+	h.mu.Unlock()
+}
+
+func (h *eventHub) publish(e LifecycleEvent) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	for ch, runID := range h.subs {
+		if runID != "" && runID != e.RunID {
+			continue
+		}
+		select {
+		case ch <- e:
+		default:
+		}
+	}
+}
+
+// warnUnreachableHeartbeats предупреждает, когда пульсы с инстансов не дойдут до API:
+// -heartbeat-url на loopback или API слушает только loopback. Прокси или туннель
+// к -listen это допускает, поэтому это предупреждение, а не ошибка
+func warnUnreachableHeartbeats(heartbeatURL, listen string) {
+	if u, err := url.Parse(heartbeatURL); err == nil && isLoopbackHost(u.Hostname()) {
+		log.Printf("Warning: -heartbeat-url %s is a loopback address, instances cannot reach it", heartbeatURL)
+	}
+	if host, _, err := net.SplitHostPort(listen); err == nil && isLoopbackHost(host) {
+		log.Printf("Warning: the API listens on %s only; -heartbeat-url %s must lead to it through a proxy or tunnel, or set -listen to an address the instances reach", listen, heartbeatURL)
+	}
+}
+
+func isLoopbackHost(host string) bool {
+	if host == "localhost" {
+		return true
+	}
+	ip := net.ParseIP(host)
+	return ip != nil && ip.IsLoopback()
+}
+
+// server - демон serve: запускает run отдельными процессами createInstance и следит за ними
+type server struct {
+	common *commonFlags
+	exe    string
+	token  string
+	events *eventHub
+
+	heartbeatURL string // адрес POST /heartbeats этого API, как его видят инстансы
+
+	mu       sync.Mutex
+	runs     map[string]*ServedRun
+	procs    map[string]*os.Process // живые процессы запусков, в том числе оставшиеся от прошлого serve
+	stopping bool                   // serve останавливается: прерванные запуски продолжатся после перезапуска
+}
+
+// runServe - подкоманда serve: HTTP API для запуска пулов из CI и чатов
+func runServe(args []string) {
+	fs := flag.NewFlagSet("serve", flag.ExitOnError)
+	listen := fs.String("listen", "127.0.0.1:8080", "address of the HTTP API")
+	token := fs.String("token", os.Getenv("HLT_API_TOKEN"), "bearer token required by the API (default: $HLT_API_TOKEN; empty: no auth)")
+	heartbeatURL := fs.String("heartbeat-url", "", "URL of POST /heartbeats of this API as the instances reach it, passed to the runs; it must lead to -listen, which is loopback by default (default: none, heartbeats off)")
+	common := addCommonFlags(fs, ProviderVast)
+	common.parse(args)
+	if *heartbeatURL != "" {
+		if err := checkHeartbeatURL(*heartbeatURL); err != nil {
+			log.Fatalf("serve: %v", err)
+		}
+		warnUnreachableHeartbeats(*heartbeatURL, *listen)
+	}
+
+	exe, err := os.Executable()
+	if err != nil {
+		log.Fatalf("serve: %v", err)
+	}
+	s := &server{
+		common: common,
+		exe:    exe,
+		token:  *token,
+		events: &eventHub{subs: make(map[chan LifecycleEvent]string)},
+		runs:   make(map[string]*ServedRun),
+		procs:  make(map[string]*os.Process),
+
+		heartbeatURL: *heartbeatURL,
+	}
+	if s.token == "" {
+		log.Printf("Warning: the API has no token; anyone who can reach %s can rent instances", *listen)
+	}
+	s.recover()
+	go s.watch()
+
+	srv := &http.Server{Addr: *listen, Handler: s.routes(), ReadHeaderTimeout: 10 * time.Second}
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, os.Interrupt, syscall.SIGTERM)
+	go func() {
+		<-signals
+		s.mu.Lock()
+		s.stopping = true
+		s.mu.Unlock()
+		fmt.Printf("Shutting down; running runs continue after restart\n")
+		s.events.close()
+		ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		defer cancel()
+		srv.Shutdown(ctx)
+	}()
+
+	fmt.Printf("Serving API on http://%s\n", *listen)
+	if err := srv.ListenAndServe(); err != nil && !errors.Is(err, http.ErrServerClosed) {
+		log.Fatalf("serve: %v", err)
+	}
+}
+
+func (s *server) routes() http.Handler {
+	mux := http.NewServeMux()
+	mux.HandleFunc("POST /runs", s.handleCreate)
+	mux.HandleFunc("GET /runs", s.handleList)
+	mux.HandleFunc("GET /runs/{id}", s.handleGet)
+	mux.HandleFunc("GET /runs/{id}/instances", s.handleInstances)
+	mux.HandleFunc("GET /runs/{id}/log", s.handleLog)
+	mux.HandleFunc("GET /runs/{id}/events", s.handleEvents)
+	mux.HandleFunc("DELETE /runs/{id}", s.handleDelete)
+	mux.HandleFunc("GET /events", s.handleEvents)
+
+	// Пульс приходит с арендованных машин: он подписан секретом своего запуска,
+	// токен API туда не попадает и пульс не проверяет
+	root := http.NewServeMux()
+	root.HandleFunc("POST /heartbeats", handleHeartbeat)
+	root.Handle("/", s.authorize(mux))
+	return root
+}
+
+func (s *server) authorize(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if s.token != "" {
+			got := strings.TrimPrefix(r.Header.Get("Authorization"), "Bearer ")
+			if subtle.ConstantTimeCompare([]byte(got), []byte(s.token)) != 1 {
+				writeError(w, http.StatusUnauthorized, "missing or invalid bearer token")
+				return
+			}
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+func writeJSON(w http.ResponseWriter, code int, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(code)
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	enc.Encode(v)
+}
+
+func writeError(w http.ResponseWriter, code int, format string, args ...interface{}) {
+	writeJSON(w, code, map[string]string{"error": fmt.Sprintf(format, args...)})
+}
+
+func (s *server) handleCreate(w http.ResponseWriter, r *http.Request) {
+	var spec RunSpec
+	dec := json.NewDecoder(http.MaxBytesReader(w, r.Body, 1<<20))
+	dec.DisallowUnknownFields()
+	if err := dec.Decode(&spec); err != nil {
+		writeError(w, http.StatusBadRequest, "invalid run spec: %v", err)
+		return
+	}
+	if err := spec.Validate(); err != nil {
+		writeError(w, http.StatusBadRequest, "invalid run spec: %v", err)
+		return
+	}
+
+	s.mu.Lock()
+	runID := newRunID()
+	for s.runs[runID] != nil || checkNewRunID(runID) != nil {
+		runID = newRunID()
+	}
+	run := &ServedRun{RunID: runID, Spec: spec, StartedAt: time.Now()}
+	s.runs[runID] = run
+	s.mu.Unlock()
+
+	configPath := ""
+	if config, _ := spec.sessionConfig(); config != nil {
+		configPath = filepath.Join("runs", runID, remoteRunnerConfig)
+		if err := writeSessionConfig(configPath, config); err != nil {
+			s.fail(runID, err.Error())
+			writeError(w, http.StatusInternalServerError, "%v", err)
+			return
+		}
+	}
+
+	args := append([]string{"run", "-run=" + runID, "-dashboard=off"}, s.commonArgs()...)
+	if s.heartbeatURL != "" && spec.StartTests {
+		args = append(args, "-heartbeat-url="+s.heartbeatURL)
+	}
+	args = append(args, spec.args(configPath)...)
+	if err := s.start(runID, args); err != nil {
+		writeError(w, http.StatusInternalServerError, "failed to start run: %v", err)
+		return
+	}
+	writeJSON(w, http.StatusCreated, s.status(runID, false))
+}
+
+func (s *server) handleList(w http.ResponseWriter, r *http.Request) {
+	s.mu.Lock()
+	var ids []string
+	for id := range s.runs {
+		ids = append(ids, id)
+	}
+	s.mu.Unlock()
+	sort.Strings(ids)
+
+	result := []RunStatus{}
+	for _, id := range ids {
+		result = append(result, s.status(id, false))
+	}
+	writeJSON(w, http.StatusOK, result)
+}
+
+func (s *server) handleGet(w http.ResponseWriter, r *http.Request) {
+	id, ok := s.lookup(w, r)
+	if !ok {
+		return
+	}
+	writeJSON(w, http.StatusOK, s.status(id, true))
+}
+
+func (s *server) handleInstances(w http.ResponseWriter, r *http.Request) {
+	id, ok := s.lookup(w, r)
+	if !ok {
+		return
+	}
+	instances := []ManifestInstance{}
+	if m, err := loadRunManifest(filepath.Join("runs", id, "run_manifest.json"), nil); err == nil {
+		instances, _ = m.snapshot()
+	}
+	writeJSON(w, http.StatusOK, instances)
+}
+
+func (s *server) handleLog(w http.ResponseWriter, r *http.Request) {
+	id, ok := s.lookup(w, r)
+	if !ok {
+		return
+	}
+	w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+	http.ServeFile(w, r, outputLogPath(id))
+}
+
+func (s *server) handleDelete(w http.ResponseWriter, r *http.Request) {
+	id, ok := s.lookup(w, r)
+	if !ok {
+		return
+	}
+	s.mu.Lock()
+	state := s.runs[id].State
+	if state != RunStateDestroying && state != RunStateDestroyed {
+		s.setState(id, RunStateDestroying, "")
+		go s.destroy(id)
+	}
+	s.mu.Unlock()
+	writeJSON(w, http.StatusAccepted, s.status(id, false))
+}
+
+// handleEvents отдает события жизненного цикла как server-sent events: все запуски на /events
+// (или ?run=<id>), один запуск на /runs/{id}/events
+func (s *server) handleEvents(w http.ResponseWriter, r *http.Request) {
+	runID := r.URL.Query().Get("run")
+	if r.PathValue("id") != "" {
+		var ok bool
+		if runID, ok = s.lookup(w, r); !ok {
+			return
+		}
+	}
+	flusher, ok := w.(http.Flusher)
+	if !ok {
+		writeError(w, http.StatusInternalServerError, "streaming is not supported")
+		return
+	}
+	ch := s.events.subscribe(runID)
+	defer s.events.unsubscribe(ch)
+
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.WriteHeader(http.StatusOK)
+	fmt.Fprintf(w, ": connected\n\n")
+	flusher.Flush()
+
+	// Комментарий раз в 15 секунд не дает прокси закрыть простаивающее соединение
+	keepalive := time.NewTicker(15 * time.Second)
+	defer keepalive.Stop()
+	for {
+		select {
+		case <-r.Context().Done():
+			return
+		case e, ok := <-ch:
+			if !ok {
+				return
+			}
+			data, _ := json.Marshal(e)
+			fmt.Fprintf(w, "event: %s\ndata: %s\n\n", e.Type, data)
+		case <-keepalive.C:
+			fmt.Fprintf(w, ": ping\n\n")
+		}
+		flusher.Flush()
+	}
+}
+
+func (s *server) lookup(w http.ResponseWriter, r *http.Request) (string, bool) {
+	id := r.PathValue("id")
+	s.mu.Lock()
+	_, ok := s.runs[id]
+	s.mu.Unlock()
+	if !ok {
+		writeError(w, http.StatusNotFound, "run %s not found", id)
+	}
+	return id, ok
+}
+
+// status собирает ответ по запуску: состояние в serve и итоги из манифеста
+func (s *server) status(runID string, withManifest bool) RunStatus {
+	s.mu.Lock()
+	st := RunStatus{ServedRun: *s.runs[runID]}
+	s.mu.Unlock()
+	m, err := loadRunManifest(filepath.Join("runs", runID, "run_manifest.json"), nil)
+	if err != nil {
+		return st
+	}
+	st.Instances = len(m.Instances)
+	st.Live = len(m.Active())
+	st.CostUSD, _ = m.Cost()
+	if withManifest {
+		st.Manifest = m
+	}
+	return st
+}
+
+// writeSessionConfig сохраняет конфиг сессии запуска; в нем могут быть пароли хранилища
+func writeSessionConfig(path string, config []byte) error {
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return fmt.Errorf("failed to write session config: %v", err)
+	}
+	if err := os.WriteFile(path, config, 0600); err != nil {
+		return fmt.Errorf("failed to write session config: %v", err)
+	}
+	return nil
+}
+
+// commonArgs передает дочерним процессам общие флаги serve
+func (s *server) commonArgs() []string {
+	args := []string{
+		"-provider=" + s.common.provider,
+		"-api-rate=" + strconv.FormatFloat(s.common.apiRate, 'f', -1, 64),
+		"-api-burst=" + strconv.Itoa(s.common.apiBurst),
+	}
+	if s.common.storageConfig != "" {
+		args = append(args, "-storage-config="+s.common.storageConfig)
+	}
+	return args
+}
+
+// start запускает createInstance с args для запуска runID; вывод дописывается в runs/<run>/output.log
+func (s *server) start(runID string, args []string) error {
+	logPath := outputLogPath(runID)
+	if err := os.MkdirAll(filepath.Dir(logPath), 0755); err != nil {
+		s.fail(runID, err.Error())
+		return err
+	}
+	logFile, err := os.OpenFile(logPath, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0644)
+	if err != nil {
+		s.fail(runID, err.Error())
+		return err
+	}
+	fmt.Fprintf(logFile, "[%s] serve: createInstance %s\n", time.Now().Format("2006-01-02 15:04:05"), strings.Join(args, " "))
+	cmd := exec.Command(s.exe, args...)
+	cmd.Stdout = logFile
+	cmd.Stderr = logFile
+	if err := cmd.Start(); err != nil {
+		logFile.Close()
+		s.fail(runID, err.Error())
+		return err
+	}
+
+	s.mu.Lock()
+	run := s.runs[runID]
+	run.Args = args
+	run.PID = cmd.Process.Pid
+	run.FinishedAt = nil
+	s.procs[runID] = cmd.Process
+	s.setState(runID, RunStateRunning, "")
+	s.mu.Unlock()
+	fmt.Printf("[%s] Run %s started (pid %d)\n", time.Now().Format("15:04:05"), runID, cmd.Process.Pid)
+
+	go func() {
+		err := cmd.Wait()
+		logFile.Close()
+		if cmd.ProcessState != nil && cmd.ProcessState.ExitCode() == -1 {
+			// Ctrl-C приходит всей группе процессов: даем serve отметить остановку,
+			// иначе прерванный запуск посчитается упавшим и не продолжится
+			time.Sleep(time.Second)
+		}
+		s.exited(runID, err)
+	}()
+	return nil
+}
+
+// adopt следит за процессом, оставшимся от прошлого serve; код выхода чужого процесса не узнать
+func (s *server) adopt(runID string, proc *os.Process) {
+	s.mu.Lock()
+	s.procs[runID] = proc
+	s.mu.Unlock()
+	fmt.Printf("[%s] Run %s is still running (pid %d), tracking it\n", time.Now().Format("15:04:05"), runID, proc.Pid)
+	go func() {
+		for processAlive(proc) {
+			time.Sleep(5 * time.Second)
+		}
+		s.exited(runID, nil)
+	}()
+}
+
+// exited фиксирует завершение процесса запуска. Пока serve останавливается, состояние не меняется:
+// прерванный запуск продолжится с --resume после перезапуска
+func (s *server) exited(runID string, err error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	delete(s.procs, runID)
+	if s.stopping || s.runs[runID].State != RunStateRunning {
+		return
+	}
+	if err != nil {
+		msg := err.Error()
+		if last := lastLogLine(outputLogPath(runID)); last != "" {
+			msg += ": " + last
+		}
+		s.setState(runID, RunStateFailed, msg)
+	} else {
+		s.setState(runID, RunStateFinished, "")
+	}
+	fmt.Printf("[%s] Run %s %s\n", time.Now().Format("15:04:05"), runID, s.runs[runID].State)
+}
+
+// destroy останавливает процесс запуска и удаляет его инстансы подкомандой destroy
+func (s *server) destroy(runID string) {
+	s.mu.Lock()
+	proc := s.procs[runID]
+	s.mu.Unlock()
+	if proc != nil {
+		proc.Signal(os.Interrupt)
+		for deadline := time.Now().Add(30 * time.Second); ; time.Sleep(500 * time.Millisecond) {
+			s.mu.Lock()
+			_, alive := s.procs[runID]
+			s.mu.Unlock()
+			if !alive {
+				break
+			}
+			if time.Now().After(deadline) {
+				proc.Kill()
+				deadline = time.Now().Add(30 * time.Second)
+			}
+		}
+	}
+
+	var destroyErr error
+	if _, err := os.Stat(filepath.Join("runs", runID, "run_manifest.json")); err == nil {
+		args := []string{"destroy", "-run=" + runID}
+		args = append(args, s.commonArgs()[1:]...) // провайдер берется из манифеста
+		logFile, err := os.OpenFile(outputLogPath(runID), os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0644)
+		if err != nil {
+			destroyErr = err
+		} else {
+			fmt.Fprintf(logFile, "[%s] serve: createInstance %s\n", time.Now().Format("2006-01-02 15:04:05"), strings.Join(args, " "))
+			cmd := exec.Command(s.exe, args...)
+			cmd.Stdout = logFile
+			cmd.Stderr = logFile
+			destroyErr = cmd.Run()
+			logFile.Close()
+		}
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if destroyErr != nil {
+		msg := "destroy: " + destroyErr.Error()
+		if last := lastLogLine(outputLogPath(runID)); last != "" {
+			msg += ": " + last
+		}
+		s.setState(runID, RunStateFailed, msg)
+	} else {
+		s.setState(runID, RunStateDestroyed, "")
+	}
+	fmt.Printf("[%s] Run %s %s\n", time.Now().Format("15:04:05"), runID, s.runs[runID].State)
+}
+
+func (s *server) fail(runID, msg string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.setState(runID, RunStateFailed, msg)
+}
+
+// setState меняет состояние запуска, сохраняет serve.json и публикует событие; s.mu захвачен
+func (s *server) setState(runID, state, errMsg string) {
+	run := s.runs[runID]
+	run.State = state
+	run.Error = errMsg
+	if state != RunStateRunning && state != RunStateDestroying {
+		now := time.Now()
+		run.FinishedAt = &now
+	}
+	if data, err := json.MarshalIndent(run, "", "  "); err == nil {
+		err = writeFileAtomic(servedRunPath(runID), data)
+		if err != nil {
+			log.Printf("Warning: could not save state of run %s: %v", runID, err)
+		}
+	}
+	s.events.publish(LifecycleEvent{Type: EventRun, RunID: runID, At: time.Now(), State: state, Message: errMsg})
+}
+
+// recover читает runs/*/serve.json после перезапуска: живые процессы запусков берет под наблюдение,
+// прерванные запуски продолжает с --resume, незаконченное удаление повторяет
+func (s *server) recover() {
+	paths, _ := filepath.Glob(filepath.Join("runs", "*", "serve.json"))
+	for _, path := range paths {
+		data, err := os.ReadFile(path)
+		if err != nil {
+			log.Printf("Warning: %v", err)
+			continue
+		}
+		run := &ServedRun{}
+		if err := json.Unmarshal(data, run); err != nil {
+			log.Printf("Warning: failed to parse %s: %v", path, err)
+			continue
+		}
+		s.runs[run.RunID] = run
+		if run.State != RunStateRunning && run.State != RunStateDestroying {
+			continue
+		}
+
+		var proc *os.Process
+		if run.PID > 0 {
+			if p, err := os.FindProcess(run.PID); err == nil && processAlive(p) {
+				proc = p
+			}
+		}
+		switch {
+		case run.State == RunStateDestroying:
+			if proc != nil {
+				s.adopt(run.RunID, proc)
+			}
+			go s.destroy(run.RunID)
+		case proc != nil:
+			s.adopt(run.RunID, proc)
+		default:
+			s.resume(run)
+		}
+	}
+}
+
+// resume продолжает запуск, процесс которого завершился вместе с прошлым serve
+func (s *server) resume(run *ServedRun) {
+	m, err := loadRunManifest(filepath.Join("runs", run.RunID, "run_manifest.json"), nil)
+	switch {
+	case err != nil:
+		s.fail(run.RunID, "serve restarted before the run created instances")
+	case len(m.Active()) == 0 && len(m.Candidates) == 0:
+		s.mu.Lock()
+		s.setState(run.RunID, RunStateFinished, "")
+		s.mu.Unlock()
+	default:
+		// Флаги запуска берутся из манифеста
+		s.mu.Lock()
+		run.Resumes++
+		s.mu.Unlock()
+		fmt.Printf("[%s] Resuming run %s\n", time.Now().Format("15:04:05"), run.RunID)
+		s.start(run.RunID, []string{"run", "-resume", "-run=" + run.RunID, "-dashboard=off"})
+	}
+}
+
+// watch перечитывает манифесты незавершенных запусков и публикует новые записи истории инстансов
+func (s *server) watch() {
+	seen := make(map[string]map[int]int) // запуск -> инстанс -> сколько записей истории уже опубликовано
+	for ; ; time.Sleep(serveWatchInterval) {
+		s.mu.Lock()
+		var ids []string
+		for id, run := range s.runs {
+			// Еще один проход после завершения, чтобы отдать последние переходы
+			if run.FinishedAt == nil || time.Since(*run.FinishedAt) < 2*serveWatchInterval {
+				ids = append(ids, id)
+			}
+		}
+		s.mu.Unlock()
+
+		for _, id := range ids {
+			m, err := loadRunManifest(filepath.Join("runs", id, "run_manifest.json"), nil)
+			if err != nil {
+				continue
+			}
+			published, primed := seen[id]
+			if !primed {
+				published = make(map[int]int)
+				seen[id] = published
+			}
+			for _, mi := range m.Instances {
+				// После перезапуска serve старую историю не повторяем
+				if !primed && s.startedBefore(id) {
+					published[mi.InstanceID] = len(mi.History)
+					continue
+				}
+				for i := published[mi.InstanceID]; i < len(mi.History); i++ {
+					h := mi.History[i]
+					e := LifecycleEvent{Type: EventStatus, RunID: id, InstanceID: mi.InstanceID, At: h.At,
+						Status: h.Status, Stage: h.Stage, Message: h.Message}
+					if i == 0 || mi.History[i-1].Stage != h.Stage {
+						e.Type = EventStage
+					}
+					s.events.publish(e)
+				}
+				published[mi.InstanceID] = len(mi.History)
+			}
+		}
+	}
+}
+
+// startedBefore - запуск появился до старта этого serve (прочитан из serve.json)
+func (s *server) startedBefore(runID string) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.runs[runID].StartedAt.Before(serveStartedAt)
+}
+
+var serveStartedAt = time.Now()
+
+// processAlive проверяет процесс сигналом 0
+func processAlive(p *os.Process) bool {
+	return p.Signal(syscall.Signal(0)) == nil
+}
+
+// lastLogLine - последняя непустая строка вывода запуска, чтобы объяснить ошибку в API
+func lastLogLine(path string) string {
+	f, err := os.Open(path)
+	if err != nil {
+		return ""
+	}
+	defer f.Close()
+	if info, err := f.Stat(); err == nil && info.Size() > 4096 {
+		f.Seek(-4096, io.SeekEnd)
+	}
+	data, _ := io.ReadAll(f)
+	lines := strings.Split(strings.TrimSpace(string(data)), "\n")
+	return strings.TrimSpace(lines[len(lines)-1])
+}
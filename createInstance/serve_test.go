@@ -0,0 +1,108 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+// TestServeShutdownEndsEventStreams: открытый поток /events не должен держать Shutdown до таймаута
+func TestServeShutdownEndsEventStreams(t *testing.T) {
+	s := &server{
+		events: &eventHub{subs: make(map[chan LifecycleEvent]string)},
+		runs:   make(map[string]*ServedRun),
+	}
+	srv := httptest.NewServer(s.routes())
+	defer srv.Close()
+
+	resp, err := http.Get(srv.URL + "/events")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	reader := bufio.NewReader(resp.Body)
+	if line, err := reader.ReadString('\n'); err != nil || !strings.HasPrefix(line, ": connected") {
+		t.Fatalf("first line = %q, %v", line, err)
+	}
+
+	s.events.close()
+	ctx, cancel := context.WithTimeout(context.Background(), 2*time.Second)
+	defer cancel()
+	if err := srv.Config.Shutdown(ctx); err != nil {
+		t.Fatalf("shutdown with an open event stream: %v", err)
+	}
+
+	// Подписка после остановки сразу закрыта
+	if _, ok := <-s.events.subscribe(""); ok {
+		t.Fatal("subscribe after close returned an open channel")
+	}
+}
+
+// TestServeHeartbeatsUseRunSecret: пульс принимается только с секретом своего запуска,
+// а этот секрет не открывает остальной API
+func TestServeHeartbeatsUseRunSecret(t *testing.T) {
+	t.Chdir(t.TempDir())
+	for _, runID := range []string{"r1", "r2"} {
+		if err := writeFileAtomic(filepath.Join("runs", runID, "run_manifest.json"), []byte("{}")); err != nil {
+			t.Fatal(err)
+		}
+	}
+	secret, err := heartbeatToken("r1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if again, err := heartbeatToken("r1"); err != nil || again != secret {
+		t.Fatalf("second heartbeatToken = %q, %v; want the same secret", again, err)
+	}
+	other, err := heartbeatToken("r2")
+	if err != nil || other == secret {
+		t.Fatalf("runs share a heartbeat secret: %v", err)
+	}
+	if info, err := os.Stat(heartbeatTokenPath("r1")); err != nil || info.Mode().Perm() != 0600 {
+		t.Fatalf("heartbeat token file: %v, %v", info, err)
+	}
+
+	s := &server{
+		token:  "api-token",
+		events: &eventHub{subs: make(map[chan LifecycleEvent]string)},
+		runs:   make(map[string]*ServedRun),
+	}
+	srv := httptest.NewServer(s.routes())
+	defer srv.Close()
+	post := func(path, token, body string) int {
+		t.Helper()
+		req, _ := http.NewRequest(http.MethodPost, srv.URL+path, strings.NewReader(body))
+		req.Header.Set("Authorization", "Bearer "+token)
+		resp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		resp.Body.Close()
+		return resp.StatusCode
+	}
+
+	heartbeat := `{"run_id":"r1","instance_id":"7","phase":"streaming"}`
+	cases := []struct {
+		name, path, token, body string
+		want                    int
+	}{
+		{"API token on heartbeats", "/heartbeats", "api-token", heartbeat, http.StatusUnauthorized},
+		{"another run's secret", "/heartbeats", other, heartbeat, http.StatusUnauthorized},
+		{"run secret", "/heartbeats", secret, heartbeat, http.StatusNoContent},
+		{"run secret on the API", "/runs", secret, `{}`, http.StatusUnauthorized},
+	}
+	for _, c := range cases {
+		if got := post(c.path, c.token, c.body); got != c.want {
+			t.Errorf("%s: status %d, want %d", c.name, got, c.want)
+		}
+	}
+	if _, err := os.Stat(heartbeatPath("r1", 7)); err != nil {
+		t.Fatalf("accepted heartbeat is not stored: %v", err)
+	}
+}
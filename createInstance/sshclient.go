@@ -0,0 +1,398 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/pkg/sftp"
+	"golang.org/x/crypto/ssh"
+	"golang.org/x/crypto/ssh/knownhosts"
+)
+
+// sshKeyPath - приватный ключ, публичную часть которого getOrCreateSSHKey регистрирует у провайдера
+func sshKeyPath() string {
+	homeDir, _ := os.UserHomeDir()
+	return filepath.Join(homeDir, ".ssh", "vastai_rsa")
+}
+
+// sshKnownHostsPath - known_hosts инструмента, отдельный от ~/.ssh/known_hosts пользователя
+func sshKnownHostsPath() string {
+	homeDir, _ := os.UserHomeDir()
+	return filepath.Join(homeDir, ".ssh", "vastai_known_hosts")
+}
+
+// SSHManager держит по одному SSH соединению на инстанс и выполняет через него команды.
+// Ключ хоста запоминается при первом подключении (TOFU); смена ключа - ошибка
+type SSHManager struct {
+	User        string
+	DialTimeout time.Duration
+	Output      io.Writer // куда транслируется вывод Run; nil - текущий os.Stdout
+
+	signer     ssh.Signer
+	knownHosts string
+
+	mu      sync.Mutex
+	clients map[string]*ssh.Client
+	outMu   sync.Mutex
+}
+
+// knownHostsMu защищает файл known_hosts от одновременной записи из разных горутин
+var knownHostsMu sync.Mutex
+
+func newSSHManager(signer ssh.Signer, knownHostsPath string) *SSHManager {
+	return &SSHManager{
+		User:        "root",
+		DialTimeout: 15 * time.Second,
+		signer:      signer,
+		knownHosts:  knownHostsPath,
+		clients:     make(map[string]*ssh.Client),
+	}
+}
+
+// NewSSHManager создает менеджер с ключом vastai_rsa (генерирует его при отсутствии)
+func NewSSHManager() (*SSHManager, error) {
+	if _, err := getOrCreateSSHKey(); err != nil {
+		return nil, fmt.Errorf("failed to get SSH key: %v", err)
+	}
+	data, err := os.ReadFile(sshKeyPath())
+	if err != nil {
+		return nil, fmt.Errorf("failed to read SSH key: %v", err)
+	}
+	signer, err := ssh.ParsePrivateKey(data)
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse SSH key %s: %v", sshKeyPath(), err)
+	}
+	return newSSHManager(signer, sshKnownHostsPath()), nil
+}
+
+var (
+	sshSessionsOnce sync.Once
+	sshSessionsMgr  *SSHManager
+	sshSessionsErr  error
+)
+
+// sshSessions возвращает общий для процесса менеджер, чтобы соединения переиспользовались между этапами
+func sshSessions() (*SSHManager, error) {
+	sshSessionsOnce.Do(func() {
+		sshSessionsMgr, sshSessionsErr = NewSSHManager()
+	})
+	return sshSessionsMgr, sshSessionsErr
+}
+
+func sshAddress(host string, port int) string {
+	return net.JoinHostPort(host, strconv.Itoa(port))
+}
+
+// hostKeyCallback проверяет ключ по known_hosts инструмента; неизвестный хост добавляется
+func (m *SSHManager) hostKeyCallback(hostname string, remote net.Addr, key ssh.PublicKey) error {
+	knownHostsMu.Lock()
+	defer knownHostsMu.Unlock()
+
+	if err := os.MkdirAll(filepath.Dir(m.knownHosts), 0700); err != nil {
+		return err
+	}
+	if _, err := os.Stat(m.knownHosts); os.IsNotExist(err) {
+		if err := os.WriteFile(m.knownHosts, nil, 0600); err != nil {
+			return err
+		}
+	}
+	check, err := knownhosts.New(m.knownHosts)
+	if err != nil {
+		return fmt.Errorf("failed to read %s: %v", m.knownHosts, err)
+	}
+	err = check(hostname, remote, key)
+	var keyErr *knownhosts.KeyError
+	if !errors.As(err, &keyErr) {
+		return err
+	}
+	if len(keyErr.Want) > 0 {
+		return fmt.Errorf("host key for %s changed (%s); remove its line from %s if the instance was re-created",
+			hostname, ssh.FingerprintSHA256(key), m.knownHosts)
+	}
+
+	f, err := os.OpenFile(m.knownHosts, os.O_APPEND|os.O_WRONLY, 0600)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	_, err = fmt.Fprintln(f, knownhosts.Line([]string{knownhosts.Normalize(hostname)}, key))
+	return err
+}
+
+// forgetHostKey удаляет ключ хоста из known_hosts: адреса vast.ai достаются следующим инстансам
+func forgetHostKey(knownHostsPath, host string, port int) error {
+	knownHostsMu.Lock()
+	defer knownHostsMu.Unlock()
+
+	data, err := os.ReadFile(knownHostsPath)
+	if os.IsNotExist(err) {
+		return nil
+	} else if err != nil {
+		return err
+	}
+	prefix := knownhosts.Normalize(sshAddress(host, port)) + " "
+	var kept []string
+	for _, line := range strings.Split(strings.TrimRight(string(data), "\n"), "\n") {
+		if line != "" && !strings.HasPrefix(line, prefix) {
+			kept = append(kept, line)
+		}
+	}
+	content := strings.Join(kept, "\n")
+	if content != "" {
+		content += "\n"
+	}
+	return os.WriteFile(knownHostsPath, []byte(content), 0600)
+}
+
+// client возвращает открытое соединение с хостом или устанавливает новое
+func (m *SSHManager) client(host string, port int) (*ssh.Client, error) {
+	addr := sshAddress(host, port)
+	m.mu.Lock()
+	c, ok := m.clients[addr]
+	m.mu.Unlock()
+	if ok {
+		return c, nil
+	}
+
+	config := &ssh.ClientConfig{
+		User:            m.User,
+		Auth:            []ssh.AuthMethod{ssh.PublicKeys(m.signer)},
+		HostKeyCallback: m.hostKeyCallback,
+		Timeout:         m.DialTimeout,
+	}
+	c, err := ssh.Dial("tcp", addr, config)
+	if err != nil {
+		return nil, err
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if existing, ok := m.clients[addr]; ok {
+		// Параллельный вызов успел подключиться первым
+		c.Close()
+		return existing, nil
+	}
+	m.clients[addr] = c
+	return c, nil
+}
+
+func (m *SSHManager) drop(host string, port int) {
+	addr := sshAddress(host, port)
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if c, ok := m.clients[addr]; ok {
+		c.Close()
+		delete(m.clients, addr)
+	}
+}
+
+// Connect устанавливает (или проверяет) соединение с хостом
+func (m *SSHManager) Connect(host string, port int) error {
+	_, err := m.client(host, port)
+	return err
+}
+
+// session открывает сессию; если закешированное соединение оборвалось, переподключается один раз
+func (m *SSHManager) session(host string, port int) (*ssh.Session, error) {
+	c, err := m.client(host, port)
+	if err != nil {
+		return nil, err
+	}
+	s, err := c.NewSession()
+	if err == nil {
+		return s, nil
+	}
+	m.drop(host, port)
+	if c, err = m.client(host, port); err != nil {
+		return nil, err
+	}
+	return c.NewSession()
+}
+
+// Run выполняет команду с таймаутом. Если prefix не пустой, вывод построчно транслируется в
+// Output с этим префиксом. Возвращает весь вывод (stdout и stderr)
+func (m *SSHManager) Run(ctx context.Context, host string, port int, prefix, command string, timeout time.Duration) (string, error) {
+	s, err := m.session(host, port)
+	if err != nil {
+		return "", err
+	}
+	defer s.Close()
+
+	var output bytes.Buffer
+	var w io.Writer = &output
+	if prefix != "" {
+		out := m.Output
+		if out == nil {
+			// Живая таблица подменяет os.Stdout, вывод должен попасть к ней
+			out = os.Stdout
+		}
+		pw := &prefixWriter{prefix: prefix, out: out, mu: &m.outMu}
+		defer pw.Flush()
+		w = io.MultiWriter(&output, pw)
+	}
+	sw := &syncWriter{w: w}
+	s.Stdout = sw
+	s.Stderr = sw
+
+	if timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, timeout)
+		defer cancel()
+	}
+
+	done := make(chan error, 1)
+	go func() { done <- s.Run(command) }()
+	select {
+	case err = <-done:
+	case <-ctx.Done():
+		s.Signal(ssh.SIGKILL)
+		s.Close()
+		// После Close Run возвращается, дождавшись копирования вывода; только потом читаем буфер
+		select {
+		case <-done:
+		case <-time.After(5 * time.Second):
+			return "", fmt.Errorf("command timed out after %v", timeout)
+		}
+		err = fmt.Errorf("command timed out after %v", timeout)
+	}
+	return output.String(), err
+}
+
+// Upload заливает файл по SFTP во временный файл, сверяет sha256 на инстансе и только потом
+// переименовывает его в remote. Если на инстансе уже лежит файл с той же суммой, заливки нет.
+// Возвращает true, если файл был залит
+func (m *SSHManager) Upload(ctx context.Context, host string, port int, f RunnerFile, timeout time.Duration) (bool, error) {
+	output, _ := m.Run(ctx, host, port, "", fmt.Sprintf("sha256sum %s 2>/dev/null", f.Remote), 30*time.Second)
+	if fields := strings.Fields(output); len(fields) > 0 && fields[0] == f.SHA256 {
+		return false, nil
+	}
+
+	c, err := m.client(host, port)
+	if err != nil {
+		return false, err
+	}
+	sc, err := sftp.NewClient(c, sftp.UseConcurrentWrites(true))
+	if err != nil {
+		return false, fmt.Errorf("failed to start SFTP: %v", err)
+	}
+	defer sc.Close()
+
+	tmp := f.Remote + ".upload"
+	done := make(chan error, 1)
+	go func() { done <- copyToRemote(sc, f.Local, tmp) }()
+	ctx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+	select {
+	case err = <-done:
+	case <-ctx.Done():
+		// Закрытие клиента прерывает запись
+		sc.Close()
+		<-done
+		err = fmt.Errorf("upload timed out after %v", timeout)
+	}
+	if err != nil {
+		sc.Remove(tmp)
+		return false, err
+	}
+
+	output, err = m.Run(ctx, host, port, "", fmt.Sprintf("sha256sum %s", tmp), time.Minute)
+	if err != nil {
+		return false, fmt.Errorf("failed to verify %s: %v", tmp, err)
+	}
+	if fields := strings.Fields(output); len(fields) == 0 || fields[0] != f.SHA256 {
+		sc.Remove(tmp)
+		return false, fmt.Errorf("checksum mismatch for %s: got %q, want %s", f.Remote, strings.TrimSpace(output), f.SHA256)
+	}
+	if err := sc.Chmod(tmp, f.Mode); err != nil {
+		return false, fmt.Errorf("failed to chmod %s: %v", tmp, err)
+	}
+	// Переименование заменяет и запущенный бинарник, в отличие от записи поверх него
+	if err := sc.PosixRename(tmp, f.Remote); err != nil {
+		return false, fmt.Errorf("failed to rename %s: %v", tmp, err)
+	}
+	return true, nil
+}
+
+func copyToRemote(sc *sftp.Client, local, remote string) error {
+	src, err := os.Open(local)
+	if err != nil {
+		return err
+	}
+	defer src.Close()
+	dst, err := sc.Create(remote)
+	if err != nil {
+		return fmt.Errorf("failed to create %s: %v", remote, err)
+	}
+	if _, err := dst.ReadFrom(src); err != nil {
+		dst.Close()
+		return fmt.Errorf("failed to write %s: %v", remote, err)
+	}
+	return dst.Close()
+}
+
+// Close закрывает все соединения
+func (m *SSHManager) Close() {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	for addr, c := range m.clients {
+		c.Close()
+		delete(m.clients, addr)
+	}
+}
+
+// syncWriter сериализует запись stdout и stderr сессии в общий буфер
+type syncWriter struct {
+	mu sync.Mutex
+	w  io.Writer
+}
+
+func (s *syncWriter) Write(p []byte) (int, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.w.Write(p)
+}
+
+// prefixWriter пишет вывод целыми строками с префиксом инстанса, чтобы параллельные
+// инстансы не перемешивали строки
+type prefixWriter struct {
+	prefix string
+	out    io.Writer
+	mu     *sync.Mutex
+	buf    []byte
+}
+
+func (p *prefixWriter) Write(data []byte) (int, error) {
+	p.buf = append(p.buf, data...)
+	for {
+		i := bytes.IndexByte(p.buf, '\n')
+		if i < 0 {
+			break
+		}
+		p.writeLine(p.buf[:i])
+		p.buf = p.buf[i+1:]
+	}
+	return len(data), nil
+}
+
+func (p *prefixWriter) Flush() {
+	if len(p.buf) > 0 {
+		p.writeLine(p.buf)
+		p.buf = nil
+	}
+}
+
+func (p *prefixWriter) writeLine(line []byte) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	fmt.Fprintf(p.out, "%s %s\n", p.prefix, strings.TrimRight(string(line), "\r"))
+}
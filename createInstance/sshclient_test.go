@@ -0,0 +1,297 @@
+package main
+
+import (
+	"context"
+	"crypto/ed25519"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/binary"
+	"encoding/hex"
+	"errors"
+	"net"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/pkg/sftp"
+	"golang.org/x/crypto/ssh"
+)
+
+// testSSHServer - SSH сервер в процессе: exec через /bin/sh в dir и подсистема sftp
+type testSSHServer struct {
+	host string
+	port int
+	dir  string
+	key  ssh.Signer
+	ln   net.Listener
+}
+
+func newTestSigner(t *testing.T) ssh.Signer {
+	t.Helper()
+	_, priv, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	signer, err := ssh.NewSignerFromKey(priv)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return signer
+}
+
+// startTestSSHServer слушает addr и пускает только ключ client; hostKey - ключ хоста (nil - новый)
+func startTestSSHServer(t *testing.T, addr string, client ssh.PublicKey, hostKey ssh.Signer, dir string) *testSSHServer {
+	t.Helper()
+	if hostKey == nil {
+		hostKey = newTestSigner(t)
+	}
+	config := &ssh.ServerConfig{
+		PublicKeyCallback: func(_ ssh.ConnMetadata, key ssh.PublicKey) (*ssh.Permissions, error) {
+			if string(key.Marshal()) != string(client.Marshal()) {
+				return nil, errors.New("unknown key")
+			}
+			return nil, nil
+		},
+	}
+	config.AddHostKey(hostKey)
+
+	ln, err := net.Listen("tcp", addr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { ln.Close() })
+	go func() {
+		for {
+			conn, err := ln.Accept()
+			if err != nil {
+				return
+			}
+			go serveTestSSHConn(conn, config, dir)
+		}
+	}()
+	tcp := ln.Addr().(*net.TCPAddr)
+	return &testSSHServer{host: "127.0.0.1", port: tcp.Port, dir: dir, key: hostKey, ln: ln}
+}
+
+func serveTestSSHConn(conn net.Conn, config *ssh.ServerConfig, dir string) {
+	sconn, chans, reqs, err := ssh.NewServerConn(conn, config)
+	if err != nil {
+		conn.Close()
+		return
+	}
+	defer sconn.Close()
+	go ssh.DiscardRequests(reqs)
+	for newCh := range chans {
+		if newCh.ChannelType() != "session" {
+			newCh.Reject(ssh.UnknownChannelType, "only sessions")
+			continue
+		}
+		ch, requests, err := newCh.Accept()
+		if err != nil {
+			continue
+		}
+		go serveTestSession(ch, requests, dir)
+	}
+}
+
+func serveTestSession(ch ssh.Channel, requests <-chan *ssh.Request, dir string) {
+	defer ch.Close()
+	for req := range requests {
+		switch req.Type {
+		case "exec":
+			var payload struct{ Command string }
+			ssh.Unmarshal(req.Payload, &payload)
+			req.Reply(true, nil)
+			cmd := exec.Command("/bin/sh", "-c", payload.Command)
+			cmd.Dir = dir
+			cmd.Stdout = ch
+			cmd.Stderr = ch.Stderr()
+			status := uint32(0)
+			if err := cmd.Run(); err != nil {
+				status = 255
+				var exitErr *exec.ExitError
+				if errors.As(err, &exitErr) {
+					status = uint32(exitErr.ExitCode())
+				}
+			}
+			ch.SendRequest("exit-status", false, binary.BigEndian.AppendUint32(nil, status))
+			return
+		case "subsystem":
+			var payload struct{ Name string }
+			ssh.Unmarshal(req.Payload, &payload)
+			if payload.Name != "sftp" {
+				req.Reply(false, nil)
+				continue
+			}
+			req.Reply(true, nil)
+			server, err := sftp.NewServer(ch, sftp.WithServerWorkingDirectory(dir))
+			if err != nil {
+				return
+			}
+			server.Serve()
+			return
+		default:
+			if req.WantReply {
+				req.Reply(false, nil)
+			}
+		}
+	}
+}
+
+func newTestSSHManager(t *testing.T) (*SSHManager, ssh.Signer) {
+	t.Helper()
+	clientKey := newTestSigner(t)
+	mgr := newSSHManager(clientKey, filepath.Join(t.TempDir(), "known_hosts"))
+	mgr.DialTimeout = 5 * time.Second
+	t.Cleanup(mgr.Close)
+	return mgr, clientKey
+}
+
+func TestSSHManagerTrustsHostKeyOnFirstUse(t *testing.T) {
+	mgr, clientKey := newTestSSHManager(t)
+	srv := startTestSSHServer(t, "127.0.0.1:0", clientKey.PublicKey(), nil, t.TempDir())
+
+	if err := mgr.Connect(srv.host, srv.port); err != nil {
+		t.Fatalf("first connect: %v", err)
+	}
+	data, err := os.ReadFile(mgr.knownHosts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	line := strings.TrimSpace(string(data))
+	wantKey := strings.TrimSpace(string(ssh.MarshalAuthorizedKey(srv.key.PublicKey())))
+	if !strings.HasPrefix(line, "[127.0.0.1]:") || !strings.HasSuffix(line, wantKey) {
+		t.Fatalf("known_hosts = %q, want the server key %q", line, wantKey)
+	}
+
+	// Повторное подключение с тем же ключом проходит и не дописывает строку
+	mgr.Close()
+	if err := mgr.Connect(srv.host, srv.port); err != nil {
+		t.Fatalf("reconnect: %v", err)
+	}
+	again, _ := os.ReadFile(mgr.knownHosts)
+	if string(again) != string(data) {
+		t.Fatalf("known_hosts changed on reconnect:\n%s", again)
+	}
+}
+
+func TestSSHManagerRejectsChangedHostKey(t *testing.T) {
+	mgr, clientKey := newTestSSHManager(t)
+	srv := startTestSSHServer(t, "127.0.0.1:0", clientKey.PublicKey(), nil, t.TempDir())
+	if err := mgr.Connect(srv.host, srv.port); err != nil {
+		t.Fatal(err)
+	}
+	mgr.Close()
+
+	// Тот же адрес, другой ключ хоста - как у пересозданного инстанса
+	srv.ln.Close()
+	other := startTestSSHServer(t, sshAddress(srv.host, srv.port), clientKey.PublicKey(), nil, t.TempDir())
+
+	err := mgr.Connect(other.host, other.port)
+	if err == nil || !strings.Contains(err.Error(), "host key for") || !strings.Contains(err.Error(), "changed") {
+		t.Fatalf("connect with a changed key: err = %v, want a changed host key error", err)
+	}
+
+	// После forgetHostKey (инстанс пересоздан) новый ключ снова принимается
+	if err := forgetHostKey(mgr.knownHosts, other.host, other.port); err != nil {
+		t.Fatal(err)
+	}
+	if err := mgr.Connect(other.host, other.port); err != nil {
+		t.Fatalf("connect after forgetHostKey: %v", err)
+	}
+}
+
+func TestSSHManagerRunExitCodeAndStderr(t *testing.T) {
+	mgr, clientKey := newTestSSHManager(t)
+	srv := startTestSSHServer(t, "127.0.0.1:0", clientKey.PublicKey(), nil, t.TempDir())
+	ctx := context.Background()
+
+	output, err := mgr.Run(ctx, srv.host, srv.port, "", "echo out; echo err >&2; exit 3", 10*time.Second)
+	var exitErr *ssh.ExitError
+	if !errors.As(err, &exitErr) || exitErr.ExitStatus() != 3 {
+		t.Fatalf("err = %v, want exit status 3", err)
+	}
+	if !strings.Contains(output, "out\n") || !strings.Contains(output, "err\n") {
+		t.Fatalf("output = %q, want stdout and stderr", output)
+	}
+
+	var streamed strings.Builder
+	mgr.Output = &streamed
+	output, err = mgr.Run(ctx, srv.host, srv.port, "[i1]", "echo one; echo two >&2", 10*time.Second)
+	if err != nil {
+		t.Fatalf("run: %v", err)
+	}
+	for _, want := range []string{"[i1] one\n", "[i1] two\n"} {
+		if !strings.Contains(streamed.String(), want) {
+			t.Fatalf("streamed output %q has no %q", streamed.String(), want)
+		}
+	}
+	if output != "one\ntwo\n" && output != "two\none\n" {
+		t.Fatalf("output = %q", output)
+	}
+
+	_, err = mgr.Run(ctx, srv.host, srv.port, "", "sleep 5", 200*time.Millisecond)
+	if err == nil || !strings.Contains(err.Error(), "timed out") {
+		t.Fatalf("err = %v, want a timeout", err)
+	}
+}
+
+func TestSSHManagerUploadVerifiesChecksum(t *testing.T) {
+	if _, err := exec.LookPath("sha256sum"); err != nil {
+		t.Skip("sha256sum is not installed")
+	}
+	mgr, clientKey := newTestSSHManager(t)
+	remoteDir := t.TempDir()
+	srv := startTestSSHServer(t, "127.0.0.1:0", clientKey.PublicKey(), nil, remoteDir)
+	ctx := context.Background()
+
+	local := filepath.Join(t.TempDir(), "runner")
+	content := []byte("#!/bin/sh\necho runner\n")
+	if err := os.WriteFile(local, content, 0644); err != nil {
+		t.Fatal(err)
+	}
+	sum := sha256.Sum256(content)
+	f := RunnerFile{Local: local, Remote: "highLoadTest", Size: int64(len(content)), SHA256: hex.EncodeToString(sum[:]), Mode: 0755}
+
+	// Старый файл на месте заменяется переименованием
+	remote := filepath.Join(remoteDir, f.Remote)
+	if err := os.WriteFile(remote, []byte("old"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	uploaded, err := mgr.Upload(ctx, srv.host, srv.port, f, time.Minute)
+	if err != nil || !uploaded {
+		t.Fatalf("Upload = %v, %v; want uploaded", uploaded, err)
+	}
+	got, _ := os.ReadFile(remote)
+	info, _ := os.Stat(remote)
+	if string(got) != string(content) || info.Mode().Perm() != 0755 {
+		t.Fatalf("remote file = %q (mode %v)", got, info.Mode().Perm())
+	}
+	if _, err := os.Stat(remote + ".upload"); !os.IsNotExist(err) {
+		t.Fatalf("temporary file left behind: %v", err)
+	}
+
+	// Та же сумма - заливки нет
+	uploaded, err = mgr.Upload(ctx, srv.host, srv.port, f, time.Minute)
+	if err != nil || uploaded {
+		t.Fatalf("second Upload = %v, %v; want up to date", uploaded, err)
+	}
+
+	// Неверная сумма: файл не заменяется, временный удаляется
+	bad := f
+	bad.Remote = "other"
+	bad.SHA256 = strings.Repeat("0", 64)
+	_, err = mgr.Upload(ctx, srv.host, srv.port, bad, time.Minute)
+	if err == nil || !strings.Contains(err.Error(), "checksum mismatch") {
+		t.Fatalf("err = %v, want checksum mismatch", err)
+	}
+	if _, err := os.Stat(filepath.Join(remoteDir, "other")); !os.IsNotExist(err) {
+		t.Fatalf("file with a bad checksum was renamed into place: %v", err)
+	}
+	if _, err := os.Stat(filepath.Join(remoteDir, "other.upload")); !os.IsNotExist(err) {
+		t.Fatalf("temporary file left behind: %v", err)
+	}
+}
@@ -0,0 +1,448 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	VASTAI_API_KEY = "1e19fbc2284ced113253ec19f519fc9aebbb2a36a917ee97b5c71376b2a2cf38"
+	VASTAI_API_URL = "https://console.vast.ai/api/v0"
+)
+
+type VastClient struct {
+	apiKey  string
+	baseURL string
+	client  *http.Client
+
+	limiter *tokenBucket
+	retry   retryPolicy
+	metrics *apiMetrics
+}
+
+type CreateInstanceRequest struct {
+	ClientID      string `json:"client_id"`
+	Image         string `json:"image"`
+	DiskSpace     int    `json:"disk"`
+	OnStart       string `json:"onstart_cmd"`
+	RunType       string `json:"runtype"`
+	ImageLogin    string `json:"image_login"`
+	PythonVersion string `json:"python_utf8"`
+	CudaVersion   string `json:"cuda_version"`
+	UseSSHKey     bool   `json:"use_ssh_key"`
+}
+
+// NewVastClient - провайдер vast.ai; все запросы проходят через один лимитер rate запросов/с
+func NewVastClient(apiKey string, rate float64, burst int) *VastClient {
+	return &VastClient{
+		apiKey:  apiKey,
+		baseURL: VASTAI_API_URL,
+		client:  &http.Client{Timeout: 30 * time.Second},
+		limiter: newTokenBucket(rate, burst),
+		retry:   defaultRetryPolicy(),
+		metrics: newAPIMetrics(),
+	}
+}
+
+func (v *VastClient) Name() string {
+	return ProviderVast
+}
+
+// makeRequest выполняет запрос через общий лимитер; 429 повторяется всегда (запрос не был
+// обработан), 5xx и сетевые ошибки - только для идемпотентных GET и DELETE
+func (v *VastClient) makeRequest(ctx context.Context, method, endpoint string, body interface{}) ([]byte, error) {
+	url := v.baseURL + endpoint
+
+	var jsonBody []byte
+	if body != nil {
+		var err error
+		jsonBody, err = json.Marshal(body)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	key := endpointKey(method, endpoint)
+	idempotent := method == http.MethodGet || method == http.MethodDelete
+	for attempt := 0; ; attempt++ {
+		if err := v.limiter.Wait(ctx); err != nil {
+			return nil, err
+		}
+
+		started := time.Now()
+		resp, respBody, err := v.doRequest(ctx, method, url, jsonBody)
+		status := 0
+		if resp != nil {
+			status = resp.StatusCode
+		}
+
+		retryable := status == http.StatusTooManyRequests || (idempotent && (err != nil || status >= 500))
+		retry := retryable && attempt < v.retry.MaxRetries && ctx.Err() == nil
+		v.metrics.observe(key, status, time.Since(started), retry)
+
+		if !retry {
+			if err != nil {
+				return nil, err
+			}
+			if status >= 400 {
+				return nil, fmt.Errorf("API error (status %d): %s", status, string(respBody))
+			}
+			return respBody, nil
+		}
+
+		delay := v.retry.backoff(attempt)
+		if resp != nil {
+			if d, ok := retryAfter(resp); ok {
+				delay = d
+			}
+		}
+		reason := fmt.Sprintf("status %d", status)
+		if err != nil {
+			reason = err.Error()
+		}
+		log.Printf("vast.ai %s: %s, retry %d/%d in %v", key, reason, attempt+1, v.retry.MaxRetries, delay.Round(time.Millisecond))
+		select {
+		case <-time.After(delay):
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		}
+	}
+}
+
+func (v *VastClient) doRequest(ctx context.Context, method, url string, jsonBody []byte) (*http.Response, []byte, error) {
+	var reqBody io.Reader
+	if jsonBody != nil {
+		reqBody = bytes.NewReader(jsonBody)
+	}
+
+	req, err := http.NewRequestWithContext(ctx, method, url, reqBody)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	// Используем те же заголовки что и UI
+	req.Header.Set("Authorization", "Bearer "+v.apiKey)
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("Accept", "application/json, text/plain, */*")
+	req.Header.Set("Accept-Language", "en-GB,en;q=0.9,ru-RU;q=0.8,ru;q=0.7,en-US;q=0.6")
+	req.Header.Set("Cache-Control", "no-cache")
+	req.Header.Set("Origin", "https://cloud.vast.ai")
+	req.Header.Set("Pragma", "no-cache")
+	req.Header.Set("Sec-Ch-Ua", `"Chromium";v="140", "Not=A?Brand";v="24", "Google Chrome";v="140"`)
+	req.Header.Set("Sec-Ch-Ua-Mobile", "?0")
+	req.Header.Set("Sec-Ch-Ua-Platform", `"macOS"`)
+	req.Header.Set("Sec-Fetch-Dest", "empty")
+	req.Header.Set("Sec-Fetch-Mode", "cors")
+	req.Header.Set("Sec-Fetch-Site", "same-origin")
+	req.Header.Set("User-Agent", "Mozilla/5.0 (Macintosh; Intel Mac OS X 10_15_7) AppleWebKit/537.36 (KHTML, like Gecko) Chrome/140.0.0.0 Safari/537.36")
+
+	resp, err := v.client.Do(req)
+	if err != nil {
+		return nil, nil, err
+	}
+	defer resp.Body.Close()
+
+	respBody, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return resp, nil, err
+	}
+	return resp, respBody, nil
+}
+
+// APIMetrics возвращает статистику запросов по эндпоинтам
+func (v *VastClient) APIMetrics() []EndpointMetrics {
+	return v.metrics.snapshot()
+}
+
+// vastPageSize - размер страницы поиска; следующие страницы запрашиваются курсором по цене
+const vastPageSize = 64
+
+// offerSearchQuery переводит границы запроса в фильтры /bundles
+func offerSearchQuery(query OfferQuery, minPrice float64) map[string]interface{} {
+	minDisk := query.MinDiskGB
+	if minDisk == 0 {
+		minDisk = 30.0
+	}
+	searchQuery := map[string]interface{}{
+		"rentable":           map[string]bool{"eq": true},
+		"disk_space":         map[string]float64{"gte": minDisk},
+		"gpu_display_active": map[string]bool{"eq": true},
+	}
+
+	// Добавляем фильтр верификации если нужно
+	if query.VerifiedOnly {
+		searchQuery["verification"] = map[string]string{"eq": "verified"}
+	}
+
+	price := map[string]float64{}
+	if query.MaxPrice > 0 {
+		price["lte"] = query.MaxPrice
+	}
+	if minPrice > 0 {
+		price["gte"] = minPrice
+	}
+	if len(price) > 0 {
+		searchQuery["dph_total"] = price
+	}
+
+	bounds := []struct {
+		field string
+		value float64
+	}{
+		{"reliability2", query.MinReliability},
+		{"inet_down", query.MinInetDown},
+		{"inet_up", query.MinInetUp},
+		{"cpu_cores_effective", query.MinCPUCores},
+		{"cpu_ram", query.MinRAMGB * 1024},
+		{"cuda_max_good", query.MinCUDA},
+	}
+	for _, b := range bounds {
+		if b.value > 0 {
+			searchQuery[b.field] = map[string]float64{"gte": b.value}
+		}
+	}
+	return searchQuery
+}
+
+// SearchOffers возвращает до query.Limit предложений от дешевых к дорогим, листая страницы
+func (v *VastClient) SearchOffers(ctx context.Context, query OfferQuery) ([]Offer, error) {
+	limit := query.Limit
+	if limit < 10 {
+		limit = 10
+	}
+
+	var offers []Offer
+	seen := make(map[int]bool)
+	minPrice := 0.0
+	for len(offers) < limit {
+		queryJSON, _ := json.Marshal(offerSearchQuery(query, minPrice))
+		endpoint := fmt.Sprintf("/bundles?q=%s&order=dph_total&type=on-demand&limit=%d", url.QueryEscape(string(queryJSON)), vastPageSize)
+
+		data, err := v.makeRequest(ctx, "GET", endpoint, nil)
+		if err != nil {
+			return nil, err
+		}
+
+		var result struct {
+			Offers []Offer `json:"offers"`
+		}
+
+		if err := json.Unmarshal(data, &result); err != nil {
+			return nil, err
+		}
+
+		// Курсор >= цены последнего предложения повторяет предложения с той же ценой, их пропускаем
+		added := 0
+		for _, offer := range result.Offers {
+			if !seen[offer.ID] {
+				seen[offer.ID] = true
+				offers = append(offers, offer)
+				added++
+			}
+		}
+		if len(result.Offers) < vastPageSize || added == 0 {
+			break
+		}
+		minPrice = result.Offers[len(result.Offers)-1].DPHTotal
+	}
+
+	if len(offers) > limit {
+		offers = offers[:limit]
+	}
+	return offers, nil
+}
+
+var sshKeySetOnce sync.Once
+var globalSSHKeyError error
+
+func (v *VastClient) CreateInstance(ctx context.Context, offer Offer) (*Instance, error) {
+	// Устанавливаем SSH ключ только один раз глобально
+	sshKeySetOnce.Do(func() {
+		sshKey, err := getOrCreateSSHKey()
+		if err != nil {
+			globalSSHKeyError = fmt.Errorf("failed to get SSH key: %v", err)
+			return
+		}
+
+		fmt.Println("Setting SSH key via API...")
+		if err := v.ensureSSHKey(ctx, sshKey); err != nil {
+			globalSSHKeyError = err
+			return
+		}
+		fmt.Println("SSH key configured successfully")
+	})
+
+	if globalSSHKeyError != nil {
+		return nil, globalSSHKeyError
+	}
+
+	fmt.Println("Creating instance via API...")
+
+	// Создаем через API с правильной структурой как в UI
+	portalConfig := "localhost:1111:11111:/:Instance Portal|localhost:6100:16100:/:Selkies Low Latency Desktop|localhost:6200:16200:/guacamole:Apache Guacamole Desktop (VNC)|localhost:8080:8080:/:Jupyter|localhost:8080:8080:/terminals/1:Jupyter Terminal|localhost:8384:18384:/:Syncthing"
+
+	endpoint := "/asks/" + fmt.Sprintf("%d", offer.ID) + "/"
+
+	// Используем точную структуру из curl запроса
+	data := map[string]interface{}{
+		"template_id":      246282,
+		"template_hash_id": "5ba61f6f48c1ffe49ca7163d4e3a6460",
+		"client_id":        "me",
+		"image":            "vastai/linux-desktop:@vastai-automatic-tag",
+		"env": map[string]string{
+			"-p 1111:1111":      "1",
+			"-p 6100:6100":      "1",
+			"-p 73478:73478":    "1",
+			"-p 8384:8384":      "1",
+			"-p 72299:72299":    "1",
+			"-p 6200:6200":      "1",
+			"-p 5900:5900":      "1",
+			"OPEN_BUTTON_TOKEN": "1",
+			"JUPYTER_DIR":       "/",
+			"DATA_DIRECTORY":    "/workspace/",
+			"PORTAL_CONFIG":     portalConfig,
+			"OPEN_BUTTON_PORT":  "1111",
+			"SELKIES_ENCODER":   "x264enc",
+		},
+		"args_str":           "",
+		"onstart":            "entrypoint.sh",
+		"runtype":            "jupyter_direc ssh_direc ssh_proxy",
+		"image_login":        nil,
+		"use_jupyter_lab":    false,
+		"jupyter_dir":        nil,
+		"python_utf8":        nil,
+		"lang_utf8":          nil,
+		"disk":               32,
+		"last_known_min_bid": offer.MinBid,
+	}
+
+	respBody, err := v.makeRequest(ctx, "PUT", endpoint, data)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create instance via API: %v", err)
+	}
+
+	var result map[string]interface{}
+	if err := json.Unmarshal(respBody, &result); err != nil {
+		return nil, fmt.Errorf("failed to parse API response: %v", err)
+	}
+
+	contractID, ok := result["new_contract"].(float64)
+	if !ok {
+		return nil, fmt.Errorf("failed to get contract ID from API response: %v", result)
+	}
+
+	fmt.Printf("Instance created with ID: %d\n", int(contractID))
+	return &Instance{ID: int(contractID)}, nil
+}
+
+// ensureSSHKey регистрирует публичный ключ в аккаунте, если его там еще нет
+func (v *VastClient) ensureSSHKey(ctx context.Context, publicKey string) error {
+	fields := strings.Fields(publicKey)
+	if len(fields) < 2 {
+		return fmt.Errorf("malformed SSH public key")
+	}
+
+	existing, err := v.makeRequest(ctx, "GET", "/ssh/", nil)
+	if err != nil {
+		return fmt.Errorf("failed to list SSH keys: %v", err)
+	}
+	// Сравниваем по base64-части ключа: комментарий в аккаунте может отличаться
+	if bytes.Contains(existing, []byte(fields[1])) {
+		fmt.Println("SSH key already registered")
+		return nil
+	}
+
+	if _, err := v.makeRequest(ctx, "POST", "/ssh/", map[string]string{"ssh_key": strings.TrimSpace(publicKey)}); err != nil {
+		return fmt.Errorf("failed to add SSH key: %v", err)
+	}
+	fmt.Println("SSH key added")
+	return nil
+}
+
+// normalize приводит ответ API к общему виду: пустой статус у только что созданного инстанса,
+// SSH адрес - как у `vastai ssh-url` (прямой порт 22, если проброшен, иначе прокси)
+func (v *VastClient) normalize(instance *Instance) {
+	if instance.Status == "" {
+		instance.Status = StatusCreated
+	}
+	if instance.Status != StatusRunning {
+		return
+	}
+	if bindings := instance.Ports["22/tcp"]; len(bindings) > 0 && instance.PublicIPAddr != "" {
+		if port, err := strconv.Atoi(bindings[0].HostPort); err == nil {
+			instance.SSHHost = instance.PublicIPAddr
+			instance.SSHPort = port
+			return
+		}
+	}
+	if strings.Contains(instance.ImageRuntype, "jupyter") && instance.SSHPort != 0 {
+		instance.SSHPort++
+	}
+}
+
+func (v *VastClient) GetInstance(ctx context.Context, instanceID int) (*Instance, error) {
+	data, err := v.makeRequest(ctx, "GET", fmt.Sprintf("/instances/%d/", instanceID), nil)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get instance info: %v", err)
+	}
+
+	var result struct {
+		Instances *Instance `json:"instances"`
+	}
+	if err := json.Unmarshal(data, &result); err != nil {
+		return nil, fmt.Errorf("failed to parse instance %d: %v", instanceID, err)
+	}
+	if result.Instances == nil || result.Instances.ID == 0 {
+		return nil, fmt.Errorf("instance %d not found", instanceID)
+	}
+
+	instance := result.Instances
+	v.normalize(instance)
+	return instance, nil
+}
+
+func (v *VastClient) SSHEndpoint(ctx context.Context, instanceID int) (SSHEndpoint, error) {
+	instance, err := v.GetInstance(ctx, instanceID)
+	if err != nil {
+		return SSHEndpoint{}, err
+	}
+	if instance.Status != StatusRunning || instance.SSHHost == "" {
+		return SSHEndpoint{}, fmt.Errorf("instance %d is %s, SSH is not available yet", instanceID, instance.Status)
+	}
+	return SSHEndpoint{Host: instance.SSHHost, Port: instance.SSHPort, User: "root"}, nil
+}
+
+func (v *VastClient) DestroyInstance(ctx context.Context, instanceID int) error {
+	if _, err := v.makeRequest(ctx, "DELETE", fmt.Sprintf("/instances/%d/", instanceID), nil); err != nil {
+		return fmt.Errorf("failed to destroy instance %d: %v", instanceID, err)
+	}
+	return nil
+}
+
+func (v *VastClient) ListInstances(ctx context.Context) ([]*Instance, error) {
+	data, err := v.makeRequest(ctx, "GET", "/instances/?owner=me", nil)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list instances: %v", err)
+	}
+
+	var result struct {
+		Instances []*Instance `json:"instances"`
+	}
+	if err := json.Unmarshal(data, &result); err != nil {
+		return nil, fmt.Errorf("failed to parse instance list: %v", err)
+	}
+	for _, instance := range result.Instances {
+		v.normalize(instance)
+	}
+	return result.Instances, nil
+}
@@ -0,0 +1,208 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"image"
+	_ "image/jpeg"
+	_ "image/png"
+	"math/bits"
+	"time"
+)
+
+const (
+	FrameBlack  = "black"
+	FrameBlank  = "blank"
+	FrameFrozen = "frozen"
+)
+
+// FrameConfig - пороги анализа скриншотов (яркость 0-255, расстояние Хэмминга по 64-битному dHash)
+type FrameConfig struct {
+	Enabled        bool    `yaml:"enabled" json:"enabled"`
+	BlackLuma      float64 `yaml:"black_luma" json:"black_luma"`
+	BlankVariance  float64 `yaml:"blank_variance" json:"blank_variance"`
+	FrozenDistance int     `yaml:"frozen_distance" json:"frozen_distance"`
+}
+
+func defaultFrameConfig() FrameConfig {
+	return FrameConfig{
+		Enabled:        true,
+		BlackLuma:      16,
+		BlankVariance:  25,
+		FrozenDistance: 2,
+	}
+}
+
+func (c FrameConfig) Validate() []string {
+	var problems []string
+	if !c.Enabled {
+		return nil
+	}
+	if c.BlackLuma < 0 || c.BlackLuma > 255 {
+		problems = append(problems, "frames.black_luma must be between 0 and 255")
+	}
+	if c.BlankVariance < 0 {
+		problems = append(problems, "frames.blank_variance must not be negative")
+	}
+	if c.FrozenDistance < 0 || c.FrozenDistance > 64 {
+		problems = append(problems, "frames.frozen_distance must be between 0 and 64")
+	}
+	return problems
+}
+
+// FrameAnalysis - характеристики одного кадра
+type FrameAnalysis struct {
+	MeanLuma float64 `json:"mean_luma"`
+	Variance float64 `json:"luma_variance"`
+	Hash     string  `json:"dhash"`
+	Distance int     `json:"distance_to_previous,omitempty"`
+	Flag     string  `json:"flag,omitempty"`
+}
+
+// FrameEvent - подряд идущие кадры с одинаковой проблемой
+type FrameEvent struct {
+	Kind       string    `json:"kind"`
+	Start      time.Time `json:"start"`
+	End        time.Time `json:"end"`
+	FirstFrame string    `json:"first_frame"`
+	LastFrame  string    `json:"last_frame"`
+	Frames     int       `json:"frames"`
+}
+
+type analyzedFrame struct {
+	name  string
+	taken time.Time
+	hash  uint64
+	flag  string
+}
+
+// FrameAnalyzer ищет черные, пустые и замершие кадры среди скриншотов сессии
+type FrameAnalyzer struct {
+	cfg    FrameConfig
+	prev   *analyzedFrame
+	events []FrameEvent
+}
+
+func NewFrameAnalyzer(cfg FrameConfig) *FrameAnalyzer {
+	return &FrameAnalyzer{cfg: cfg, events: []FrameEvent{}}
+}
+
+// Analyze декодирует кадр (PNG или JPEG) и сравнивает его с предыдущим
+func (a *FrameAnalyzer) Analyze(name string, data []byte, takenAt time.Time) (*FrameAnalysis, error) {
+	img, _, err := image.Decode(bytes.NewReader(data))
+	if err != nil {
+		return nil, fmt.Errorf("could not decode %s: %v", name, err)
+	}
+	mean, variance, hash := measureFrame(img)
+
+	result := &FrameAnalysis{
+		MeanLuma: mean,
+		Variance: variance,
+		Hash:     fmt.Sprintf("%016x", hash),
+	}
+	frame := &analyzedFrame{name: name, taken: takenAt, hash: hash}
+
+	// Черный/пустой кадр важнее замершего: два черных кадра тоже совпадут по хешу
+	switch {
+	case mean <= a.cfg.BlackLuma:
+		result.Flag = FrameBlack
+	case variance <= a.cfg.BlankVariance:
+		result.Flag = FrameBlank
+	}
+	if a.prev != nil {
+		result.Distance = bits.OnesCount64(hash ^ a.prev.hash)
+		if result.Flag == "" && a.prev.flag != FrameBlack && a.prev.flag != FrameBlank &&
+			result.Distance <= a.cfg.FrozenDistance {
+			result.Flag = FrameFrozen
+		}
+	}
+	frame.flag = result.Flag
+
+	if result.Flag != "" {
+		a.record(frame)
+	}
+	a.prev = frame
+	return result, nil
+}
+
+// record продлевает текущее событие или открывает новое
+func (a *FrameAnalyzer) record(frame *analyzedFrame) {
+	if n := len(a.events); n > 0 && a.prev != nil && a.prev.flag == frame.flag {
+		last := &a.events[n-1]
+		last.End = frame.taken
+		last.LastFrame = frame.name
+		last.Frames++
+		return
+	}
+
+	event := FrameEvent{
+		Kind:       frame.flag,
+		Start:      frame.taken,
+		End:        frame.taken,
+		FirstFrame: frame.name,
+		LastFrame:  frame.name,
+		Frames:     1,
+	}
+	// Замирание начинается с предыдущего кадра, с которым совпал текущий
+	if frame.flag == FrameFrozen {
+		event.Start = a.prev.taken
+		event.FirstFrame = a.prev.name
+		event.Frames = 2
+	}
+	a.events = append(a.events, event)
+}
+
+func (a *FrameAnalyzer) Events() []FrameEvent {
+	return a.events
+}
+
+// measureFrame считает яркость по сетке не более ~320 точек по ширине
+// и 64-битный difference hash по сетке 9x8
+func measureFrame(img image.Image) (mean, variance float64, hash uint64) {
+	b := img.Bounds()
+	w, h := b.Dx(), b.Dy()
+	if w == 0 || h == 0 {
+		return 0, 0, 0
+	}
+	step := w / 320
+	if step < 1 {
+		step = 1
+	}
+
+	var sum, sumSq float64
+	var n int
+	var cells [8][9]float64
+	var counts [8][9]int
+	for y := b.Min.Y; y < b.Max.Y; y += step {
+		cy := (y - b.Min.Y) * 8 / h
+		for x := b.Min.X; x < b.Max.X; x += step {
+			r, g, bl, _ := img.At(x, y).RGBA()
+			luma := (0.299*float64(r) + 0.587*float64(g) + 0.114*float64(bl)) / 257
+			sum += luma
+			sumSq += luma * luma
+			n++
+
+			cx := (x - b.Min.X) * 9 / w
+			cells[cy][cx] += luma
+			counts[cy][cx]++
+		}
+	}
+
+	mean = sum / float64(n)
+	variance = sumSq/float64(n) - mean*mean
+	if variance < 0 {
+		variance = 0
+	}
+
+	for y := 0; y < 8; y++ {
+		for x := 0; x < 8; x++ {
+			left := cells[y][x] / float64(max(counts[y][x], 1))
+			right := cells[y][x+1] / float64(max(counts[y][x+1], 1))
+			hash <<= 1
+			if left < right {
+				hash |= 1
+			}
+		}
+	}
+	return mean, variance, hash
+}
@@ -0,0 +1,126 @@
+package main
+
+import (
+	"bytes"
+	"image"
+	"image/color"
+	"image/png"
+	"testing"
+	"time"
+)
+
+// Кадры 90x80: одна клетка сетки dHash 9x8 - 10x10 точек
+func uniformFrame(luma uint8) image.Image {
+	img := image.NewGray(image.Rect(0, 0, 90, 80))
+	for i := range img.Pix {
+		img.Pix[i] = luma
+	}
+	return img
+}
+
+// blockFrame - клетки 10x10 псевдослучайной яркости, сдвинутые на shift клеток влево
+func blockFrame(shift int) image.Image {
+	img := image.NewRGBA(image.Rect(0, 0, 90, 80))
+	for y := 0; y < 80; y++ {
+		for x := 0; x < 90; x++ {
+			cell := (x/10+shift)*7 + (y/10)*13
+			v := uint8(cell * 97 % 251)
+			img.SetRGBA(x, y, color.RGBA{v, v, v, 255})
+		}
+	}
+	return img
+}
+
+func encodePNG(t *testing.T, img image.Image) []byte {
+	t.Helper()
+	var buf bytes.Buffer
+	if err := png.Encode(&buf, img); err != nil {
+		t.Fatal(err)
+	}
+	return buf.Bytes()
+}
+
+func TestMeasureFrameHash(t *testing.T) {
+	gradient := func(rising bool) image.Image {
+		img := image.NewGray(image.Rect(0, 0, 90, 80))
+		for y := 0; y < 80; y++ {
+			for x := 0; x < 90; x++ {
+				v := uint8(x * 2)
+				if !rising {
+					v = uint8(178 - x*2)
+				}
+				img.SetGray(x, y, color.Gray{v})
+			}
+		}
+		return img
+	}
+	cases := []struct {
+		name string
+		img  image.Image
+		hash uint64
+	}{
+		{"all black", uniformFrame(0), 0},
+		{"uniform gray", uniformFrame(128), 0},
+		// Каждая клетка темнее правой соседки - все биты 1
+		{"rising gradient", gradient(true), ^uint64(0)},
+		{"falling gradient", gradient(false), 0},
+	}
+	for _, c := range cases {
+		if _, _, hash := measureFrame(c.img); hash != c.hash {
+			t.Errorf("%s: hash %016x, want %016x", c.name, hash, c.hash)
+		}
+	}
+	if mean, variance, _ := measureFrame(uniformFrame(128)); mean < 127.99 || mean > 128.01 || variance > 0.01 {
+		t.Errorf("uniform gray: mean %v, variance %v; want 128, 0", mean, variance)
+	}
+}
+
+func TestFrameAnalyzerFlags(t *testing.T) {
+	start := time.Now()
+	frames := []struct {
+		name string
+		img  image.Image
+		flag string
+	}{
+		{"shot_001.png", uniformFrame(0), FrameBlack},
+		{"shot_002.png", uniformFrame(0), FrameBlack}, // совпадает по хешу, но черный важнее
+		{"shot_003.png", uniformFrame(128), FrameBlank},
+		{"shot_004.png", blockFrame(0), ""}, // после пустого кадра не замирание
+		{"shot_005.png", blockFrame(0), FrameFrozen},
+		{"shot_006.png", blockFrame(1), ""},
+	}
+	a := NewFrameAnalyzer(defaultFrameConfig())
+	var distances []int
+	for i, f := range frames {
+		result, err := a.Analyze(f.name, encodePNG(t, f.img), start.Add(time.Duration(i)*15*time.Second))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if result.Flag != f.flag {
+			t.Errorf("%s: flag %q, want %q (mean %.1f, variance %.1f, distance %d)",
+				f.name, result.Flag, f.flag, result.MeanLuma, result.Variance, result.Distance)
+		}
+		distances = append(distances, result.Distance)
+	}
+	if distances[4] != 0 || distances[5] <= defaultFrameConfig().FrozenDistance {
+		t.Errorf("distances %v: want 0 for the identical pair and above the threshold for the shifted one", distances)
+	}
+
+	want := []FrameEvent{
+		{Kind: FrameBlack, FirstFrame: "shot_001.png", LastFrame: "shot_002.png", Frames: 2},
+		{Kind: FrameBlank, FirstFrame: "shot_003.png", LastFrame: "shot_003.png", Frames: 1},
+		{Kind: FrameFrozen, FirstFrame: "shot_004.png", LastFrame: "shot_005.png", Frames: 2},
+	}
+	events := a.Events()
+	if len(events) != len(want) {
+		t.Fatalf("events = %+v, want %d", events, len(want))
+	}
+	for i, e := range events {
+		if e.Kind != want[i].Kind || e.FirstFrame != want[i].FirstFrame || e.LastFrame != want[i].LastFrame || e.Frames != want[i].Frames {
+			t.Errorf("event %d = %+v, want %+v", i, e, want[i])
+		}
+	}
+	if frozen := events[2]; !frozen.Start.Equal(start.Add(45*time.Second)) || !frozen.End.Equal(start.Add(60*time.Second)) {
+		t.Errorf("frozen event spans %v - %v", frozen.Start.Sub(start), frozen.End.Sub(start))
+	}
+}
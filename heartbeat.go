@@ -0,0 +1,194 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"sync"
+	"time"
+)
+
+// Фазы сессии в пульсе раннера
+const (
+	PhaseStarting  = "starting"  // установка Playwright и запуск Chrome
+	PhaseScenario  = "scenario"  // выполняются шаги сценария
+	PhaseStreaming = "streaming" // сессия идет, скриншоты по интервалу
+	PhaseFinishing = "finishing" // отчет и дозагрузка артефактов
+	PhaseDone      = "done"      // сессия завершена, отчет сохранен
+	PhaseFailed    = "failed"    // раннер завершился с ошибкой до конца сессии
+)
+
+type HeartbeatConfig struct {
+	// URL оркестратора, пульс отправляется POST запросом с JSON; пустой - пульс выключен
+	URL      string        `yaml:"url" json:"url"`
+	Token    string        `yaml:"token" json:"token"`
+	Interval time.Duration `yaml:"interval" json:"interval"`
+}
+
+// Heartbeat - снимок состояния сессии, который раннер периодически отправляет оркестратору
+type Heartbeat struct {
+	SessionID   string        `json:"session_id"`
+	RunID       string        `json:"run_id,omitempty"`
+	InstanceID  string        `json:"instance_id,omitempty"`
+	Phase       string        `json:"phase"`
+	Screenshots int           `json:"screenshots"`
+	Errors      int           `json:"errors"`
+	LastError   string        `json:"last_error,omitempty"`
+	Status      string        `json:"status,omitempty"` // итоговый статус сессии в последнем пульсе
+	Stream      *StreamSample `json:"stream,omitempty"`
+	StartedAt   time.Time     `json:"started_at"`
+	SentAt      time.Time     `json:"sent_at"`
+}
+
+// HeartbeatSender отправляет пульс в фоне. Нулевой *HeartbeatSender (пульс выключен)
+// можно использовать: все методы ничего не делают
+type HeartbeatSender struct {
+	cfg    HeartbeatConfig
+	client *http.Client
+
+	mu      sync.Mutex
+	state   Heartbeat
+	lastErr string
+
+	wake chan struct{}
+	stop chan struct{}
+	done chan struct{}
+}
+
+func NewHeartbeatSender(cfg HeartbeatConfig, report *SessionReport) *HeartbeatSender {
+	if cfg.URL == "" {
+		return nil
+	}
+	return &HeartbeatSender{
+		cfg:    cfg,
+		client: &http.Client{Timeout: 10 * time.Second},
+		state: Heartbeat{
+			SessionID:  report.SessionID,
+			RunID:      report.RunID,
+			InstanceID: report.InstanceID,
+			Phase:      PhaseStarting,
+			StartedAt:  report.StartedAt,
+		},
+		wake: make(chan struct{}, 1),
+		stop: make(chan struct{}),
+		done: make(chan struct{}),
+	}
+}
+
+// Start отправляет первый пульс сразу и дальше раз в интервал
+func (h *HeartbeatSender) Start() {
+	if h == nil {
+		return
+	}
+	log.Printf("Sending heartbeats to %s every %v", h.cfg.URL, h.cfg.Interval)
+	go func() {
+		defer close(h.done)
+		ticker := time.NewTicker(h.cfg.Interval)
+		defer ticker.Stop()
+		for {
+			h.send()
+			select {
+			case <-ticker.C:
+			case <-h.wake:
+			case <-h.stop:
+				return
+			}
+		}
+	}()
+}
+
+// Update обновляет состояние из отчета; смена фазы отправляется без ожидания интервала
+func (h *HeartbeatSender) Update(phase string, report *SessionReport, sample *StreamSample) {
+	if h == nil {
+		return
+	}
+	h.mu.Lock()
+	changed := phase != "" && phase != h.state.Phase
+	if phase != "" {
+		h.state.Phase = phase
+	}
+	h.state.Screenshots = len(report.Screenshots)
+	h.state.Errors = len(report.Errors)
+	if n := len(report.Errors); n > 0 {
+		last := report.Errors[n-1]
+		h.state.LastError = last.Phase + ": " + last.Message
+	}
+	if sample != nil {
+		h.state.Stream = sample
+	}
+	h.mu.Unlock()
+	if changed {
+		select {
+		case h.wake <- struct{}{}:
+		default:
+		}
+	}
+}
+
+// Stop останавливает фон и синхронно отправляет последний пульс с итоговой фазой
+func (h *HeartbeatSender) Stop(phase, status, lastError string) {
+	if h == nil {
+		return
+	}
+	close(h.stop)
+	<-h.done
+	h.mu.Lock()
+	h.state.Phase = phase
+	h.state.Status = status
+	if lastError != "" {
+		h.state.LastError = lastError
+	}
+	h.mu.Unlock()
+	h.send()
+}
+
+func (h *HeartbeatSender) send() {
+	h.mu.Lock()
+	beat := h.state
+	beat.SentAt = time.Now()
+	h.mu.Unlock()
+
+	err := h.post(beat)
+	// Пишем в лог только смену результата, чтобы недоступный оркестратор не забивал лог
+	msg := ""
+	if err != nil {
+		msg = err.Error()
+	}
+	if msg != h.lastErr {
+		if err != nil {
+			log.Printf("Could not send heartbeat: %v", err)
+		} else {
+			log.Println("Heartbeats are delivered again")
+		}
+		h.lastErr = msg
+	}
+}
+
+func (h *HeartbeatSender) post(beat Heartbeat) error {
+	body, err := json.Marshal(beat)
+	if err != nil {
+		return err
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), h.client.Timeout)
+	defer cancel()
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, h.cfg.URL, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	if h.cfg.Token != "" {
+		req.Header.Set("Authorization", "Bearer "+h.cfg.Token)
+	}
+	resp, err := h.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode/100 != 2 {
+		return fmt.Errorf("orchestrator returned %s", resp.Status)
+	}
+	return nil
+}
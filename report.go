@@ -0,0 +1,291 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"strings"
+	"time"
+)
+
+const (
+	StatusSuccess  = "success"
+	StatusDegraded = "degraded"
+	StatusFailed   = "failed"
+	StatusAborted  = "aborted"
+)
+
+// Коды причины статуса: стабильны между сессиями, подробности с числами - в StatusReason
+const (
+	ReasonInterrupted    = "interrupted"
+	ReasonScenarioFailed = "scenario_failed"
+	ReasonNoScreenshots  = "no_screenshots"
+	ReasonNoPicture      = "no_picture"
+	ReasonErrors         = "errors"
+	ReasonStreamProblems = "stream_problems"
+)
+
+// Источник времени до первого кадра
+const (
+	FirstFrameScreenshot = "screenshot"
+	FirstFrameWebRTC     = "webrtc"
+)
+
+const (
+	reportJSONName     = "session_report.json"
+	reportMarkdownName = "session_report.md"
+)
+
+type ScreenshotInfo struct {
+	Name      string         `json:"name"`
+	TakenAt   time.Time      `json:"taken_at"`
+	SizeBytes int            `json:"size_bytes"`
+	Frame     *FrameAnalysis `json:"frame,omitempty"`
+}
+
+type ReportError struct {
+	Time    time.Time `json:"time"`
+	Phase   string    `json:"phase"`
+	Message string    `json:"message"`
+}
+
+// SessionReport - единый источник данных для session_report.json и session_report.md
+type SessionReport struct {
+	SessionID        string           `json:"session_id"`
+	RunID            string           `json:"run_id,omitempty"`
+	InstanceID       string           `json:"instance_id,omitempty"`
+	TargetURL        string           `json:"target_url"`
+	BrowserVersion   string           `json:"browser_version,omitempty"`
+	GPU              string           `json:"gpu,omitempty"`
+	StartedAt        time.Time        `json:"started_at"`
+	EndedAt          time.Time        `json:"ended_at"`
+	DurationSeconds  float64          `json:"duration_seconds"`
+	Status           string           `json:"status"`
+	StatusCode       string           `json:"status_code,omitempty"`
+	StatusReason     string           `json:"status_reason,omitempty"`
+	TimeToFirstFrame *float64         `json:"time_to_first_frame_seconds,omitempty"`
+	FirstFrameSource string           `json:"first_frame_source,omitempty"`
+	Stream           *StreamSummary   `json:"stream,omitempty"`
+	Steps            []StepResult     `json:"steps"`
+	Screenshots      []ScreenshotInfo `json:"screenshots"`
+	FrameEvents      []FrameEvent     `json:"frame_events"`
+	Errors           []ReportError    `json:"errors"`
+
+	scenarioFailed bool
+	aborted        bool
+}
+
+func NewSessionReport(sessionID, instanceID, targetURL string) *SessionReport {
+	return &SessionReport{
+		SessionID:   sessionID,
+		InstanceID:  instanceID,
+		TargetURL:   targetURL,
+		StartedAt:   time.Now(),
+		Steps:       []StepResult{},
+		Screenshots: []ScreenshotInfo{},
+		FrameEvents: []FrameEvent{},
+		Errors:      []ReportError{},
+	}
+}
+
+// AddScreenshot регистрирует скриншот; frame - результат анализа кадра или nil
+func (r *SessionReport) AddScreenshot(name string, size int, frame *FrameAnalysis) {
+	r.Screenshots = append(r.Screenshots, ScreenshotInfo{Name: name, TakenAt: time.Now(), SizeBytes: size, Frame: frame})
+}
+
+func (r *SessionReport) AddError(phase string, err error) {
+	r.Errors = append(r.Errors, ReportError{Time: time.Now(), Phase: phase, Message: err.Error()})
+}
+
+// SetScenario сохраняет результаты шагов; err - ошибка обязательного шага, если был
+func (r *SessionReport) SetScenario(steps []StepResult, err error) {
+	r.Steps = steps
+	if err != nil {
+		r.scenarioFailed = true
+		r.AddError("scenario", err)
+	}
+}
+
+func (r *SessionReport) MarkAborted() {
+	r.aborted = true
+}
+
+// Finish фиксирует время окончания и вычисляет итоговый статус
+func (r *SessionReport) Finish() {
+	r.EndedAt = time.Now()
+	r.DurationSeconds = r.EndedAt.Sub(r.StartedAt).Seconds()
+
+	failedSteps := 0
+	var navigationStart time.Time
+	for _, step := range r.Steps {
+		if step.Status == StepStatusFailed {
+			failedSteps++
+		}
+		if step.Action == StepGoto && navigationStart.IsZero() {
+			navigationStart = step.StartedAt
+		}
+	}
+	analyzed, badFrames := 0, 0
+	for _, shot := range r.Screenshots {
+		if shot.Frame == nil {
+			continue
+		}
+		analyzed++
+		if shot.Frame.Flag == FrameBlack || shot.Frame.Flag == FrameBlank {
+			badFrames++
+		}
+	}
+	frameKinds := make(map[string]int)
+	for _, e := range r.FrameEvents {
+		frameKinds[e.Kind]++
+	}
+
+	r.TimeToFirstFrame, r.FirstFrameSource = nil, ""
+	if at, source := r.firstFrame(); !navigationStart.IsZero() && !at.IsZero() {
+		ttff := at.Sub(navigationStart).Seconds()
+		r.TimeToFirstFrame, r.FirstFrameSource = &ttff, source
+	}
+
+	switch {
+	case r.aborted:
+		r.setStatus(StatusAborted, ReasonInterrupted, "session interrupted by signal")
+	case r.scenarioFailed:
+		r.setStatus(StatusFailed, ReasonScenarioFailed, "a required scenario step failed")
+	case len(r.Screenshots) == 0:
+		r.setStatus(StatusFailed, ReasonNoScreenshots, "no screenshots captured")
+	case analyzed > 0 && badFrames == analyzed:
+		r.setStatus(StatusFailed, ReasonNoPicture, "stream never rendered: all frames black or blank")
+	case failedSteps > 0 || len(r.Errors) > 0:
+		r.setStatus(StatusDegraded, ReasonErrors, fmt.Sprintf("%d failed optional steps, %d errors", failedSteps, len(r.Errors)))
+	case len(r.FrameEvents) > 0:
+		r.setStatus(StatusDegraded, ReasonStreamProblems, fmt.Sprintf("stream problems: %d black, %d blank, %d frozen periods",
+			frameKinds[FrameBlack], frameKinds[FrameBlank], frameKinds[FrameFrozen]))
+	default:
+		r.setStatus(StatusSuccess, "", "")
+	}
+}
+
+func (r *SessionReport) setStatus(status, code, reason string) {
+	r.Status, r.StatusCode, r.StatusReason = status, code, reason
+}
+
+// firstFrame - время первого кадра с изображением: первый проанализированный скриншот, который
+// не черный, или первая точка WebRTC с декодированными кадрами, что раньше. Скриншот без анализа
+// кадром не считается: на нем может быть страница загрузки
+func (r *SessionReport) firstFrame() (time.Time, string) {
+	var at time.Time
+	source := ""
+	for _, shot := range r.Screenshots {
+		if shot.Frame != nil && shot.Frame.Flag != FrameBlack {
+			at, source = shot.TakenAt, FirstFrameScreenshot
+			break
+		}
+	}
+	if r.Stream != nil && r.Stream.FirstFrameAt != nil && (at.IsZero() || r.Stream.FirstFrameAt.Before(at)) {
+		at, source = *r.Stream.FirstFrameAt, FirstFrameWebRTC
+	}
+	return at, source
+}
+
+func (r *SessionReport) JSON() ([]byte, error) {
+	return json.MarshalIndent(r, "", "  ")
+}
+
+func (r *SessionReport) Markdown() string {
+	var b strings.Builder
+
+	fmt.Fprintf(&b, `# Playwright Session Report
+
+## Session Info
+- Session ID: %s
+`, r.SessionID)
+	if r.RunID != "" {
+		fmt.Fprintf(&b, "- Run ID: %s\n", r.RunID)
+	}
+	if r.InstanceID != "" {
+		fmt.Fprintf(&b, "- Instance ID: %s\n", r.InstanceID)
+	}
+	fmt.Fprintf(&b, `- Start Time: %s
+- End Time: %s
+- Duration: %s
+- URL: %s
+`, r.StartedAt.Format("2006-01-02 15:04:05"), r.EndedAt.Format("2006-01-02 15:04:05"),
+		r.EndedAt.Sub(r.StartedAt).Round(time.Second), r.TargetURL)
+	if r.BrowserVersion != "" {
+		fmt.Fprintf(&b, "- Browser: %s\n", r.BrowserVersion)
+	}
+	if r.GPU != "" {
+		fmt.Fprintf(&b, "- GPU: %s\n", r.GPU)
+	}
+	if r.TimeToFirstFrame != nil {
+		fmt.Fprintf(&b, "- Time to first frame: %.1fs (%s)\n", *r.TimeToFirstFrame, r.FirstFrameSource)
+	} else {
+		b.WriteString("- Time to first frame: no frame with a picture\n")
+	}
+	fmt.Fprintf(&b, "- Status: %s\n", r.Status)
+
+	if st := r.Stream; st != nil {
+		b.WriteString("\n## Stream Quality\n")
+		if st.VideoSamples == 0 {
+			fmt.Fprintf(&b, "- No WebRTC video stream detected (%d polls, %d errors)\n", st.Samples, st.PollErrors)
+		} else {
+			fmt.Fprintf(&b, "- FPS: avg %.1f, min %.1f\n", st.AvgFPS, st.MinFPS)
+			fmt.Fprintf(&b, "- Frames: %d decoded, %d dropped (%.2f%%)\n", st.FramesDecoded, st.FramesDropped, st.DroppedFramesPct)
+			fmt.Fprintf(&b, "- Bitrate: avg %.0f kbps\n", st.AvgBitrateKbps)
+			fmt.Fprintf(&b, "- Jitter: avg %.1f ms, max %.1f ms\n", st.AvgJitterMs, st.MaxJitterMs)
+			fmt.Fprintf(&b, "- RTT: avg %.1f ms, max %.1f ms\n", st.AvgRTTMs, st.MaxRTTMs)
+			fmt.Fprintf(&b, "- Packet loss: %.2f%%\n", st.PacketLossPct)
+			if st.Resolution != "" {
+				fmt.Fprintf(&b, "- Resolution: %s (%d changes)\n", st.Resolution, len(st.ResolutionChanges)-1)
+			}
+		}
+	}
+
+	b.WriteString("\n## Artifacts\n")
+	for i, shot := range r.Screenshots {
+		fmt.Fprintf(&b, "- Screenshot %d: %s (%s, %d KB)", i+1, shot.Name, shot.TakenAt.Format("15:04:05"), shot.SizeBytes/1024)
+		if shot.Frame != nil && shot.Frame.Flag != "" {
+			fmt.Fprintf(&b, " — %s", shot.Frame.Flag)
+		}
+		b.WriteString("\n")
+	}
+
+	if len(r.FrameEvents) > 0 {
+		b.WriteString("\n## Frame Events\n")
+		for _, e := range r.FrameEvents {
+			fmt.Fprintf(&b, "- %s: %s – %s, %d frames (%s … %s)\n", e.Kind, e.Start.Format("15:04:05"),
+				e.End.Format("15:04:05"), e.Frames, e.FirstFrame, e.LastFrame)
+		}
+	}
+
+	if len(r.Steps) > 0 {
+		b.WriteString("\n## Scenario\n")
+		for _, step := range r.Steps {
+			fmt.Fprintf(&b, "- [%s] %s: %s (%s)", step.Index, step.Name, step.Status, step.Duration.Round(time.Millisecond))
+			if step.Output != "" {
+				fmt.Fprintf(&b, " → %s", step.Output)
+			}
+			if step.Error != "" {
+				fmt.Fprintf(&b, " — %s", step.Error)
+			}
+			b.WriteString("\n")
+		}
+	}
+
+	if len(r.Errors) > 0 {
+		b.WriteString("\n## Errors\n")
+		for _, e := range r.Errors {
+			fmt.Fprintf(&b, "- %s [%s] %s\n", e.Time.Format("15:04:05"), e.Phase, e.Message)
+		}
+	}
+
+	switch r.Status {
+	case StatusSuccess:
+		b.WriteString("\n## Session completed successfully\n")
+	case StatusAborted:
+		b.WriteString("\n## Session aborted before completion (partial results)\n")
+	default:
+		fmt.Fprintf(&b, "\n## Session %s: %s\n", r.Status, r.StatusReason)
+	}
+
+	return b.String()
+}
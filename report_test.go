@@ -0,0 +1,74 @@
+package main
+
+import (
+	"testing"
+	"time"
+)
+
+func TestFinishTimeToFirstFrame(t *testing.T) {
+	start := time.Now()
+	at := func(s float64) time.Time { return start.Add(time.Duration(s * float64(time.Second))) }
+	newReport := func() *SessionReport {
+		r := NewSessionReport("session_test", "1", "https://example.com")
+		r.Steps = []StepResult{{Action: StepGoto, Status: StepStatusPassed, StartedAt: start}}
+		return r
+	}
+
+	// Черные кадры не в счет, считаем от первого кадра с изображением
+	r := newReport()
+	r.Screenshots = []ScreenshotInfo{
+		{Name: "shot_001.png", TakenAt: at(2), Frame: &FrameAnalysis{Flag: FrameBlack}},
+		{Name: "shot_002.png", TakenAt: at(5), Frame: &FrameAnalysis{Flag: FrameFrozen}},
+	}
+	r.Finish()
+	if r.TimeToFirstFrame == nil || *r.TimeToFirstFrame != 5 || r.FirstFrameSource != FirstFrameScreenshot {
+		t.Fatalf("TTFF = %v (%s), want 5s from a screenshot", r.TimeToFirstFrame, r.FirstFrameSource)
+	}
+
+	// Декодированный WebRTC кадр раньше скриншота
+	decoded := at(3)
+	r.Stream = &StreamSummary{FirstFrameAt: &decoded}
+	r.Finish()
+	if r.TimeToFirstFrame == nil || *r.TimeToFirstFrame != 3 || r.FirstFrameSource != FirstFrameWebRTC {
+		t.Fatalf("TTFF = %v (%s), want 3s from WebRTC", r.TimeToFirstFrame, r.FirstFrameSource)
+	}
+
+	// Только черные кадры и без WebRTC: времени нет, а не время первого скриншота
+	r = newReport()
+	r.Screenshots = []ScreenshotInfo{{Name: "shot_001.png", TakenAt: at(2), Frame: &FrameAnalysis{Flag: FrameBlack}}}
+	r.Stream = &StreamSummary{}
+	r.Finish()
+	if r.TimeToFirstFrame != nil || r.FirstFrameSource != "" {
+		t.Fatalf("TTFF = %v (%s), want none", r.TimeToFirstFrame, r.FirstFrameSource)
+	}
+	if r.Status != StatusFailed || r.StatusCode != ReasonNoPicture {
+		t.Fatalf("status = %s/%s, want %s/%s", r.Status, r.StatusCode, StatusFailed, ReasonNoPicture)
+	}
+}
+
+func TestSummarizeBucketsFailuresByCode(t *testing.T) {
+	ttff := 4.0
+	sessions := []aggregatedSession{
+		{Status: StatusDegraded, StatusCode: ReasonErrors, StatusReason: "1 failed optional steps, 2 errors", TimeToFirstFrame: &ttff},
+		{Status: StatusDegraded, StatusCode: ReasonErrors, StatusReason: "0 failed optional steps, 5 errors"},
+		{Status: StatusFailed, StatusCode: reasonMissingReport, StatusReason: "missing session report"},
+		{Status: StatusFailed, StatusReason: "report without a code"},
+		{Status: StatusSuccess},
+	}
+	g := summarize("all", sessions)
+	want := map[string]int{ReasonErrors: 2, reasonMissingReport: 1, reasonUnspecified: 1}
+	if len(g.FailureReasons) != len(want) {
+		t.Fatalf("failure reasons = %v, want %v", g.FailureReasons, want)
+	}
+	for code, n := range want {
+		if g.FailureReasons[code] != n {
+			t.Fatalf("failure reasons = %v, want %v", g.FailureReasons, want)
+		}
+	}
+	if g.MedianTimeToFirstFrame == nil || *g.MedianTimeToFirstFrame != ttff {
+		t.Fatalf("median TTFF = %v, want %v from the only session that has one", g.MedianTimeToFirstFrame, ttff)
+	}
+	if g := summarize("none", sessions[1:]); g.MedianTimeToFirstFrame != nil || g.P95TimeToFirstFrame != nil {
+		t.Fatal("TTFF percentiles are set for sessions without a first frame")
+	}
+}
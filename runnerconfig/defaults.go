@@ -0,0 +1,10 @@
+// Package runnerconfig - умолчания конфига сессии раннера, общие для раннера и оркестратора
+package runnerconfig
+
+import "time"
+
+const (
+	DefaultURL                = "https://x.la/cgs/1754888695/play"
+	DefaultDuration           = 5 * time.Minute
+	DefaultScreenshotInterval = 15 * time.Second
+)
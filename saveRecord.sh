@@ -1,8 +1,12 @@
 #!/bin/bash
 
-HOST="kryuchenko.org"
-USER="storage"
-PASS="storage123"
+HOST="$HLT_STORAGE_HOST"
+USER="$HLT_STORAGE_USER"
+PASS="$HLT_STORAGE_PASSWORD"
+if [ -z "$HOST" ] || [ -z "$USER" ] || [ -z "$PASS" ]; then
+    echo "Error: set HLT_STORAGE_HOST, HLT_STORAGE_USER and HLT_STORAGE_PASSWORD"
+    exit 1
+fi
 mkdir -p ~/.ssh
 chmod 700 ~/.ssh
 ssh-keyscan -H $HOST >> ~/.ssh/known_hosts
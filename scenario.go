@@ -0,0 +1,423 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"log"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/playwright-community/playwright-go"
+	"gopkg.in/yaml.v3"
+)
+
+const (
+	StepGoto          = "goto"
+	StepWaitFor       = "wait_for"
+	StepClick         = "click"
+	StepType          = "type"
+	StepPress         = "press"
+	StepSleep         = "sleep"
+	StepScreenshot    = "screenshot"
+	StepAssertText    = "assert_text"
+	StepAssertVisible = "assert_visible"
+	StepEvaluate      = "evaluate"
+	StepLoop          = "loop"
+)
+
+const (
+	StepStatusPassed  = "passed"
+	StepStatusFailed  = "failed"
+	StepStatusSkipped = "skipped"
+)
+
+type ScenarioStep struct {
+	Name            string         `yaml:"name,omitempty" json:"name,omitempty"`
+	Action          string         `yaml:"action" json:"action"`
+	URL             string         `yaml:"url,omitempty" json:"url,omitempty"`
+	Selector        string         `yaml:"selector,omitempty" json:"selector,omitempty"`
+	Text            string         `yaml:"text,omitempty" json:"text,omitempty"`
+	Key             string         `yaml:"key,omitempty" json:"key,omitempty"`
+	Script          string         `yaml:"script,omitempty" json:"script,omitempty"`
+	Duration        time.Duration  `yaml:"duration,omitempty" json:"duration,omitempty"`
+	Timeout         time.Duration  `yaml:"timeout,omitempty" json:"timeout,omitempty"`
+	Times           int            `yaml:"times,omitempty" json:"times,omitempty"`
+	Steps           []ScenarioStep `yaml:"steps,omitempty" json:"steps,omitempty"`
+	ContinueOnError bool           `yaml:"continue_on_error,omitempty" json:"continue_on_error,omitempty"`
+}
+
+type StepResult struct {
+	Index     string        `json:"index"`
+	Name      string        `json:"name"`
+	Action    string        `json:"action"`
+	Status    string        `json:"status"`
+	StartedAt time.Time     `json:"started_at"`
+	Duration  time.Duration `json:"duration_ns"`
+	Output    string        `json:"output,omitempty"`
+	Error     string        `json:"error,omitempty"`
+}
+
+func (s ScenarioStep) label() string {
+	if s.Name != "" {
+		return s.Name
+	}
+	switch {
+	case s.Selector != "":
+		return fmt.Sprintf("%s %s", s.Action, s.Selector)
+	case s.URL != "":
+		return fmt.Sprintf("%s %s", s.Action, s.URL)
+	case s.Key != "":
+		return fmt.Sprintf("%s %s", s.Action, s.Key)
+	}
+	return s.Action
+}
+
+// defaultScenario повторяет исходный сценарий: открыть страницу и принять GDPR
+func defaultScenario(cfg SessionConfig) []ScenarioStep {
+	steps := []ScenarioStep{{Name: "open target", Action: StepGoto}}
+	if cfg.ConsentSelector != "" {
+		steps = append(steps, ScenarioStep{
+			Name:            "accept GDPR consent",
+			Action:          StepClick,
+			Selector:        cfg.ConsentSelector,
+			Timeout:         cfg.ConsentTimeout,
+			ContinueOnError: true,
+		})
+	}
+	return steps
+}
+
+func loadScenarioFile(path string) ([]ScenarioStep, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read scenario %s: %v", path, err)
+	}
+
+	// Файл может быть либо списком шагов, либо объектом с ключом steps; формат выбирается
+	// по корневому узлу, чтобы ошибка относилась к нему, а не к другому формату
+	var root yaml.Node
+	if err := yaml.Unmarshal(data, &root); err != nil {
+		return nil, fmt.Errorf("failed to parse scenario %s: %v", filepath.Base(path), err)
+	}
+	if len(root.Content) == 0 {
+		return nil, fmt.Errorf("scenario %s is empty", filepath.Base(path))
+	}
+
+	var wrapped struct {
+		Steps []ScenarioStep `yaml:"steps"`
+	}
+	var target interface{}
+	switch root.Content[0].Kind {
+	case yaml.SequenceNode:
+		target = &wrapped.Steps
+	case yaml.MappingNode:
+		target = &wrapped
+	default:
+		return nil, fmt.Errorf("failed to parse scenario %s: expected a list of steps or an object with steps", filepath.Base(path))
+	}
+	decoder := yaml.NewDecoder(bytes.NewReader(data))
+	decoder.KnownFields(true)
+	if err := decoder.Decode(target); err != nil {
+		return nil, fmt.Errorf("failed to parse scenario %s: %v", filepath.Base(path), err)
+	}
+	return wrapped.Steps, nil
+}
+
+func validateScenario(steps []ScenarioStep, prefix string) []string {
+	var problems []string
+	for i, step := range steps {
+		where := fmt.Sprintf("scenario step %s%d (%s)", prefix, i+1, step.label())
+		switch step.Action {
+		case StepGoto, StepScreenshot:
+		case StepWaitFor, StepClick, StepAssertVisible:
+			if step.Selector == "" {
+				problems = append(problems, where+": selector is required")
+			}
+		case StepType:
+			if step.Selector == "" {
+				problems = append(problems, where+": selector is required")
+			}
+			if step.Text == "" {
+				problems = append(problems, where+": text is required")
+			}
+		case StepPress:
+			if step.Key == "" {
+				problems = append(problems, where+": key is required")
+			}
+		case StepSleep:
+			if step.Duration <= 0 {
+				problems = append(problems, where+": duration must be positive")
+			}
+		case StepAssertText:
+			if step.Text == "" {
+				problems = append(problems, where+": text is required")
+			}
+		case StepEvaluate:
+			if step.Script == "" {
+				problems = append(problems, where+": script is required")
+			}
+		case StepLoop:
+			if step.Times <= 0 {
+				problems = append(problems, where+": times must be positive")
+			}
+			if len(step.Steps) == 0 {
+				problems = append(problems, where+": steps are required")
+			}
+			problems = append(problems, validateScenario(step.Steps, fmt.Sprintf("%s%d.", prefix, i+1))...)
+		case "":
+			problems = append(problems, where+": action is required")
+		default:
+			problems = append(problems, fmt.Sprintf("%s: unknown action %q", where, step.Action))
+		}
+		if step.Timeout < 0 {
+			problems = append(problems, where+": timeout must not be negative")
+		}
+	}
+	return problems
+}
+
+// SaveArtifactFunc сохраняет артефакт сессии в хранилище под указанным именем
+type SaveArtifactFunc func(name string, data []byte) error
+
+type ScenarioRunner struct {
+	page           playwright.Page
+	baseURL        string
+	defaultTimeout time.Duration
+	saveScreenshot SaveArtifactFunc
+
+	screenshotCount int
+	Results         []StepResult
+}
+
+func NewScenarioRunner(page playwright.Page, cfg SessionConfig, saveScreenshot SaveArtifactFunc) *ScenarioRunner {
+	return &ScenarioRunner{
+		page:           page,
+		baseURL:        cfg.URL,
+		defaultTimeout: cfg.StepTimeout,
+		saveScreenshot: saveScreenshot,
+	}
+}
+
+// Run выполняет шаги по порядку; возвращает ошибку первого обязательного шага, который упал
+func (r *ScenarioRunner) Run(ctx context.Context, steps []ScenarioStep) error {
+	return r.runSteps(ctx, steps, "")
+}
+
+func (r *ScenarioRunner) runSteps(ctx context.Context, steps []ScenarioStep, prefix string) error {
+	for i, step := range steps {
+		index := fmt.Sprintf("%s%d", prefix, i+1)
+
+		// При отмене сессии оставшиеся шаги не выполняем
+		if ctx.Err() != nil {
+			r.skipRemaining(steps[i:], prefix, i+1)
+			return fmt.Errorf("scenario aborted before step %s: %v", index, ctx.Err())
+		}
+
+		if step.Action == StepLoop {
+			if err := r.runLoop(ctx, step, index); err != nil {
+				r.skipRemaining(steps[i+1:], prefix, i+2)
+				return err
+			}
+			continue
+		}
+
+		result := StepResult{
+			Index:     index,
+			Name:      step.label(),
+			Action:    step.Action,
+			StartedAt: time.Now(),
+		}
+		log.Printf("Step %s: %s", index, result.Name)
+
+		output, err := r.runStep(ctx, step)
+		result.Duration = time.Since(result.StartedAt)
+		result.Output = output
+		if err != nil {
+			result.Status = StepStatusFailed
+			result.Error = err.Error()
+			log.Printf("Step %s failed after %v: %v", index, result.Duration.Round(time.Millisecond), err)
+		} else {
+			result.Status = StepStatusPassed
+		}
+		r.Results = append(r.Results, result)
+
+		if err != nil && !step.ContinueOnError {
+			r.skipRemaining(steps[i+1:], prefix, i+2)
+			return fmt.Errorf("step %s (%s) failed: %v", index, result.Name, err)
+		}
+	}
+	return nil
+}
+
+func (r *ScenarioRunner) runLoop(ctx context.Context, step ScenarioStep, index string) error {
+	for iter := 1; iter <= step.Times; iter++ {
+		log.Printf("Step %s: %s, iteration %d/%d", index, step.label(), iter, step.Times)
+		err := r.runSteps(ctx, step.Steps, fmt.Sprintf("%s.%d.", index, iter))
+		if ctx.Err() != nil {
+			return err
+		}
+		if err != nil && !step.ContinueOnError {
+			return err
+		}
+	}
+	return nil
+}
+
+func (r *ScenarioRunner) skipRemaining(steps []ScenarioStep, prefix string, start int) {
+	for i, step := range steps {
+		r.Results = append(r.Results, StepResult{
+			Index:  fmt.Sprintf("%s%d", prefix, start+i),
+			Name:   step.label(),
+			Action: step.Action,
+			Status: StepStatusSkipped,
+		})
+	}
+}
+
+func (r *ScenarioRunner) timeoutFor(step ScenarioStep) time.Duration {
+	if step.Timeout > 0 {
+		return step.Timeout
+	}
+	return r.defaultTimeout
+}
+
+func (r *ScenarioRunner) runStep(ctx context.Context, step ScenarioStep) (string, error) {
+	timeout := r.timeoutFor(step)
+	ms := playwright.Float(float64(timeout.Milliseconds()))
+
+	switch step.Action {
+	case StepGoto:
+		target, err := r.resolveURL(step.URL)
+		if err != nil {
+			return "", err
+		}
+		_, err = r.page.Goto(target, playwright.PageGotoOptions{Timeout: ms})
+		return target, err
+
+	case StepWaitFor:
+		return "", r.page.Locator(step.Selector).WaitFor(playwright.LocatorWaitForOptions{Timeout: ms})
+
+	case StepClick:
+		return "", r.page.Locator(step.Selector).Click(playwright.LocatorClickOptions{Timeout: ms})
+
+	case StepType:
+		return "", r.page.Locator(step.Selector).PressSequentially(step.Text, playwright.LocatorPressSequentiallyOptions{Timeout: ms})
+
+	case StepPress:
+		if step.Selector != "" {
+			return "", r.page.Locator(step.Selector).Press(step.Key, playwright.LocatorPressOptions{Timeout: ms})
+		}
+		return "", r.page.Keyboard().Press(step.Key)
+
+	case StepSleep:
+		select {
+		case <-time.After(step.Duration):
+			return "", nil
+		case <-ctx.Done():
+			return "", ctx.Err()
+		}
+
+	case StepScreenshot:
+		r.screenshotCount++
+		name := fmt.Sprintf("step_%d.png", r.screenshotCount)
+		if step.Name != "" {
+			name = fmt.Sprintf("step_%d_%s.png", r.screenshotCount, sanitizeFileName(step.Name))
+		}
+		data, err := r.page.Screenshot(playwright.PageScreenshotOptions{Timeout: ms})
+		if err != nil {
+			return "", err
+		}
+		if err := r.saveScreenshot(name, data); err != nil {
+			return "", fmt.Errorf("could not save %s: %v", name, err)
+		}
+		return name, nil
+
+	case StepAssertText:
+		var text string
+		var err error
+		if step.Selector != "" {
+			text, err = r.page.Locator(step.Selector).TextContent(playwright.LocatorTextContentOptions{Timeout: ms})
+		} else {
+			text, err = r.page.Locator("body").TextContent(playwright.LocatorTextContentOptions{Timeout: ms})
+		}
+		if err != nil {
+			return "", err
+		}
+		if !strings.Contains(text, step.Text) {
+			return "", fmt.Errorf("text %q not found", step.Text)
+		}
+		return "", nil
+
+	case StepAssertVisible:
+		err := r.page.Locator(step.Selector).WaitFor(playwright.LocatorWaitForOptions{
+			State:   playwright.WaitForSelectorStateVisible,
+			Timeout: ms,
+		})
+		if err != nil {
+			return "", fmt.Errorf("element %s is not visible: %v", step.Selector, err)
+		}
+		return "", nil
+
+	case StepEvaluate:
+		return r.evaluate(step.Script, timeout)
+	}
+
+	return "", fmt.Errorf("unknown action %q", step.Action)
+}
+
+func (r *ScenarioRunner) evaluate(script string, timeout time.Duration) (string, error) {
+	value, err := evaluateWithTimeout(r.page, script, timeout)
+	if err != nil || value == nil {
+		return "", err
+	}
+	return fmt.Sprintf("%v", value), nil
+}
+
+// evaluateWithTimeout: Page.Evaluate не поддерживает таймаут в Playwright, поэтому ограничиваем его сами
+func evaluateWithTimeout(page playwright.Page, script string, timeout time.Duration) (interface{}, error) {
+	type evalResult struct {
+		value interface{}
+		err   error
+	}
+	done := make(chan evalResult, 1)
+	go func() {
+		value, err := page.Evaluate(script)
+		done <- evalResult{value, err}
+	}()
+
+	select {
+	case res := <-done:
+		return res.value, res.err
+	case <-time.After(timeout):
+		return nil, fmt.Errorf("evaluate timed out after %v", timeout)
+	}
+}
+
+func (r *ScenarioRunner) resolveURL(target string) (string, error) {
+	if target == "" {
+		return r.baseURL, nil
+	}
+	base, err := url.Parse(r.baseURL)
+	if err != nil {
+		return "", err
+	}
+	ref, err := url.Parse(target)
+	if err != nil {
+		return "", fmt.Errorf("invalid url %q: %v", target, err)
+	}
+	return base.ResolveReference(ref).String(), nil
+}
+
+func sanitizeFileName(name string) string {
+	return strings.Map(func(r rune) rune {
+		switch {
+		case r >= 'a' && r <= 'z', r >= 'A' && r <= 'Z', r >= '0' && r <= '9', r == '-', r == '_':
+			return r
+		}
+		return '_'
+	}, name)
+}
@@ -0,0 +1,39 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestLoadScenarioFileReportsErrorsOfItsFormat(t *testing.T) {
+	cases := []struct {
+		name, data, wantErr string
+		wantSteps           int
+	}{
+		{"list", "- action: goto\n- action: click\n  selector: '#play'\n", "", 2},
+		{"object", "steps:\n  - action: goto\n", "", 1},
+		{"list with unknown field", "- action: click\n  selectr: '#play'\n", "field selectr not found", 0},
+		{"object with unknown field", "steps:\n  - action: goto\n    urll: https://example.com\n", "field urll not found", 0},
+		{"object with unknown key", "stepz:\n  - action: goto\n", "field stepz not found", 0},
+		{"scalar", "goto\n", "expected a list of steps or an object with steps", 0},
+		{"empty", "", "is empty", 0},
+	}
+	for _, c := range cases {
+		path := filepath.Join(t.TempDir(), "scenario.yaml")
+		if err := os.WriteFile(path, []byte(c.data), 0644); err != nil {
+			t.Fatal(err)
+		}
+		steps, err := loadScenarioFile(path)
+		if c.wantErr == "" {
+			if err != nil || len(steps) != c.wantSteps {
+				t.Errorf("%s: %d steps, err %v; want %d steps", c.name, len(steps), err, c.wantSteps)
+			}
+			continue
+		}
+		if err == nil || !strings.Contains(err.Error(), c.wantErr) {
+			t.Errorf("%s: err = %v, want %q", c.name, err, c.wantErr)
+		}
+	}
+}
@@ -20,12 +20,16 @@ export DISPLAY=:20
 INSTANCE_ID=${1:-"unknown"}
 log "Instance ID: $INSTANCE_ID"
 
+# Run ID ties all sessions of one pool creation together
+RUN_ID=${2:-""}
+log "Run ID: ${RUN_ID:-none}"
+
 # Install required packages with detailed logging
 log "Updating package lists..."
 sudo apt update 2>&1 | tee -a /tmp/test_startup.log
 
-log "Installing Chrome, SSHFS, and LFTP..."
-if sudo apt install -y google-chrome-stable sshfs lftp 2>&1 | tee -a /tmp/test_startup.log; then
+log "Installing Chrome..."
+if sudo apt install -y google-chrome-stable 2>&1 | tee -a /tmp/test_startup.log; then
     log "Dependencies installed successfully"
 else
     log "ERROR: Failed to install dependencies"
@@ -40,13 +44,14 @@ else
     exit 1
 fi
 
-# Enable fuse module for SSHFS
-log "Enabling FUSE module..."
-if sudo modprobe fuse 2>&1 | tee -a /tmp/test_startup.log; then
-    log "FUSE module enabled"
-else
-    log "WARNING: Failed to enable FUSE module"
+# Artifact storage comes from the session config or HLT_STORAGE_* variables; there is no default
+if [ -n "$HLT_CONFIG" ]; then
+    log "Session config: $HLT_CONFIG"
+elif [ -z "$HLT_STORAGE_BACKEND" ]; then
+    log "ERROR: no artifact storage configured: pass a session config (HLT_CONFIG) or set HLT_STORAGE_BACKEND and its credentials"
+    exit 1
 fi
+log "Artifact storage: ${HLT_STORAGE_BACKEND:-from config}"
 
 # Verify binary exists
 if [ ! -f "./highLoadTest" ]; then
@@ -60,7 +65,7 @@ log "Binary permissions set"
 
 # Start the test with logging
 log "Starting highLoadTest process..."
-nohup ./highLoadTest -instance="$INSTANCE_ID" > /tmp/test_output.log 2>&1 &
+nohup ./highLoadTest -instance="$INSTANCE_ID" -run="$RUN_ID" > /tmp/test_output.log 2>&1 &
 TEST_PID=$!
 
 log "Test started with PID: $TEST_PID"
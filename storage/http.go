@@ -0,0 +1,197 @@
+package storage
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"sort"
+	"strings"
+	"time"
+)
+
+type HTTPConfig struct {
+	// Базовый URL, ключ добавляется к нему: PUT <url>/<key>
+	URL      string            `yaml:"url" json:"url"`
+	Token    string            `yaml:"token" json:"token"`
+	User     string            `yaml:"user" json:"user"`
+	Password string            `yaml:"password" json:"password"`
+	Headers  map[string]string `yaml:"headers" json:"headers"`
+	Timeout  time.Duration     `yaml:"timeout" json:"timeout"`
+}
+
+// HTTPStore загружает артефакты через HTTP PUT (например nginx с WebDAV).
+// Листинг ожидает JSON-индекс каталогов в формате nginx autoindex_format json.
+type HTTPStore struct {
+	cfg    HTTPConfig
+	base   *url.URL
+	client *http.Client
+}
+
+func NewHTTPStore(cfg HTTPConfig) (*HTTPStore, error) {
+	base, err := url.Parse(strings.TrimSuffix(cfg.URL, "/") + "/")
+	if err != nil || (base.Scheme != "http" && base.Scheme != "https") {
+		return nil, fmt.Errorf("invalid storage.http.url %q", cfg.URL)
+	}
+	if cfg.Timeout == 0 {
+		cfg.Timeout = 60 * time.Second
+	}
+	return &HTTPStore{
+		cfg:    cfg,
+		base:   base,
+		client: &http.Client{Timeout: cfg.Timeout},
+	}, nil
+}
+
+func (s *HTTPStore) String() string {
+	return s.base.Redacted()
+}
+
+func (s *HTTPStore) urlFor(key string) string {
+	parts := strings.Split(key, "/")
+	for i, p := range parts {
+		parts[i] = url.PathEscape(p)
+	}
+	return s.base.String() + strings.Join(parts, "/")
+}
+
+func (s *HTTPStore) newRequest(ctx context.Context, method, target string, body io.Reader) (*http.Request, error) {
+	req, err := http.NewRequestWithContext(ctx, method, target, body)
+	if err != nil {
+		return nil, err
+	}
+	if s.cfg.Token != "" {
+		req.Header.Set("Authorization", "Bearer "+s.cfg.Token)
+	} else if s.cfg.User != "" {
+		req.SetBasicAuth(s.cfg.User, s.cfg.Password)
+	}
+	for k, v := range s.cfg.Headers {
+		req.Header.Set(k, v)
+	}
+	return req, nil
+}
+
+func (s *HTTPStore) Put(ctx context.Context, key string, r io.Reader, size int64) error {
+	key, err := cleanKey(key)
+	if err != nil {
+		return err
+	}
+	req, err := s.newRequest(ctx, http.MethodPut, s.urlFor(key), r)
+	if err != nil {
+		return err
+	}
+	req.ContentLength = size
+	req.Header.Set("Content-Type", contentType(key))
+
+	resp, err := s.client.Do(req)
+	if err != nil {
+		return fmt.Errorf("failed to upload %s: %v", key, err)
+	}
+	defer resp.Body.Close()
+	io.Copy(io.Discard, resp.Body)
+
+	if resp.StatusCode >= 300 {
+		return fmt.Errorf("failed to upload %s: HTTP %d", key, resp.StatusCode)
+	}
+	return nil
+}
+
+func (s *HTTPStore) Get(ctx context.Context, key string) (io.ReadCloser, error) {
+	key, err := cleanKey(key)
+	if err != nil {
+		return nil, err
+	}
+	req, err := s.newRequest(ctx, http.MethodGet, s.urlFor(key), nil)
+	if err != nil {
+		return nil, err
+	}
+	resp, err := s.client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode == http.StatusNotFound {
+		resp.Body.Close()
+		return nil, fmt.Errorf("%s: %w", key, ErrNotFound)
+	}
+	if resp.StatusCode >= 300 {
+		resp.Body.Close()
+		return nil, fmt.Errorf("failed to download %s: HTTP %d", key, resp.StatusCode)
+	}
+	return resp.Body, nil
+}
+
+type autoindexEntry struct {
+	Name  string `json:"name"`
+	Type  string `json:"type"`
+	MTime string `json:"mtime"`
+	Size  int64  `json:"size"`
+}
+
+func (s *HTTPStore) List(ctx context.Context, prefix string) ([]ObjectInfo, error) {
+	prefix = cleanPrefix(prefix)
+	dir := ""
+	if i := strings.LastIndex(prefix, "/"); i >= 0 {
+		dir = prefix[:i+1]
+	}
+
+	var objects []ObjectInfo
+	if err := s.walk(ctx, dir, prefix, &objects); err != nil {
+		return nil, err
+	}
+	sort.Slice(objects, func(i, j int) bool { return objects[i].Key < objects[j].Key })
+	return objects, nil
+}
+
+func (s *HTTPStore) walk(ctx context.Context, dir, prefix string, out *[]ObjectInfo) error {
+	target := s.base.String()
+	if dir != "" {
+		target = s.urlFor(strings.TrimSuffix(dir, "/")) + "/"
+	}
+	req, err := s.newRequest(ctx, http.MethodGet, target, nil)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Accept", "application/json")
+
+	resp, err := s.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode == http.StatusNotFound {
+		return nil
+	}
+	if resp.StatusCode >= 300 {
+		return fmt.Errorf("failed to list %s: HTTP %d", target, resp.StatusCode)
+	}
+
+	var entries []autoindexEntry
+	if err := json.NewDecoder(resp.Body).Decode(&entries); err != nil {
+		return fmt.Errorf("failed to parse listing of %s (expected JSON autoindex): %v", target, err)
+	}
+
+	for _, e := range entries {
+		key := dir + e.Name
+		if e.Type == "directory" {
+			// Спускаемся только в каталоги, совместимые с префиксом
+			if strings.HasPrefix(key+"/", prefix) || strings.HasPrefix(prefix, key+"/") {
+				if err := s.walk(ctx, key+"/", prefix, out); err != nil {
+					return err
+				}
+			}
+			continue
+		}
+		if !strings.HasPrefix(key, prefix) {
+			continue
+		}
+		modTime, _ := time.Parse(time.RFC1123, e.MTime)
+		*out = append(*out, ObjectInfo{Key: key, Size: e.Size, ModTime: modTime})
+	}
+	return nil
+}
+
+func (s *HTTPStore) Close() error {
+	return nil
+}
@@ -0,0 +1,111 @@
+package storage
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+// newTestHTTPStorage - stand-in для nginx: PUT пишет файл в dir, GET каталога отдает
+// autoindex_format json, GET файла - содержимое. Без токена отвечает 401
+func newTestHTTPStorage(t *testing.T, token string) (*httptest.Server, string) {
+	t.Helper()
+	dir := t.TempDir()
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("Authorization") != "Bearer "+token {
+			http.Error(w, "unauthorized", http.StatusUnauthorized)
+			return
+		}
+		name := strings.TrimPrefix(path.Clean("/"+strings.TrimPrefix(r.URL.Path, "/files")), "/")
+		target := filepath.Join(dir, filepath.FromSlash(name))
+
+		switch {
+		case r.Method == http.MethodPut:
+			os.MkdirAll(filepath.Dir(target), 0755)
+			data, err := io.ReadAll(r.Body)
+			if err != nil {
+				http.Error(w, err.Error(), http.StatusBadRequest)
+				return
+			}
+			if err := os.WriteFile(target, data, 0644); err != nil {
+				http.Error(w, err.Error(), http.StatusInternalServerError)
+				return
+			}
+			w.WriteHeader(http.StatusCreated)
+		case r.Method == http.MethodGet && strings.HasSuffix(r.URL.Path, "/"):
+			entries, err := os.ReadDir(target)
+			if err != nil {
+				http.NotFound(w, r)
+				return
+			}
+			index := []autoindexEntry{}
+			for _, e := range entries {
+				info, _ := e.Info()
+				entry := autoindexEntry{Name: e.Name(), Type: "file", MTime: info.ModTime().UTC().Format(time.RFC1123), Size: info.Size()}
+				if e.IsDir() {
+					entry.Type, entry.Size = "directory", 0
+				}
+				index = append(index, entry)
+			}
+			json.NewEncoder(w).Encode(index)
+		case r.Method == http.MethodGet:
+			data, err := os.ReadFile(target)
+			if err != nil {
+				http.NotFound(w, r)
+				return
+			}
+			w.Write(data)
+		default:
+			http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		}
+	}))
+	t.Cleanup(srv.Close)
+	return srv, dir
+}
+
+func TestHTTPStore(t *testing.T) {
+	srv, dir := newTestHTTPStorage(t, "secret")
+	store, err := NewHTTPStore(HTTPConfig{URL: srv.URL + "/files", Token: "secret"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	testArtifactStore(t, store)
+
+	data, err := os.ReadFile(filepath.Join(dir, "session_1", "screenshots", "shot_001.png"))
+	if err != nil || string(data) != "png" {
+		t.Fatalf("screenshot on the server = %q, %v", data, err)
+	}
+	objects, err := store.List(context.Background(), "session_1/screenshots/")
+	if err != nil || len(objects) != 1 || objects[0].ModTime.IsZero() {
+		t.Fatalf("List = %v, %v; want one object with a modification time", objects, err)
+	}
+}
+
+func TestHTTPStoreReportsHTTPErrors(t *testing.T) {
+	srv, _ := newTestHTTPStorage(t, "secret")
+	store, err := NewHTTPStore(HTTPConfig{URL: srv.URL + "/files", Token: "wrong"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	ctx := context.Background()
+
+	err = PutBytes(ctx, store, "s/report.json", []byte("{}"))
+	if err == nil || !strings.Contains(err.Error(), "HTTP 401") {
+		t.Fatalf("Put: err = %v, want HTTP 401", err)
+	}
+	if _, err := store.List(ctx, "s/"); err == nil || !strings.Contains(err.Error(), "HTTP 401") {
+		t.Fatalf("List: err = %v, want HTTP 401", err)
+	}
+
+	if _, err := NewHTTPStore(HTTPConfig{URL: "ftp://storage.example.com"}); err == nil {
+		t.Fatal("NewHTTPStore accepted a non-http URL")
+	}
+}
@@ -0,0 +1,115 @@
+package storage
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+type LocalConfig struct {
+	Dir string `yaml:"dir" json:"dir"`
+}
+
+type LocalStore struct {
+	root string
+}
+
+func NewLocalStore(cfg LocalConfig) (*LocalStore, error) {
+	if err := os.MkdirAll(cfg.Dir, 0755); err != nil {
+		return nil, fmt.Errorf("could not create local storage dir %s: %v", cfg.Dir, err)
+	}
+	return &LocalStore{root: cfg.Dir}, nil
+}
+
+func (s *LocalStore) String() string {
+	return "local:" + s.root
+}
+
+func (s *LocalStore) Root() string {
+	return s.root
+}
+
+func (s *LocalStore) Put(ctx context.Context, key string, r io.Reader, size int64) error {
+	key, err := cleanKey(key)
+	if err != nil {
+		return err
+	}
+	target := filepath.Join(s.root, filepath.FromSlash(key))
+	if err := os.MkdirAll(filepath.Dir(target), 0755); err != nil {
+		return err
+	}
+
+	// Пишем во временный файл и переименовываем, чтобы не оставлять обрезанные артефакты
+	tmp, err := os.CreateTemp(filepath.Dir(target), ".upload-*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+
+	written, err := io.Copy(tmp, r)
+	if closeErr := tmp.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		return fmt.Errorf("failed to write %s: %v", key, err)
+	}
+	if size >= 0 && written != size {
+		return fmt.Errorf("short write for %s: %d of %d bytes", key, written, size)
+	}
+	return os.Rename(tmp.Name(), target)
+}
+
+func (s *LocalStore) Get(ctx context.Context, key string) (io.ReadCloser, error) {
+	key, err := cleanKey(key)
+	if err != nil {
+		return nil, err
+	}
+	f, err := os.Open(filepath.Join(s.root, filepath.FromSlash(key)))
+	if os.IsNotExist(err) {
+		return nil, fmt.Errorf("%s: %w", key, ErrNotFound)
+	}
+	return f, err
+}
+
+func (s *LocalStore) List(ctx context.Context, prefix string) ([]ObjectInfo, error) {
+	prefix = cleanPrefix(prefix)
+	var objects []ObjectInfo
+
+	err := filepath.WalkDir(s.root, func(p string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if d.IsDir() || strings.HasPrefix(d.Name(), ".upload-") {
+			return nil
+		}
+		rel, err := filepath.Rel(s.root, p)
+		if err != nil {
+			return err
+		}
+		key := filepath.ToSlash(rel)
+		if !strings.HasPrefix(key, prefix) {
+			return nil
+		}
+		info, err := d.Info()
+		if err != nil {
+			return err
+		}
+		objects = append(objects, ObjectInfo{Key: key, Size: info.Size(), ModTime: info.ModTime()})
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	sort.Slice(objects, func(i, j int) bool { return objects[i].Key < objects[j].Key })
+	return objects, nil
+}
+
+func (s *LocalStore) Close() error {
+	return nil
+}
@@ -0,0 +1,53 @@
+package storage
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestLocalStore(t *testing.T) {
+	root := filepath.Join(t.TempDir(), "artifacts")
+	store, err := NewLocalStore(LocalConfig{Dir: root})
+	if err != nil {
+		t.Fatal(err)
+	}
+	testArtifactStore(t, store)
+
+	// Ключ с ".." пишется внутрь корня, а не рядом с ним
+	if _, err := os.Stat(filepath.Join(root, "session_1", "notes.md")); err != nil {
+		t.Fatalf("cleaned key is not under the root: %v", err)
+	}
+	if _, err := os.Stat(filepath.Join(filepath.Dir(root), "session_1")); !os.IsNotExist(err) {
+		t.Fatalf("key escaped the root: %v", err)
+	}
+}
+
+func TestLocalStoreShortWriteKeepsOldFile(t *testing.T) {
+	store, err := NewLocalStore(LocalConfig{Dir: t.TempDir()})
+	if err != nil {
+		t.Fatal(err)
+	}
+	ctx := context.Background()
+	if err := PutBytes(ctx, store, "s/report.json", []byte("old")); err != nil {
+		t.Fatal(err)
+	}
+
+	err = store.Put(ctx, "s/report.json", strings.NewReader("new"), 10)
+	if err == nil || !strings.Contains(err.Error(), "short write") {
+		t.Fatalf("err = %v, want a short write", err)
+	}
+	data, _ := GetBytes(ctx, store, "s/report.json")
+	if string(data) != "old" {
+		t.Fatalf("report = %q after a failed write", data)
+	}
+
+	// Временные файлы не попадают в листинг
+	os.WriteFile(filepath.Join(store.Root(), "s", ".upload-123"), []byte("tmp"), 0644)
+	objects, err := store.List(ctx, "s/")
+	if err != nil || len(objects) != 1 {
+		t.Fatalf("List = %v, %v; want only the report", objects, err)
+	}
+}
@@ -0,0 +1,390 @@
+package storage
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"math/rand"
+	"os"
+	"path/filepath"
+	"sort"
+	"sync"
+	"time"
+)
+
+const journalFile = "upload_journal.json"
+
+type QueueConfig struct {
+	// Каталог для локальных копий артефактов и журнала (заполняется раннером)
+	Dir            string        `yaml:"-" json:"-"`
+	Concurrency    int           `yaml:"concurrency" json:"concurrency"`
+	MaxAttempts    int           `yaml:"max_attempts" json:"max_attempts"`
+	InitialBackoff time.Duration `yaml:"initial_backoff" json:"initial_backoff"`
+	MaxBackoff     time.Duration `yaml:"max_backoff" json:"max_backoff"`
+	Verify         bool          `yaml:"verify" json:"verify"`
+	FlushTimeout   time.Duration `yaml:"flush_timeout" json:"flush_timeout"`
+	KeepLocal      bool          `yaml:"keep_local" json:"keep_local"`
+}
+
+func DefaultQueueConfig() QueueConfig {
+	return QueueConfig{
+		Concurrency:    2,
+		InitialBackoff: 2 * time.Second,
+		MaxBackoff:     time.Minute,
+		Verify:         true,
+		FlushTimeout:   2 * time.Minute,
+		KeepLocal:      true,
+	}
+}
+
+func (c QueueConfig) Validate() error {
+	if c.Concurrency <= 0 {
+		return fmt.Errorf("upload.concurrency must be positive")
+	}
+	if c.MaxAttempts < 0 {
+		return fmt.Errorf("upload.max_attempts must not be negative")
+	}
+	if c.InitialBackoff <= 0 || c.MaxBackoff < c.InitialBackoff {
+		return fmt.Errorf("upload.initial_backoff must be positive and not exceed upload.max_backoff")
+	}
+	if c.FlushTimeout <= 0 {
+		return fmt.Errorf("upload.flush_timeout must be positive")
+	}
+	return nil
+}
+
+type queueEntry struct {
+	Key        string    `json:"key"`
+	SHA256     string    `json:"sha256"`
+	Size       int64     `json:"size"`
+	Attempts   int       `json:"attempts"`
+	LastError  string    `json:"last_error,omitempty"`
+	EnqueuedAt time.Time `json:"enqueued_at"`
+	Failed     bool      `json:"failed,omitempty"`
+}
+
+type QueueStats struct {
+	Pending   int `json:"pending"`
+	Uploaded  int `json:"uploaded"`
+	Failed    int `json:"failed"`
+	Retries   int `json:"retries"`
+	Recovered int `json:"recovered"`
+}
+
+// UploadQueue сначала сохраняет артефакт на диск и в журнал, затем загружает его
+// в хранилище в фоне с повторами. Незавершенные загрузки переживают перезапуск процесса.
+type UploadQueue struct {
+	store ArtifactStore
+	cfg   QueueConfig
+
+	mu      sync.Mutex
+	entries map[string]*queueEntry
+	// scheduled - ключи, которые ждут воркера или повтора либо загружаются; второй раз не планируются
+	scheduled map[string]bool
+	stats     QueueStats
+	changed   chan struct{}
+
+	ready chan string
+	quit  chan struct{}
+	wg    sync.WaitGroup
+}
+
+func NewUploadQueue(store ArtifactStore, cfg QueueConfig) (*UploadQueue, error) {
+	if err := cfg.Validate(); err != nil {
+		return nil, err
+	}
+	if err := os.MkdirAll(cfg.Dir, 0755); err != nil {
+		return nil, fmt.Errorf("could not create upload spool %s: %v", cfg.Dir, err)
+	}
+
+	q := &UploadQueue{
+		store:     store,
+		cfg:       cfg,
+		entries:   make(map[string]*queueEntry),
+		scheduled: make(map[string]bool),
+		changed:   make(chan struct{}),
+		ready:     make(chan string, 1024),
+		quit:      make(chan struct{}),
+	}
+
+	if err := q.loadJournal(); err != nil {
+		return nil, err
+	}
+	return q, nil
+}
+
+func (q *UploadQueue) journalPath() string {
+	return filepath.Join(q.cfg.Dir, journalFile)
+}
+
+func (q *UploadQueue) spoolPath(key string) string {
+	return filepath.Join(q.cfg.Dir, filepath.FromSlash(key))
+}
+
+func (q *UploadQueue) loadJournal() error {
+	data, err := os.ReadFile(q.journalPath())
+	if os.IsNotExist(err) {
+		return nil
+	}
+	if err != nil {
+		return fmt.Errorf("failed to read upload journal: %v", err)
+	}
+
+	var entries []*queueEntry
+	if err := json.Unmarshal(data, &entries); err != nil {
+		return fmt.Errorf("failed to parse upload journal %s: %v", q.journalPath(), err)
+	}
+	for _, e := range entries {
+		if _, err := os.Stat(q.spoolPath(e.Key)); err != nil {
+			log.Printf("Upload journal: local copy of %s is gone, dropping it", e.Key)
+			continue
+		}
+		// Исчерпанные попытки прошлого запуска пробуем снова
+		e.Failed = false
+		e.Attempts = 0
+		q.entries[e.Key] = e
+		q.stats.Recovered++
+	}
+	if q.stats.Recovered > 0 {
+		log.Printf("Upload journal: resuming %d pending uploads", q.stats.Recovered)
+	}
+	return q.saveJournalLocked()
+}
+
+// saveJournalLocked атомарно переписывает журнал (вызывать под q.mu)
+func (q *UploadQueue) saveJournalLocked() error {
+	entries := make([]*queueEntry, 0, len(q.entries))
+	for _, e := range q.entries {
+		entries = append(entries, e)
+	}
+	sort.Slice(entries, func(i, j int) bool { return entries[i].EnqueuedAt.Before(entries[j].EnqueuedAt) })
+
+	data, err := json.MarshalIndent(entries, "", "  ")
+	if err != nil {
+		return err
+	}
+	tmp := q.journalPath() + ".tmp"
+	if err := os.WriteFile(tmp, data, 0644); err != nil {
+		return err
+	}
+	return os.Rename(tmp, q.journalPath())
+}
+
+func (q *UploadQueue) notifyLocked() {
+	close(q.changed)
+	q.changed = make(chan struct{})
+}
+
+// Start запускает воркеры и ставит в очередь загрузки, восстановленные из журнала
+func (q *UploadQueue) Start() {
+	for i := 0; i < q.cfg.Concurrency; i++ {
+		q.wg.Add(1)
+		go q.worker()
+	}
+
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	for key := range q.entries {
+		q.schedule(key, 0)
+	}
+}
+
+func (q *UploadQueue) Enqueue(key string, data []byte) error {
+	key, err := cleanKey(key)
+	if err != nil {
+		return err
+	}
+	target := q.spoolPath(key)
+	if err := os.MkdirAll(filepath.Dir(target), 0755); err != nil {
+		return err
+	}
+	if err := os.WriteFile(target, data, 0644); err != nil {
+		return fmt.Errorf("failed to spool %s: %v", key, err)
+	}
+
+	sum := sha256.Sum256(data)
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	q.entries[key] = &queueEntry{
+		Key:        key,
+		SHA256:     hex.EncodeToString(sum[:]),
+		Size:       int64(len(data)),
+		EnqueuedAt: time.Now(),
+	}
+	if err := q.saveJournalLocked(); err != nil {
+		log.Printf("Warning: could not update upload journal: %v", err)
+	}
+	q.notifyLocked()
+	q.schedule(key, 0)
+	return nil
+}
+
+// schedule ставит ключ в очередь воркерам (вызывать под q.mu). Ключ, который уже запланирован
+// или загружается, не дублируется: перезаписанный во время загрузки артефакт process планирует сам
+func (q *UploadQueue) schedule(key string, delay time.Duration) {
+	if q.scheduled[key] {
+		return
+	}
+	q.scheduled[key] = true
+	send := func() {
+		select {
+		case q.ready <- key:
+		case <-q.quit:
+		}
+	}
+	if delay <= 0 {
+		go send()
+		return
+	}
+	time.AfterFunc(delay, send)
+}
+
+func (q *UploadQueue) backoff(attempt int) time.Duration {
+	d := q.cfg.InitialBackoff << uint(attempt-1)
+	if d <= 0 || d > q.cfg.MaxBackoff {
+		d = q.cfg.MaxBackoff
+	}
+	// Джиттер ±20%, чтобы воркеры не долбили хранилище синхронно
+	jitter := time.Duration(rand.Int63n(int64(d)/5+1)) - d/10
+	return d + jitter
+}
+
+func (q *UploadQueue) worker() {
+	defer q.wg.Done()
+	for {
+		select {
+		case <-q.quit:
+			return
+		case key := <-q.ready:
+			q.process(key)
+		}
+	}
+}
+
+func (q *UploadQueue) process(key string) {
+	q.mu.Lock()
+	entry, ok := q.entries[key]
+	if !ok || entry.Failed {
+		delete(q.scheduled, key)
+		q.mu.Unlock()
+		return
+	}
+	snapshot := *entry
+	q.mu.Unlock()
+
+	err := q.upload(snapshot)
+
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	delete(q.scheduled, key)
+	// Пока шла загрузка, артефакт могли перезаписать: тогда грузим заново
+	current, ok := q.entries[key]
+	if !ok {
+		return
+	}
+	if current.SHA256 != snapshot.SHA256 {
+		q.schedule(key, 0)
+		return
+	}
+
+	if err == nil {
+		delete(q.entries, key)
+		q.stats.Uploaded++
+		if !q.cfg.KeepLocal {
+			os.Remove(q.spoolPath(key))
+		}
+	} else {
+		current.Attempts++
+		current.LastError = err.Error()
+		if q.cfg.MaxAttempts > 0 && current.Attempts >= q.cfg.MaxAttempts {
+			current.Failed = true
+			q.stats.Failed++
+			log.Printf("Upload of %s failed permanently after %d attempts: %v", key, current.Attempts, err)
+		} else {
+			q.stats.Retries++
+			delay := q.backoff(current.Attempts)
+			log.Printf("Upload of %s failed (attempt %d): %v, retrying in %v", key, current.Attempts, err, delay.Round(time.Millisecond))
+			q.schedule(key, delay)
+		}
+	}
+	if err := q.saveJournalLocked(); err != nil {
+		log.Printf("Warning: could not update upload journal: %v", err)
+	}
+	q.notifyLocked()
+}
+
+func (q *UploadQueue) upload(entry queueEntry) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 2*time.Minute)
+	defer cancel()
+
+	if err := PutFile(ctx, q.store, entry.Key, q.spoolPath(entry.Key)); err != nil {
+		return err
+	}
+	if !q.cfg.Verify {
+		return nil
+	}
+
+	rc, err := q.store.Get(ctx, entry.Key)
+	if err != nil {
+		return fmt.Errorf("verification read failed: %v", err)
+	}
+	defer rc.Close()
+	h := sha256.New()
+	n, err := io.Copy(h, rc)
+	if err != nil {
+		return fmt.Errorf("verification read failed: %v", err)
+	}
+	if sum := hex.EncodeToString(h.Sum(nil)); sum != entry.SHA256 || n != entry.Size {
+		return fmt.Errorf("checksum mismatch: remote %s (%d bytes), local %s (%d bytes)", sum, n, entry.SHA256, entry.Size)
+	}
+	return nil
+}
+
+// Flush ждет, пока все загрузки завершатся или закончится контекст.
+// Окончательно упавшие загрузки не ждем, но возвращаем их в ошибке.
+func (q *UploadQueue) Flush(ctx context.Context) error {
+	for {
+		q.mu.Lock()
+		waiting, failed := 0, 0
+		for _, e := range q.entries {
+			if e.Failed {
+				failed++
+			} else {
+				waiting++
+			}
+		}
+		changed := q.changed
+		q.mu.Unlock()
+
+		if waiting == 0 {
+			if failed > 0 {
+				return fmt.Errorf("%d artifacts failed to upload and remain in %s", failed, q.cfg.Dir)
+			}
+			return nil
+		}
+
+		select {
+		case <-changed:
+		case <-ctx.Done():
+			return fmt.Errorf("%d artifacts still pending after flush deadline, kept in %s for resume", waiting+failed, q.cfg.Dir)
+		}
+	}
+}
+
+func (q *UploadQueue) Stats() QueueStats {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	stats := q.stats
+	stats.Pending = len(q.entries)
+	return stats
+}
+
+// Close останавливает воркеры; незавершенные загрузки остаются в журнале
+func (q *UploadQueue) Close() {
+	close(q.quit)
+	q.wg.Wait()
+}
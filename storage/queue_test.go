@@ -0,0 +1,130 @@
+package storage
+
+import (
+	"context"
+	"io"
+	"sync"
+	"testing"
+	"time"
+)
+
+// gatedStore считает Put по ключам и держит их, пока открыт gate
+type gatedStore struct {
+	ArtifactStore
+	gate    chan struct{}
+	started chan string
+
+	mu      sync.Mutex
+	puts    map[string]int
+	active  map[string]int
+	overlap bool
+}
+
+func newGatedStore(t *testing.T) *gatedStore {
+	local, err := NewLocalStore(LocalConfig{Dir: t.TempDir()})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return &gatedStore{
+		ArtifactStore: local,
+		gate:          make(chan struct{}),
+		started:       make(chan string, 16),
+		puts:          make(map[string]int),
+		active:        make(map[string]int),
+	}
+}
+
+func (s *gatedStore) Put(ctx context.Context, key string, r io.Reader, size int64) error {
+	s.mu.Lock()
+	s.puts[key]++
+	s.active[key]++
+	if s.active[key] > 1 {
+		s.overlap = true
+	}
+	s.mu.Unlock()
+	defer func() {
+		s.mu.Lock()
+		s.active[key]--
+		s.mu.Unlock()
+	}()
+
+	s.started <- key
+	<-s.gate
+	return s.ArtifactStore.Put(ctx, key, r, size)
+}
+
+func newTestQueue(t *testing.T, store ArtifactStore) *UploadQueue {
+	t.Helper()
+	cfg := DefaultQueueConfig()
+	cfg.Dir = t.TempDir()
+	cfg.Concurrency = 4
+	cfg.InitialBackoff = 10 * time.Millisecond
+	q, err := NewUploadQueue(store, cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	q.Start()
+	t.Cleanup(q.Close)
+	return q
+}
+
+func TestUploadQueueSkipsDuplicateSchedules(t *testing.T) {
+	store := newGatedStore(t)
+	q := newTestQueue(t, store)
+
+	if err := q.Enqueue("s/report.json", []byte("v1")); err != nil {
+		t.Fatal(err)
+	}
+	<-store.started
+	// Тот же артефакт снова, пока первая загрузка идет: свободные воркеры не должны его подхватить
+	for i := 0; i < 5; i++ {
+		if err := q.Enqueue("s/report.json", []byte("v1")); err != nil {
+			t.Fatal(err)
+		}
+	}
+	time.Sleep(50 * time.Millisecond)
+	close(store.gate)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	if err := q.Flush(ctx); err != nil {
+		t.Fatal(err)
+	}
+	store.mu.Lock()
+	defer store.mu.Unlock()
+	if store.puts["s/report.json"] != 1 || store.overlap {
+		t.Fatalf("puts = %d, concurrent uploads of one key: %v; want a single upload", store.puts["s/report.json"], store.overlap)
+	}
+}
+
+func TestUploadQueueReuploadsArtifactChangedInFlight(t *testing.T) {
+	store := newGatedStore(t)
+	q := newTestQueue(t, store)
+
+	if err := q.Enqueue("s/report.json", []byte("running")); err != nil {
+		t.Fatal(err)
+	}
+	<-store.started
+	if err := q.Enqueue("s/report.json", []byte("completed")); err != nil {
+		t.Fatal(err)
+	}
+	close(store.gate)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	if err := q.Flush(ctx); err != nil {
+		t.Fatal(err)
+	}
+	data, err := GetBytes(ctx, store, "s/report.json")
+	if err != nil || string(data) != "completed" {
+		t.Fatalf("stored report = %q, %v; want the newer version", data, err)
+	}
+	store.mu.Lock()
+	defer store.mu.Unlock()
+	if store.puts["s/report.json"] != 2 || store.overlap {
+		t.Fatalf("puts = %d, overlapping: %v; want two sequential uploads", store.puts["s/report.json"], store.overlap)
+	}
+	if stats := q.Stats(); stats.Pending != 0 || stats.Uploaded != 1 {
+		t.Fatalf("stats = %+v", stats)
+	}
+}
@@ -0,0 +1,150 @@
+package storage
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"path"
+	"strings"
+
+	"github.com/minio/minio-go/v7"
+	"github.com/minio/minio-go/v7/pkg/credentials"
+)
+
+type S3Config struct {
+	Endpoint  string `yaml:"endpoint" json:"endpoint"`
+	Bucket    string `yaml:"bucket" json:"bucket"`
+	Region    string `yaml:"region" json:"region"`
+	AccessKey string `yaml:"access_key" json:"access_key"`
+	SecretKey string `yaml:"secret_key" json:"secret_key"`
+	Prefix    string `yaml:"prefix" json:"prefix"`
+	UseSSL    bool   `yaml:"use_ssl" json:"use_ssl"`
+	// Создавать бакет при старте (удобно для локального MinIO)
+	CreateBucket bool `yaml:"create_bucket" json:"create_bucket"`
+}
+
+type S3Store struct {
+	cfg    S3Config
+	client *minio.Client
+}
+
+func NewS3Store(ctx context.Context, cfg S3Config) (*S3Store, error) {
+	endpoint := cfg.Endpoint
+	secure := cfg.UseSSL
+	switch {
+	case strings.HasPrefix(endpoint, "https://"):
+		endpoint, secure = strings.TrimPrefix(endpoint, "https://"), true
+	case strings.HasPrefix(endpoint, "http://"):
+		endpoint, secure = strings.TrimPrefix(endpoint, "http://"), false
+	}
+
+	client, err := minio.New(endpoint, &minio.Options{
+		Creds:  credentials.NewStaticV4(cfg.AccessKey, cfg.SecretKey, ""),
+		Secure: secure,
+		Region: cfg.Region,
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to create S3 client for %s: %v", cfg.Endpoint, err)
+	}
+
+	exists, err := client.BucketExists(ctx, cfg.Bucket)
+	if err != nil {
+		return nil, fmt.Errorf("failed to check bucket %s: %v", cfg.Bucket, err)
+	}
+	if !exists {
+		if !cfg.CreateBucket {
+			return nil, fmt.Errorf("bucket %s does not exist", cfg.Bucket)
+		}
+		if err := client.MakeBucket(ctx, cfg.Bucket, minio.MakeBucketOptions{Region: cfg.Region}); err != nil {
+			return nil, fmt.Errorf("failed to create bucket %s: %v", cfg.Bucket, err)
+		}
+	}
+
+	return &S3Store{cfg: cfg, client: client}, nil
+}
+
+func (s *S3Store) String() string {
+	return fmt.Sprintf("s3://%s/%s", s.cfg.Bucket, s.cfg.Prefix)
+}
+
+func (s *S3Store) objectName(key string) string {
+	if s.cfg.Prefix == "" {
+		return key
+	}
+	return path.Join(s.cfg.Prefix, key)
+}
+
+func (s *S3Store) Put(ctx context.Context, key string, r io.Reader, size int64) error {
+	key, err := cleanKey(key)
+	if err != nil {
+		return err
+	}
+	_, err = s.client.PutObject(ctx, s.cfg.Bucket, s.objectName(key), r, size, minio.PutObjectOptions{
+		ContentType: contentType(key),
+	})
+	if err != nil {
+		return fmt.Errorf("failed to upload %s: %v", key, err)
+	}
+	return nil
+}
+
+func (s *S3Store) Get(ctx context.Context, key string) (io.ReadCloser, error) {
+	key, err := cleanKey(key)
+	if err != nil {
+		return nil, err
+	}
+	obj, err := s.client.GetObject(ctx, s.cfg.Bucket, s.objectName(key), minio.GetObjectOptions{})
+	if err != nil {
+		return nil, err
+	}
+	// GetObject ленивый, ошибка 404 видна только после Stat
+	if _, err := obj.Stat(); err != nil {
+		obj.Close()
+		if minio.ToErrorResponse(err).Code == "NoSuchKey" {
+			return nil, fmt.Errorf("%s: %w", key, ErrNotFound)
+		}
+		return nil, err
+	}
+	return obj, nil
+}
+
+func (s *S3Store) List(ctx context.Context, prefix string) ([]ObjectInfo, error) {
+	prefix = cleanPrefix(prefix)
+	fullPrefix := prefix
+	if s.cfg.Prefix != "" {
+		fullPrefix = strings.TrimSuffix(s.cfg.Prefix, "/") + "/" + prefix
+	}
+
+	var objects []ObjectInfo
+	for obj := range s.client.ListObjects(ctx, s.cfg.Bucket, minio.ListObjectsOptions{Prefix: fullPrefix, Recursive: true}) {
+		if obj.Err != nil {
+			return nil, obj.Err
+		}
+		key := obj.Key
+		if s.cfg.Prefix != "" {
+			key = strings.TrimPrefix(key, strings.TrimSuffix(s.cfg.Prefix, "/")+"/")
+		}
+		objects = append(objects, ObjectInfo{Key: key, Size: obj.Size, ModTime: obj.LastModified})
+	}
+	return objects, nil
+}
+
+func (s *S3Store) Close() error {
+	return nil
+}
+
+func contentType(key string) string {
+	switch path.Ext(key) {
+	case ".png":
+		return "image/png"
+	case ".json":
+		return "application/json"
+	case ".md":
+		return "text/markdown; charset=utf-8"
+	case ".html":
+		return "text/html; charset=utf-8"
+	case ".csv":
+		return "text/csv; charset=utf-8"
+	}
+	return "application/octet-stream"
+}
@@ -0,0 +1,176 @@
+package storage
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"crypto/md5"
+	"encoding/hex"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// testS3Server - минимальный S3 в памяти для minio-go: бакеты, PUT/GET/HEAD объектов
+// и ListObjectsV2. Подписи не проверяются
+type testS3Server struct {
+	mu      sync.Mutex
+	buckets map[string]map[string]testS3Object
+}
+
+type testS3Object struct {
+	data    []byte
+	modTime time.Time
+}
+
+func newTestS3Server(t *testing.T) (*httptest.Server, *testS3Server) {
+	t.Helper()
+	s3 := &testS3Server{buckets: make(map[string]map[string]testS3Object)}
+	srv := httptest.NewServer(s3)
+	t.Cleanup(srv.Close)
+	return srv, s3
+}
+
+func (s *testS3Server) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	bucket, key, _ := strings.Cut(strings.TrimPrefix(r.URL.Path, "/"), "/")
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	objects, exists := s.buckets[bucket]
+
+	switch {
+	case key == "" && r.URL.Query().Has("location"):
+		fmt.Fprint(w, `<?xml version="1.0" encoding="UTF-8"?><LocationConstraint xmlns="http://s3.amazonaws.com/doc/2006-03-01/"></LocationConstraint>`)
+	case key == "" && r.Method == http.MethodHead:
+		if !exists {
+			w.WriteHeader(http.StatusNotFound)
+		}
+	case key == "" && r.Method == http.MethodPut:
+		if !exists {
+			s.buckets[bucket] = make(map[string]testS3Object)
+		}
+	case !exists:
+		writeS3Error(w, http.StatusNotFound, "NoSuchBucket")
+	case key == "" && r.Method == http.MethodGet:
+		s.list(w, objects, r.URL.Query().Get("prefix"))
+	case r.Method == http.MethodPut:
+		data, err := readS3Body(r)
+		if err != nil {
+			writeS3Error(w, http.StatusBadRequest, "IncompleteBody")
+			return
+		}
+		objects[key] = testS3Object{data: data, modTime: time.Now().UTC()}
+		w.Header().Set("ETag", etag(data))
+	case r.Method == http.MethodGet || r.Method == http.MethodHead:
+		obj, ok := objects[key]
+		if !ok {
+			writeS3Error(w, http.StatusNotFound, "NoSuchKey")
+			return
+		}
+		w.Header().Set("ETag", etag(obj.data))
+		w.Header().Set("Content-Type", "application/octet-stream")
+		http.ServeContent(w, r, key, obj.modTime, bytes.NewReader(obj.data))
+	default:
+		writeS3Error(w, http.StatusMethodNotAllowed, "MethodNotAllowed")
+	}
+}
+
+func (s *testS3Server) list(w http.ResponseWriter, objects map[string]testS3Object, prefix string) {
+	type content struct {
+		Key          string
+		LastModified string
+		ETag         string
+		Size         int64
+	}
+	result := struct {
+		XMLName     xml.Name `xml:"ListBucketResult"`
+		Prefix      string
+		KeyCount    int
+		IsTruncated bool
+		Contents    []content
+	}{Prefix: prefix}
+	for key, obj := range objects {
+		if strings.HasPrefix(key, prefix) {
+			result.Contents = append(result.Contents, content{
+				Key:          key,
+				LastModified: obj.modTime.Format(time.RFC3339),
+				ETag:         etag(obj.data),
+				Size:         int64(len(obj.data)),
+			})
+		}
+	}
+	sort.Slice(result.Contents, func(i, j int) bool { return result.Contents[i].Key < result.Contents[j].Key })
+	result.KeyCount = len(result.Contents)
+	w.Header().Set("Content-Type", "application/xml")
+	xml.NewEncoder(w).Encode(result)
+}
+
+// readS3Body снимает aws-chunked кодирование, которым minio-go шлет PUT без TLS
+func readS3Body(r *http.Request) ([]byte, error) {
+	if !strings.HasPrefix(r.Header.Get("X-Amz-Content-Sha256"), "STREAMING-") {
+		return io.ReadAll(r.Body)
+	}
+	var data []byte
+	br := bufio.NewReader(r.Body)
+	for {
+		line, err := br.ReadString('\n')
+		if err != nil {
+			return nil, err
+		}
+		sizeHex, _, _ := strings.Cut(strings.TrimSpace(line), ";")
+		size, err := strconv.ParseInt(sizeHex, 16, 64)
+		if err != nil {
+			return nil, err
+		}
+		if size == 0 {
+			return data, nil
+		}
+		chunk := make([]byte, size+2) // данные и \r\n
+		if _, err := io.ReadFull(br, chunk); err != nil {
+			return nil, err
+		}
+		data = append(data, chunk[:size]...)
+	}
+}
+
+func writeS3Error(w http.ResponseWriter, status int, code string) {
+	w.Header().Set("Content-Type", "application/xml")
+	w.WriteHeader(status)
+	fmt.Fprintf(w, `<?xml version="1.0" encoding="UTF-8"?><Error><Code>%s</Code><Message>%s</Message></Error>`, code, code)
+}
+
+func etag(data []byte) string {
+	sum := md5.Sum(data)
+	return `"` + hex.EncodeToString(sum[:]) + `"`
+}
+
+func TestS3Store(t *testing.T) {
+	srv, s3 := newTestS3Server(t)
+	cfg := S3Config{Endpoint: srv.URL, Bucket: "sessions", AccessKey: "test", SecretKey: "test", Prefix: "load"}
+	ctx := context.Background()
+
+	if _, err := NewS3Store(ctx, cfg); err == nil || !strings.Contains(err.Error(), "does not exist") {
+		t.Fatalf("missing bucket: err = %v", err)
+	}
+	cfg.CreateBucket = true
+	store, err := NewS3Store(ctx, cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	testArtifactStore(t, store)
+
+	// Объекты лежат под префиксом из конфига, а ключи в листинге - без него
+	s3.mu.Lock()
+	_, ok := s3.buckets["sessions"]["load/session_1/session_report.json"]
+	s3.mu.Unlock()
+	if !ok {
+		t.Fatal("object is not stored under the configured prefix")
+	}
+}
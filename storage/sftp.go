@@ -0,0 +1,329 @@
+package storage
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"path"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/pkg/sftp"
+	"golang.org/x/crypto/ssh"
+	"golang.org/x/crypto/ssh/knownhosts"
+)
+
+type SFTPConfig struct {
+	Host       string `yaml:"host" json:"host"`
+	Port       int    `yaml:"port" json:"port"`
+	User       string `yaml:"user" json:"user"`
+	Password   string `yaml:"password" json:"password"`
+	KeyFile    string `yaml:"key_file" json:"key_file"`
+	KnownHosts string `yaml:"known_hosts" json:"known_hosts"`
+	Dir        string `yaml:"dir" json:"dir"`
+}
+
+// SFTPStore держит одно SSH соединение, каждая операция открывает на нем свою SFTP сессию.
+// При обрыве соединения операция переподключается и повторяется один раз
+type SFTPStore struct {
+	cfg       SFTPConfig
+	sshConfig *ssh.ClientConfig
+
+	mu   sync.Mutex
+	conn *ssh.Client
+}
+
+func NewSFTPStore(ctx context.Context, cfg SFTPConfig) (*SFTPStore, error) {
+	if cfg.Port == 0 {
+		cfg.Port = 22
+	}
+
+	var auth []ssh.AuthMethod
+	if cfg.KeyFile != "" {
+		keyData, err := os.ReadFile(cfg.KeyFile)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read SFTP key %s: %v", cfg.KeyFile, err)
+		}
+		signer, err := ssh.ParsePrivateKey(keyData)
+		if err != nil {
+			return nil, fmt.Errorf("failed to parse SFTP key %s: %v", cfg.KeyFile, err)
+		}
+		auth = append(auth, ssh.PublicKeys(signer))
+	}
+	if cfg.Password != "" {
+		auth = append(auth, ssh.Password(cfg.Password))
+	}
+
+	// Без known_hosts ведем себя как раньше (StrictHostKeyChecking=no)
+	hostKeyCallback := ssh.InsecureIgnoreHostKey()
+	if cfg.KnownHosts != "" {
+		cb, err := knownhosts.New(cfg.KnownHosts)
+		if err != nil {
+			return nil, fmt.Errorf("failed to load known_hosts %s: %v", cfg.KnownHosts, err)
+		}
+		hostKeyCallback = cb
+	}
+
+	sshConfig := &ssh.ClientConfig{
+		User:            cfg.User,
+		Auth:            auth,
+		HostKeyCallback: hostKeyCallback,
+		Timeout:         30 * time.Second,
+	}
+
+	s := &SFTPStore{cfg: cfg, sshConfig: sshConfig}
+	if err := s.dialLocked(ctx); err != nil {
+		return nil, err
+	}
+	return s, nil
+}
+
+// dialLocked открывает SSH соединение и проверяет, что сервер дает SFTP
+// (вызывать под s.mu или до первого использования)
+func (s *SFTPStore) dialLocked(ctx context.Context) error {
+	addr := net.JoinHostPort(s.cfg.Host, strconv.Itoa(s.cfg.Port))
+	dialer := net.Dialer{Timeout: s.sshConfig.Timeout}
+	netConn, err := dialer.DialContext(ctx, "tcp", addr)
+	if err != nil {
+		return fmt.Errorf("failed to connect to %s: %v", addr, err)
+	}
+	c, chans, reqs, err := ssh.NewClientConn(netConn, addr, s.sshConfig)
+	if err != nil {
+		netConn.Close()
+		return fmt.Errorf("SSH handshake with %s failed: %v", addr, err)
+	}
+	conn := ssh.NewClient(c, chans, reqs)
+
+	client, err := sftp.NewClient(conn)
+	if err != nil {
+		conn.Close()
+		return fmt.Errorf("failed to start SFTP session: %v", err)
+	}
+	client.Close()
+	s.conn = conn
+	return nil
+}
+
+// redial переподключается, если broken все еще текущее соединение: параллельные операции
+// на одном оборванном соединении переподключаются один раз
+func (s *SFTPStore) redial(ctx context.Context, broken *ssh.Client) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.conn != broken {
+		return nil
+	}
+	s.conn.Close()
+	return s.dialLocked(ctx)
+}
+
+// connectionLost - ошибка оборванного соединения, после которой имеет смысл переподключиться
+func connectionLost(err error) bool {
+	return errors.Is(err, sftp.ErrSSHFxConnectionLost) || errors.Is(err, io.EOF) ||
+		errors.Is(err, io.ErrUnexpectedEOF) || errors.Is(err, net.ErrClosed)
+}
+
+// do выполняет op в своей SFTP сессии и закрывает ее
+func (s *SFTPStore) do(ctx context.Context, op func(client *sftp.Client, retry bool) error) error {
+	client, err := s.session(ctx, op)
+	if err == nil {
+		client.Close()
+	}
+	return err
+}
+
+// session открывает SFTP сессию на общем соединении и выполняет в ней op. SFTP не знает
+// о контексте, поэтому отмена ctx закрывает сессию этой операции, а соединение и операции
+// в других сессиях не трогает. При обрыве соединения op повторяется один раз на новом.
+// После успешного op сессия остается открытой и закрывается вызывающим
+func (s *SFTPStore) session(ctx context.Context, op func(client *sftp.Client, retry bool) error) (*sftp.Client, error) {
+	ran := false // op уже выполнялся и мог прочитать часть данных
+	for retry := false; ; retry = true {
+		if err := ctx.Err(); err != nil {
+			return nil, err
+		}
+		s.mu.Lock()
+		conn := s.conn
+		s.mu.Unlock()
+
+		// Сессия не открылась - соединение оборвано, как бы ssh ни назвал ошибку
+		client, err := sftp.NewClient(conn)
+		lost := err != nil
+		if err == nil {
+			stop := context.AfterFunc(ctx, func() { client.Close() })
+			err = op(client, ran)
+			ran = true
+			if stop() && err == nil {
+				return client, nil
+			}
+			client.Close()
+			lost = connectionLost(err)
+		}
+		if ctxErr := ctx.Err(); ctxErr != nil {
+			return nil, ctxErr
+		}
+		if retry || !lost {
+			return nil, err
+		}
+		if rerr := s.redial(ctx, conn); rerr != nil {
+			return nil, fmt.Errorf("%v; reconnect failed: %v", err, rerr)
+		}
+	}
+}
+
+func (s *SFTPStore) String() string {
+	return fmt.Sprintf("sftp://%s@%s/%s", s.cfg.User, s.cfg.Host, s.cfg.Dir)
+}
+
+func (s *SFTPStore) remotePath(key string) string {
+	if s.cfg.Dir == "" {
+		return key
+	}
+	return path.Join(s.cfg.Dir, key)
+}
+
+func (s *SFTPStore) Put(ctx context.Context, key string, r io.Reader, size int64) error {
+	key, err := cleanKey(key)
+	if err != nil {
+		return err
+	}
+	target := s.remotePath(key)
+	err = s.do(ctx, func(client *sftp.Client, retry bool) error {
+		if retry {
+			// Повтор после обрыва: читаем артефакт заново с начала
+			seeker, ok := r.(io.Seeker)
+			if !ok {
+				return fmt.Errorf("connection lost and the source cannot be rewound")
+			}
+			if _, err := seeker.Seek(0, io.SeekStart); err != nil {
+				return err
+			}
+		}
+		return s.put(client, target, r, size)
+	})
+	if err != nil {
+		return fmt.Errorf("failed to upload %s: %w", key, err)
+	}
+	return nil
+}
+
+func (s *SFTPStore) put(client *sftp.Client, target string, r io.Reader, size int64) error {
+	if err := client.MkdirAll(path.Dir(target)); err != nil {
+		return fmt.Errorf("failed to create remote dir: %w", err)
+	}
+
+	tmp := target + ".part"
+	f, err := client.Create(tmp)
+	if err != nil {
+		return fmt.Errorf("failed to create %s: %w", tmp, err)
+	}
+	written, err := f.ReadFrom(r)
+	if closeErr := f.Close(); err == nil {
+		err = closeErr
+	}
+	if err == nil && size >= 0 && written != size {
+		err = fmt.Errorf("short write: %d of %d bytes", written, size)
+	}
+	if err != nil {
+		client.Remove(tmp)
+		return err
+	}
+
+	// PosixRename перезаписывает существующий файл, обычный Rename на SFTP v3 - нет
+	if err := client.PosixRename(tmp, target); err != nil {
+		client.Remove(target)
+		if err := client.Rename(tmp, target); err != nil {
+			return fmt.Errorf("failed to finalize: %w", err)
+		}
+	}
+	return nil
+}
+
+func (s *SFTPStore) Get(ctx context.Context, key string) (io.ReadCloser, error) {
+	key, err := cleanKey(key)
+	if err != nil {
+		return nil, err
+	}
+	var f *sftp.File
+	client, err := s.session(ctx, func(client *sftp.Client, retry bool) error {
+		f, err = client.Open(s.remotePath(key))
+		return err
+	})
+	if os.IsNotExist(err) {
+		return nil, fmt.Errorf("%s: %w", key, ErrNotFound)
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &sftpReader{File: f, client: client}, nil
+}
+
+// sftpReader закрывает вместе с файлом сессию, в которой он открыт
+type sftpReader struct {
+	*sftp.File
+	client *sftp.Client
+}
+
+func (r *sftpReader) Close() error {
+	err := r.File.Close()
+	r.client.Close()
+	return err
+}
+
+func (s *SFTPStore) List(ctx context.Context, prefix string) ([]ObjectInfo, error) {
+	prefix = cleanPrefix(prefix)
+	root := s.remotePath("")
+	if root == "" {
+		root = "."
+	}
+
+	// Начинаем обход с ближайшего каталога префикса, чтобы не сканировать все хранилище
+	start := root
+	if dir := path.Dir(prefix); prefix != "" && dir != "." {
+		start = path.Join(root, dir)
+	}
+
+	var objects []ObjectInfo
+	err := s.do(ctx, func(client *sftp.Client, retry bool) error {
+		objects = nil
+		walker := client.Walk(start)
+		for walker.Step() {
+			if err := walker.Err(); err != nil {
+				if os.IsNotExist(err) && walker.Path() == start {
+					return nil
+				}
+				return err
+			}
+			info := walker.Stat()
+			if info.IsDir() || strings.HasSuffix(info.Name(), ".part") {
+				continue
+			}
+			key := strings.TrimPrefix(strings.TrimPrefix(walker.Path(), root), "/")
+			if root == "." {
+				key = strings.TrimPrefix(walker.Path(), "./")
+			}
+			if !strings.HasPrefix(key, prefix) {
+				continue
+			}
+			objects = append(objects, ObjectInfo{Key: key, Size: info.Size(), ModTime: info.ModTime()})
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	sort.Slice(objects, func(i, j int) bool { return objects[i].Key < objects[j].Key })
+	return objects, nil
+}
+
+func (s *SFTPStore) Close() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.conn.Close()
+}
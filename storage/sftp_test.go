@@ -0,0 +1,299 @@
+package storage
+
+import (
+	"context"
+	"crypto/ed25519"
+	"crypto/rand"
+	"errors"
+	"io"
+	"net"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/pkg/sftp"
+	"golang.org/x/crypto/ssh"
+	"golang.org/x/crypto/ssh/knownhosts"
+)
+
+// testSFTPServer - SSH сервер с подсистемой sftp в процессе, корень - dir, вход по паролю
+type testSFTPServer struct {
+	host string
+	port int
+	dir  string
+	key  ssh.Signer
+
+	mu    sync.Mutex
+	conns []net.Conn
+}
+
+func startTestSFTPServer(t *testing.T, user, password string) *testSFTPServer {
+	t.Helper()
+	_, priv, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	hostKey, err := ssh.NewSignerFromKey(priv)
+	if err != nil {
+		t.Fatal(err)
+	}
+	config := &ssh.ServerConfig{
+		PasswordCallback: func(c ssh.ConnMetadata, pass []byte) (*ssh.Permissions, error) {
+			if c.User() != user || string(pass) != password {
+				return nil, errors.New("access denied")
+			}
+			return nil, nil
+		},
+	}
+	config.AddHostKey(hostKey)
+
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	srv := &testSFTPServer{host: "127.0.0.1", port: ln.Addr().(*net.TCPAddr).Port, dir: t.TempDir(), key: hostKey}
+	t.Cleanup(func() {
+		ln.Close()
+		srv.dropConnections()
+	})
+	go func() {
+		for {
+			conn, err := ln.Accept()
+			if err != nil {
+				return
+			}
+			srv.mu.Lock()
+			srv.conns = append(srv.conns, conn)
+			srv.mu.Unlock()
+			go srv.serve(conn, config)
+		}
+	}()
+	return srv
+}
+
+func (s *testSFTPServer) serve(conn net.Conn, config *ssh.ServerConfig) {
+	sconn, chans, reqs, err := ssh.NewServerConn(conn, config)
+	if err != nil {
+		conn.Close()
+		return
+	}
+	defer sconn.Close()
+	go ssh.DiscardRequests(reqs)
+	for newCh := range chans {
+		if newCh.ChannelType() != "session" {
+			newCh.Reject(ssh.UnknownChannelType, "only sessions")
+			continue
+		}
+		ch, requests, err := newCh.Accept()
+		if err != nil {
+			continue
+		}
+		go func() {
+			defer ch.Close()
+			for req := range requests {
+				var payload struct{ Name string }
+				ssh.Unmarshal(req.Payload, &payload)
+				if req.Type != "subsystem" || payload.Name != "sftp" {
+					req.Reply(false, nil)
+					continue
+				}
+				req.Reply(true, nil)
+				server, err := sftp.NewServer(ch, sftp.WithServerWorkingDirectory(s.dir))
+				if err != nil {
+					return
+				}
+				server.Serve()
+				return
+			}
+		}()
+	}
+}
+
+// dropConnections рвет все открытые соединения, как при обрыве сети
+func (s *testSFTPServer) dropConnections() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for _, c := range s.conns {
+		c.Close()
+	}
+	s.conns = nil
+}
+
+func (s *testSFTPServer) config(password string) SFTPConfig {
+	return SFTPConfig{Host: s.host, Port: s.port, User: "storage", Password: password, Dir: "files"}
+}
+
+func TestSFTPStore(t *testing.T) {
+	srv := startTestSFTPServer(t, "storage", "secret")
+	store, err := NewSFTPStore(context.Background(), srv.config("secret"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer store.Close()
+	testArtifactStore(t, store)
+
+	data, err := os.ReadFile(filepath.Join(srv.dir, "files", "session_1", "session_report.json"))
+	if err != nil || string(data) != `{"status":"completed"}` {
+		t.Fatalf("report on the server = %q, %v", data, err)
+	}
+	// Недокачанные .part файлы не попадают в листинг
+	os.WriteFile(filepath.Join(srv.dir, "files", "session_2", "shot.png.part"), []byte("x"), 0644)
+	objects, err := store.List(context.Background(), "session_2/")
+	if err != nil || len(objects) != 1 {
+		t.Fatalf("List = %v, %v; want only the report", objects, err)
+	}
+}
+
+func TestSFTPStoreRejectsBadCredentialsAndHostKey(t *testing.T) {
+	srv := startTestSFTPServer(t, "storage", "secret")
+	ctx := context.Background()
+
+	_, err := NewSFTPStore(ctx, srv.config("wrong"))
+	if err == nil || !strings.Contains(err.Error(), "handshake") {
+		t.Fatalf("wrong password: err = %v, want a handshake error", err)
+	}
+
+	// known_hosts с чужим ключом для этого адреса
+	other, _, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	otherKey, err := ssh.NewPublicKey(other)
+	if err != nil {
+		t.Fatal(err)
+	}
+	knownHosts := filepath.Join(t.TempDir(), "known_hosts")
+	addr := knownhosts.Normalize(net.JoinHostPort(srv.host, strconv.Itoa(srv.port)))
+	line := knownhosts.Line([]string{addr}, otherKey)
+	if err := os.WriteFile(knownHosts, []byte(line+"\n"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	cfg := srv.config("secret")
+	cfg.KnownHosts = knownHosts
+	if _, err := NewSFTPStore(ctx, cfg); err == nil {
+		t.Fatal("connected to a host with a key that is not in known_hosts")
+	}
+
+	line = knownhosts.Line([]string{addr}, srv.key.PublicKey())
+	if err := os.WriteFile(knownHosts, []byte(line+"\n"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	store, err := NewSFTPStore(ctx, cfg)
+	if err != nil {
+		t.Fatalf("connect with the right known_hosts: %v", err)
+	}
+	store.Close()
+}
+
+func TestSFTPStoreRedialsAfterConnectionLoss(t *testing.T) {
+	srv := startTestSFTPServer(t, "storage", "secret")
+	store, err := NewSFTPStore(context.Background(), srv.config("secret"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer store.Close()
+	ctx := context.Background()
+
+	if err := PutBytes(ctx, store, "s/one.png", []byte("one")); err != nil {
+		t.Fatal(err)
+	}
+	for _, op := range []string{"put", "get", "list"} {
+		srv.dropConnections()
+		switch op {
+		case "put":
+			err = PutBytes(ctx, store, "s/two.png", []byte("two"))
+		case "get":
+			var data []byte
+			data, err = GetBytes(ctx, store, "s/one.png")
+			if err == nil && string(data) != "one" {
+				t.Fatalf("Get after reconnect = %q", data)
+			}
+		case "list":
+			var objects []ObjectInfo
+			objects, err = store.List(ctx, "s/")
+			if err == nil && len(objects) != 2 {
+				t.Fatalf("List after reconnect = %v", objects)
+			}
+		}
+		if err != nil {
+			t.Fatalf("%s after the connection was dropped: %v", op, err)
+		}
+	}
+}
+
+// endlessReader отдает данные понемногу и бесконечно, как очень медленный источник
+type endlessReader struct{}
+
+func (endlessReader) Read(p []byte) (int, error) {
+	time.Sleep(time.Millisecond)
+	n := copy(p, strings.Repeat("x", 512))
+	return n, nil
+}
+
+func TestSFTPStorePutRespectsContext(t *testing.T) {
+	srv := startTestSFTPServer(t, "storage", "secret")
+	store, err := NewSFTPStore(context.Background(), srv.config("secret"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer store.Close()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 200*time.Millisecond)
+	defer cancel()
+	start := time.Now()
+	err = store.Put(ctx, "s/endless.bin", endlessReader{}, -1)
+	if !errors.Is(err, context.DeadlineExceeded) {
+		t.Fatalf("err = %v, want the context deadline", err)
+	}
+	if elapsed := time.Since(start); elapsed > 5*time.Second {
+		t.Fatalf("Put returned %v after the deadline", elapsed)
+	}
+	if _, err := os.Stat(filepath.Join(srv.dir, "files", "s", "endless.bin")); !os.IsNotExist(err) {
+		t.Fatalf("cancelled upload was renamed into place: %v", err)
+	}
+
+	if err := PutBytes(context.Background(), store, "s/next.png", []byte("next")); err != nil {
+		t.Fatalf("Put after a cancelled upload: %v", err)
+	}
+}
+
+// TestSFTPStoreCancelDoesNotAbortOtherOperations: отмена одной загрузки закрывает только ее
+// сессию; параллельная загрузка на том же соединении доходит до конца без переподключения
+func TestSFTPStoreCancelDoesNotAbortOtherOperations(t *testing.T) {
+	srv := startTestSFTPServer(t, "storage", "secret")
+	store, err := NewSFTPStore(context.Background(), srv.config("secret"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer store.Close()
+
+	// Медленный, но конечный источник: загрузка идет и во время, и после отмены соседней
+	slow := io.LimitReader(endlessReader{}, 256*1024)
+	done := make(chan error, 1)
+	go func() {
+		done <- store.Put(context.Background(), "s/slow.bin", slow, 256*1024)
+	}()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+	defer cancel()
+	if err := store.Put(ctx, "s/endless.bin", endlessReader{}, -1); !errors.Is(err, context.DeadlineExceeded) {
+		t.Fatalf("cancelled Put: err = %v, want the context deadline", err)
+	}
+	if err := <-done; err != nil {
+		t.Fatalf("concurrent Put failed after another Put was cancelled: %v", err)
+	}
+	info, err := os.Stat(filepath.Join(srv.dir, "files", "s", "slow.bin"))
+	if err != nil || info.Size() != 256*1024 {
+		t.Fatalf("slow.bin: %v, %v", info, err)
+	}
+	srv.mu.Lock()
+	conns := len(srv.conns)
+	srv.mu.Unlock()
+	if conns != 1 {
+		t.Fatalf("store opened %d connections, want 1: the cancel must not close the shared connection", conns)
+	}
+}
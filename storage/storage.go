@@ -0,0 +1,161 @@
+package storage
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net/url"
+	"os"
+	"path"
+	"strings"
+	"time"
+)
+
+const (
+	BackendLocal = "local"
+	BackendSFTP  = "sftp"
+	BackendS3    = "s3"
+	BackendHTTP  = "http"
+)
+
+var ErrNotFound = errors.New("artifact not found")
+
+// ArtifactStore хранит артефакты сессий по ключам вида "<session_id>/<file>"
+type ArtifactStore interface {
+	Put(ctx context.Context, key string, r io.Reader, size int64) error
+	Get(ctx context.Context, key string) (io.ReadCloser, error)
+	List(ctx context.Context, prefix string) ([]ObjectInfo, error)
+	Close() error
+	String() string
+}
+
+type ObjectInfo struct {
+	Key     string    `json:"key"`
+	Size    int64     `json:"size"`
+	ModTime time.Time `json:"mod_time"`
+}
+
+type Config struct {
+	Backend string      `yaml:"backend" json:"backend"`
+	Local   LocalConfig `yaml:"local" json:"local"`
+	SFTP    SFTPConfig  `yaml:"sftp" json:"sftp"`
+	S3      S3Config    `yaml:"s3" json:"s3"`
+	HTTP    HTTPConfig  `yaml:"http" json:"http"`
+}
+
+func (c Config) Validate() error {
+	switch c.Backend {
+	case BackendLocal:
+		if c.Local.Dir == "" {
+			return fmt.Errorf("storage.local.dir is required")
+		}
+	case BackendSFTP:
+		if c.SFTP.Host == "" || c.SFTP.User == "" {
+			return fmt.Errorf("storage.sftp.host and storage.sftp.user are required")
+		}
+		if c.SFTP.Password == "" && c.SFTP.KeyFile == "" {
+			return fmt.Errorf("storage.sftp.password or storage.sftp.key_file is required")
+		}
+	case BackendS3:
+		if c.S3.Endpoint == "" || c.S3.Bucket == "" {
+			return fmt.Errorf("storage.s3.endpoint and storage.s3.bucket are required")
+		}
+	case BackendHTTP:
+		if c.HTTP.URL == "" {
+			return fmt.Errorf("storage.http.url is required")
+		}
+	case "":
+		return fmt.Errorf("storage.backend is required")
+	default:
+		return fmt.Errorf("unknown storage backend %q (expected local, sftp, s3 or http)", c.Backend)
+	}
+	return nil
+}
+
+// Target описывает, куда пишет хранилище, в том же виде, что String открытого хранилища
+func (c Config) Target() string {
+	switch c.Backend {
+	case BackendLocal:
+		return "local:" + c.Local.Dir
+	case BackendSFTP:
+		return fmt.Sprintf("sftp://%s@%s/%s", c.SFTP.User, c.SFTP.Host, c.SFTP.Dir)
+	case BackendS3:
+		return fmt.Sprintf("s3://%s/%s", c.S3.Bucket, c.S3.Prefix)
+	case BackendHTTP:
+		if u, err := url.Parse(c.HTTP.URL); err == nil {
+			return u.Redacted()
+		}
+		return c.HTTP.URL
+	}
+	return c.Backend
+}
+
+func New(ctx context.Context, cfg Config) (ArtifactStore, error) {
+	if err := cfg.Validate(); err != nil {
+		return nil, err
+	}
+	switch cfg.Backend {
+	case BackendLocal:
+		return NewLocalStore(cfg.Local)
+	case BackendSFTP:
+		return NewSFTPStore(ctx, cfg.SFTP)
+	case BackendS3:
+		return NewS3Store(ctx, cfg.S3)
+	case BackendHTTP:
+		return NewHTTPStore(cfg.HTTP)
+	}
+	return nil, fmt.Errorf("unknown storage backend %q", cfg.Backend)
+}
+
+func PutBytes(ctx context.Context, store ArtifactStore, key string, data []byte) error {
+	return store.Put(ctx, key, bytes.NewReader(data), int64(len(data)))
+}
+
+func PutFile(ctx context.Context, store ArtifactStore, key, filePath string) error {
+	f, err := os.Open(filePath)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	info, err := f.Stat()
+	if err != nil {
+		return err
+	}
+	return store.Put(ctx, key, f, info.Size())
+}
+
+func GetBytes(ctx context.Context, store ArtifactStore, key string) ([]byte, error) {
+	rc, err := store.Get(ctx, key)
+	if err != nil {
+		return nil, err
+	}
+	defer rc.Close()
+	return io.ReadAll(rc)
+}
+
+// cleanKey приводит ключ к виду "a/b/c" и запрещает выход за корень хранилища
+func cleanKey(key string) (string, error) {
+	cleaned := path.Clean("/" + strings.ReplaceAll(key, "\\", "/"))
+	cleaned = strings.TrimPrefix(cleaned, "/")
+	if cleaned == "" || cleaned == "." {
+		return "", fmt.Errorf("empty artifact key %q", key)
+	}
+	return cleaned, nil
+}
+
+func cleanPrefix(prefix string) string {
+	if prefix == "" {
+		return ""
+	}
+	cleaned, err := cleanKey(prefix)
+	if err != nil {
+		return ""
+	}
+	if strings.HasSuffix(prefix, "/") {
+		cleaned += "/"
+	}
+	return cleaned
+}
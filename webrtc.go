@@ -0,0 +1,309 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"time"
+
+	"github.com/playwright-community/playwright-go"
+)
+
+const streamStatsName = "webrtc_stats.json"
+
+// streamHookScript выполняется до скриптов страницы и запоминает все RTCPeerConnection,
+// чтобы потом опрашивать их getStats()
+const streamHookScript = `(() => {
+	if (window.__hltPeerConnections || !window.RTCPeerConnection) return;
+	const connections = [];
+	window.__hltPeerConnections = connections;
+	const Native = window.RTCPeerConnection;
+	function Hooked(...args) {
+		const pc = new Native(...args);
+		connections.push(pc);
+		return pc;
+	}
+	Hooked.prototype = Native.prototype;
+	Object.setPrototypeOf(Hooked, Native);
+	window.RTCPeerConnection = Hooked;
+	if (window.webkitRTCPeerConnection) window.webkitRTCPeerConnection = Hooked;
+})()`
+
+// streamStatsScript собирает счетчики входящих видеопотоков и RTT активной пары кандидатов
+const streamStatsScript = `async () => {
+	const out = { connections: 0, video: [], rtt: [] };
+	for (const pc of window.__hltPeerConnections || []) {
+		if (pc.connectionState === 'closed') continue;
+		out.connections++;
+		const report = await pc.getStats();
+		report.forEach(s => {
+			if (s.type === 'inbound-rtp' && (s.kind || s.mediaType) === 'video') {
+				out.video.push({
+					frames_decoded: s.framesDecoded || 0,
+					frames_dropped: s.framesDropped || 0,
+					frames_per_second: s.framesPerSecond || 0,
+					jitter: s.jitter || 0,
+					packets_lost: s.packetsLost || 0,
+					packets_received: s.packetsReceived || 0,
+					bytes_received: s.bytesReceived || 0,
+					frame_width: s.frameWidth || 0,
+					frame_height: s.frameHeight || 0,
+				});
+			} else if (s.type === 'candidate-pair' && s.nominated && s.state === 'succeeded' && s.currentRoundTripTime !== undefined) {
+				out.rtt.push(s.currentRoundTripTime);
+			}
+		});
+	}
+	return out;
+}`
+
+type rawVideoStats struct {
+	FramesDecoded   int64   `json:"frames_decoded"`
+	FramesDropped   int64   `json:"frames_dropped"`
+	FramesPerSecond float64 `json:"frames_per_second"`
+	Jitter          float64 `json:"jitter"`
+	PacketsLost     int64   `json:"packets_lost"`
+	PacketsReceived int64   `json:"packets_received"`
+	BytesReceived   int64   `json:"bytes_received"`
+	FrameWidth      int     `json:"frame_width"`
+	FrameHeight     int     `json:"frame_height"`
+}
+
+type rawStreamStats struct {
+	Connections int             `json:"connections"`
+	Video       []rawVideoStats `json:"video"`
+	RTT         []float64       `json:"rtt"`
+}
+
+// StreamSample - одна точка временного ряда; скорости считаются за интервал с предыдущей точки
+type StreamSample struct {
+	Time            time.Time `json:"time"`
+	Connections     int       `json:"connections"`
+	VideoStreams    int       `json:"video_streams"`
+	FramesDecoded   int64     `json:"frames_decoded"`
+	FramesDropped   int64     `json:"frames_dropped"`
+	FPS             float64   `json:"fps"`
+	JitterMs        float64   `json:"jitter_ms"`
+	PacketsLost     int64     `json:"packets_lost"`
+	PacketsReceived int64     `json:"packets_received"`
+	PacketLossPct   float64   `json:"packet_loss_pct"`
+	RTTMs           float64   `json:"rtt_ms"`
+	BitrateKbps     float64   `json:"bitrate_kbps"`
+	Width           int       `json:"width"`
+	Height          int       `json:"height"`
+}
+
+type ResolutionChange struct {
+	Time   time.Time `json:"time"`
+	Width  int       `json:"width"`
+	Height int       `json:"height"`
+}
+
+// StreamSummary - сводка качества стрима для отчета сессии
+type StreamSummary struct {
+	Samples           int                `json:"samples"`
+	VideoSamples      int                `json:"video_samples"`
+	PollErrors        int                `json:"poll_errors"`
+	AvgFPS            float64            `json:"avg_fps"`
+	MinFPS            float64            `json:"min_fps"`
+	FramesDecoded     int64              `json:"frames_decoded"`
+	FramesDropped     int64              `json:"frames_dropped"`
+	DroppedFramesPct  float64            `json:"dropped_frames_pct"`
+	AvgJitterMs       float64            `json:"avg_jitter_ms"`
+	MaxJitterMs       float64            `json:"max_jitter_ms"`
+	PacketLossPct     float64            `json:"packet_loss_pct"`
+	AvgRTTMs          float64            `json:"avg_rtt_ms"`
+	MaxRTTMs          float64            `json:"max_rtt_ms"`
+	AvgBitrateKbps    float64            `json:"avg_bitrate_kbps"`
+	Resolution        string             `json:"resolution,omitempty"`
+	ResolutionChanges []ResolutionChange `json:"resolution_changes"`
+	// FirstFrameAt - первая точка с framesDecoded > 0; nil - ни одного декодированного кадра
+	FirstFrameAt *time.Time `json:"first_frame_at,omitempty"`
+}
+
+// StreamStatsCollector опрашивает WebRTC getStats() на странице и копит временной ряд
+type StreamStatsCollector struct {
+	page    playwright.Page
+	timeout time.Duration
+
+	samples    []StreamSample
+	changes    []ResolutionChange
+	pollErrors int
+
+	havePrev bool
+	prevRaw  rawVideoStats
+	prevTime time.Time
+}
+
+// NewStreamStatsCollector ставит хук на RTCPeerConnection; вызывать до навигации
+func NewStreamStatsCollector(page playwright.Page, timeout time.Duration) (*StreamStatsCollector, error) {
+	script := streamHookScript
+	if err := page.AddInitScript(playwright.Script{Content: &script}); err != nil {
+		return nil, fmt.Errorf("could not install WebRTC hook: %v", err)
+	}
+	return &StreamStatsCollector{
+		page:    page,
+		timeout: timeout,
+		samples: []StreamSample{},
+		changes: []ResolutionChange{},
+	}, nil
+}
+
+// Poll снимает одну точку временного ряда
+func (c *StreamStatsCollector) Poll() (*StreamSample, error) {
+	value, err := evaluateWithTimeout(c.page, streamStatsScript, c.timeout)
+	if err != nil {
+		c.pollErrors++
+		return nil, err
+	}
+	data, err := json.Marshal(value)
+	if err != nil {
+		c.pollErrors++
+		return nil, err
+	}
+	var raw rawStreamStats
+	if err := json.Unmarshal(data, &raw); err != nil {
+		c.pollErrors++
+		return nil, fmt.Errorf("unexpected getStats result: %v", err)
+	}
+	sample := c.record(raw, time.Now())
+	return &sample, nil
+}
+
+// record добавляет в ряд точку по снимку getStats, снятому в now
+func (c *StreamStatsCollector) record(raw rawStreamStats, now time.Time) StreamSample {
+	sample := StreamSample{Time: now, Connections: raw.Connections, VideoStreams: len(raw.Video)}
+
+	// Несколько видеопотоков суммируем, разрешение берем у самого большого
+	var total rawVideoStats
+	for _, v := range raw.Video {
+		total.FramesDecoded += v.FramesDecoded
+		total.FramesDropped += v.FramesDropped
+		total.PacketsLost += v.PacketsLost
+		total.PacketsReceived += v.PacketsReceived
+		total.BytesReceived += v.BytesReceived
+		if v.FramesPerSecond > total.FramesPerSecond {
+			total.FramesPerSecond = v.FramesPerSecond
+		}
+		if v.Jitter > total.Jitter {
+			total.Jitter = v.Jitter
+		}
+		if v.FrameWidth*v.FrameHeight > total.FrameWidth*total.FrameHeight {
+			total.FrameWidth, total.FrameHeight = v.FrameWidth, v.FrameHeight
+		}
+	}
+	sample.FramesDecoded = total.FramesDecoded
+	sample.FramesDropped = total.FramesDropped
+	sample.PacketsLost = total.PacketsLost
+	sample.PacketsReceived = total.PacketsReceived
+	sample.JitterMs = total.Jitter * 1000
+	sample.Width, sample.Height = total.FrameWidth, total.FrameHeight
+	for _, rtt := range raw.RTT {
+		if rtt*1000 > sample.RTTMs {
+			sample.RTTMs = rtt * 1000
+		}
+	}
+
+	// Скорости считаем по приращениям; уменьшение счетчиков значит, что соединение пересоздано.
+	// Пока приращений нет, берем мгновенный framesPerSecond из браузера
+	sample.FPS = total.FramesPerSecond
+	if c.havePrev && len(raw.Video) > 0 {
+		dt := now.Sub(c.prevTime).Seconds()
+		dFrames := total.FramesDecoded - c.prevRaw.FramesDecoded
+		dBytes := total.BytesReceived - c.prevRaw.BytesReceived
+		dLost := total.PacketsLost - c.prevRaw.PacketsLost
+		dReceived := total.PacketsReceived - c.prevRaw.PacketsReceived
+		if dt > 0 && dFrames >= 0 && dBytes >= 0 {
+			sample.FPS = float64(dFrames) / dt
+			sample.BitrateKbps = float64(dBytes) * 8 / 1000 / dt
+		}
+		if dLost >= 0 && dReceived >= 0 && dLost+dReceived > 0 {
+			sample.PacketLossPct = float64(dLost) / float64(dLost+dReceived) * 100
+		}
+	}
+
+	if sample.Width > 0 && (len(c.changes) == 0 || c.changes[len(c.changes)-1].Width != sample.Width ||
+		c.changes[len(c.changes)-1].Height != sample.Height) {
+		c.changes = append(c.changes, ResolutionChange{Time: now, Width: sample.Width, Height: sample.Height})
+	}
+
+	c.samples = append(c.samples, sample)
+	if len(raw.Video) > 0 {
+		c.havePrev = true
+		c.prevRaw = total
+		c.prevTime = now
+	}
+	return sample
+}
+
+// Summary сворачивает временной ряд; точки без посчитанных скоростей не входят в средние FPS и битрейт
+func (c *StreamStatsCollector) Summary() *StreamSummary {
+	s := &StreamSummary{
+		Samples:           len(c.samples),
+		PollErrors:        c.pollErrors,
+		ResolutionChanges: c.changes,
+	}
+
+	var fpsSum, jitterSum, rttSum, bitrateSum float64
+	var rateSamples, rttSamples int
+	var last *StreamSample
+	for i := range c.samples {
+		sample := &c.samples[i]
+		if sample.VideoStreams == 0 {
+			continue
+		}
+		s.VideoSamples++
+		last = sample
+		if s.FirstFrameAt == nil && sample.FramesDecoded > 0 {
+			at := sample.Time
+			s.FirstFrameAt = &at
+		}
+		jitterSum += sample.JitterMs
+		if sample.JitterMs > s.MaxJitterMs {
+			s.MaxJitterMs = sample.JitterMs
+		}
+		if sample.RTTMs > 0 {
+			rttSum += sample.RTTMs
+			rttSamples++
+			if sample.RTTMs > s.MaxRTTMs {
+				s.MaxRTTMs = sample.RTTMs
+			}
+		}
+		if sample.FPS > 0 || sample.BitrateKbps > 0 {
+			fpsSum += sample.FPS
+			bitrateSum += sample.BitrateKbps
+			if rateSamples == 0 || sample.FPS < s.MinFPS {
+				s.MinFPS = sample.FPS
+			}
+			rateSamples++
+		}
+	}
+	if last == nil {
+		return s
+	}
+
+	s.AvgJitterMs = jitterSum / float64(s.VideoSamples)
+	if rttSamples > 0 {
+		s.AvgRTTMs = rttSum / float64(rttSamples)
+	}
+	if rateSamples > 0 {
+		s.AvgFPS = fpsSum / float64(rateSamples)
+		s.AvgBitrateKbps = bitrateSum / float64(rateSamples)
+	}
+	s.FramesDecoded = last.FramesDecoded
+	s.FramesDropped = last.FramesDropped
+	if total := last.FramesDecoded + last.FramesDropped; total > 0 {
+		s.DroppedFramesPct = float64(last.FramesDropped) / float64(total) * 100
+	}
+	if total := last.PacketsLost + last.PacketsReceived; total > 0 {
+		s.PacketLossPct = float64(last.PacketsLost) / float64(total) * 100
+	}
+	if last.Width > 0 {
+		s.Resolution = fmt.Sprintf("%dx%d", last.Width, last.Height)
+	}
+	return s
+}
+
+// JSON возвращает временной ряд для артефакта webrtc_stats.json
+func (c *StreamStatsCollector) JSON() ([]byte, error) {
+	return json.MarshalIndent(c.samples, "", "  ")
+}
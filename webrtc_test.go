@@ -0,0 +1,61 @@
+package main
+
+import (
+	"math"
+	"testing"
+	"time"
+)
+
+func TestStreamStatsRatesAcrossCounterReset(t *testing.T) {
+	start := time.Now()
+	video := func(decoded, bytes, lost, received int64, fps float64, w, h int) rawStreamStats {
+		return rawStreamStats{Connections: 1, Video: []rawVideoStats{{
+			FramesDecoded: decoded, FramesPerSecond: fps, Jitter: 0.01, BytesReceived: bytes,
+			PacketsLost: lost, PacketsReceived: received, FrameWidth: w, FrameHeight: h,
+		}}}
+	}
+	cases := []struct {
+		name               string
+		at                 float64 // секунды от начала
+		raw                rawStreamStats
+		fps, kbps, lossPct float64
+	}{
+		// Приращений еще нет: FPS из браузера, битрейта и потерь нет
+		{"first snapshot", 0, video(100, 1_000_000, 10, 990, 29, 1280, 720), 29, 0, 0},
+		// 120 кадров, 500 КБ, 10 из 1000 пакетов потеряны за 2 с
+		{"deltas", 2, video(220, 1_500_000, 20, 1980, 31, 1280, 720), 60, 2000, 1},
+		// Соединение пересоздано, счетчики сбросились: отрицательные приращения не считаются
+		{"counter reset", 3, video(30, 100_000, 0, 100, 30, 1920, 1080), 30, 0, 0},
+		// Дальше приращения снова от нового соединения
+		{"after reset", 4, video(90, 350_000, 5, 195, 30, 1920, 1080), 60, 2000, 5},
+	}
+	near := func(got, want float64) bool { return math.Abs(got-want) < 1e-9 }
+
+	c := &StreamStatsCollector{samples: []StreamSample{}, changes: []ResolutionChange{}}
+	for _, tc := range cases {
+		at := start.Add(time.Duration(tc.at * float64(time.Second)))
+		s := c.record(tc.raw, at)
+		if !near(s.FPS, tc.fps) || !near(s.BitrateKbps, tc.kbps) || !near(s.PacketLossPct, tc.lossPct) {
+			t.Errorf("%s: fps %v, bitrate %v kbps, loss %v%%; want %v, %v, %v",
+				tc.name, s.FPS, s.BitrateKbps, s.PacketLossPct, tc.fps, tc.kbps, tc.lossPct)
+		}
+	}
+
+	sum := c.Summary()
+	if sum.Samples != 4 || sum.VideoSamples != 4 {
+		t.Fatalf("samples %d, video samples %d; want 4, 4", sum.Samples, sum.VideoSamples)
+	}
+	if !near(sum.AvgFPS, (29+60+30+60)/4.0) || sum.MinFPS != 29 || !near(sum.AvgBitrateKbps, 1000) {
+		t.Errorf("avg fps %v, min fps %v, avg bitrate %v", sum.AvgFPS, sum.MinFPS, sum.AvgBitrateKbps)
+	}
+	// Итоговые счетчики - последнего соединения
+	if sum.FramesDecoded != 90 || !near(sum.PacketLossPct, 2.5) || !near(sum.AvgJitterMs, 10) {
+		t.Errorf("frames %d, loss %v%%, jitter %v ms", sum.FramesDecoded, sum.PacketLossPct, sum.AvgJitterMs)
+	}
+	if sum.Resolution != "1920x1080" || len(sum.ResolutionChanges) != 2 {
+		t.Errorf("resolution %s, changes %v", sum.Resolution, sum.ResolutionChanges)
+	}
+	if sum.FirstFrameAt == nil || !sum.FirstFrameAt.Equal(start) {
+		t.Errorf("first frame at %v, want %v", sum.FirstFrameAt, start)
+	}
+}